@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	mongodriver "go.mongodb.org/mongo-driver/mongo"
+
+	"DB-Presentation/models"
+	"DB-Presentation/storage"
+
+	dbmongo "DB-Presentation/database/mongo"
+	dbsqlite "DB-Presentation/database/sqlite"
+)
+
+// mongoTestClient connects to TEST_MONGO_URI, skipping the test when it is unset.
+// The tests use the chat database, which is dropped before and after each of them.
+func mongoTestClient(t *testing.T) *mongodriver.Client {
+	t.Helper()
+	uri := os.Getenv("TEST_MONGO_URI")
+	if uri == "" {
+		t.Skip("TEST_MONGO_URI is not set")
+	}
+	mc, err := dbmongo.Connect(uri)
+	if err != nil {
+		t.Fatal(err)
+	}
+	drop := func() {
+		if err := mc.Database("chat").Drop(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	drop()
+	t.Cleanup(func() {
+		drop()
+		mc.Disconnect(context.Background())
+	})
+	return mc
+}
+
+// mongoStore returns a function building the store main uses with MONGO_URI set:
+// SQLite with an outbox, mirrored to mc by a running relay.
+func mongoStore(t *testing.T, mc *mongodriver.Client) func(d *sql.DB) storage.MessageStore {
+	return func(d *sql.DB) storage.MessageStore {
+		if err := dbmongo.EnsureMessageIndex(context.Background(), mc); err != nil {
+			t.Fatal(err)
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		t.Cleanup(cancel)
+		relay := dbmongo.NewRelay(mc, d)
+		go relay.Run(ctx)
+		return dbmongo.NewMessageStore(mc, dbsqlite.NewMessageStore(d, true), relay)
+	}
+}
+
+// waitForRelay waits until every queued change has been copied to Mongo, so that
+// reads are served from there.
+func (api *testAPI) waitForRelay() {
+	api.t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		pending, err := dbsqlite.OutboxPending(api.db, 0)
+		if err != nil {
+			api.t.Fatal(err)
+		}
+		if !pending {
+			return
+		}
+		if time.Now().After(deadline) {
+			api.t.Fatal("the relay did not empty the outbox")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestUnreadBadgesMongo(t *testing.T) {
+	api := newTestAPI(t, mongoStore(t, mongoTestClient(t)))
+	checkUnreadBadges(t, api, api.waitForRelay)
+}
+
+func TestReconcileRepairsMongo(t *testing.T) {
+	mc := mongoTestClient(t)
+	api := newTestAPI(t, mongoStore(t, mc))
+	alice, bob := api.register("alice"), api.register("bob")
+	api.befriend(alice, bob)
+
+	var sent []models.Message
+	for i := 0; i < 4; i++ {
+		var msg models.Message
+		req := map[string]interface{}{"recipient_id": alice.id, "message": "hello " + strconv.Itoa(i)}
+		api.call("POST", "/api/messages", bob.token, req, http.StatusCreated, &msg)
+		sent = append(sent, msg)
+	}
+	api.waitForRelay()
+
+	ctx := context.Background()
+	report, err := dbmongo.Reconcile(ctx, mc, api.db, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Checked != 4 || report.Diverged() {
+		t.Fatalf("before drifting: %+v", report)
+	}
+
+	// Drift the copy behind the relay's back: one document of each kind of difference
+	coll := mc.Database("chat").Collection("messages")
+	drift := []func() error{
+		func() error {
+			_, err := coll.DeleteOne(ctx, bson.M{"id": sent[0].ID})
+			return err
+		},
+		func() error {
+			_, err := coll.UpdateOne(ctx, bson.M{"id": sent[1].ID}, bson.M{"$set": bson.M{"is_read": true}})
+			return err
+		},
+		func() error {
+			_, err := coll.UpdateOne(ctx, bson.M{"id": sent[2].ID}, bson.M{"$set": bson.M{"message": "tampered"}})
+			return err
+		},
+		func() error {
+			_, err := coll.InsertOne(ctx, bson.M{"sender_id": bob.id, "recipient_id": alice.id, "message": "stray"})
+			return err
+		},
+	}
+	for _, f := range drift {
+		if err := f(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	report, err = dbmongo.Reconcile(ctx, mc, api.db, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := dbmongo.ReconcileReport{Checked: 4, Missing: 1, ReadState: 1, Content: 1, Extra: 1}
+	if report != want {
+		t.Errorf("drifted: got %+v, want %+v", report, want)
+	}
+
+	// What cmd/reconcile -repair runs
+	if _, err := dbmongo.Reconcile(ctx, mc, api.db, true); err != nil {
+		t.Fatal(err)
+	}
+	report, err = dbmongo.Reconcile(ctx, mc, api.db, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Diverged() {
+		t.Errorf("after repair: %+v", report)
+	}
+
+	// Reads served from Mongo see the repaired copy
+	var history []models.Message
+	api.call("GET", "/api/messages/"+strconv.Itoa(bob.id), alice.token, nil, http.StatusOK, &history)
+	if len(history) != 4 {
+		t.Fatalf("got %d messages, want 4", len(history))
+	}
+	for _, m := range history {
+		if m.Message == "tampered" || m.Message == "stray" {
+			t.Errorf("message %d still reads %q", m.ID, m.Message)
+		}
+	}
+}
@@ -0,0 +1,355 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/gorilla/mux"
+
+	"DB-Presentation/auth"
+	"DB-Presentation/db"
+	"DB-Presentation/handlers"
+	"DB-Presentation/models"
+	"DB-Presentation/storage"
+	"DB-Presentation/ws"
+
+	dbsqlite "DB-Presentation/database/sqlite"
+)
+
+// testAPI serves the HTTP API over a fresh SQLite database, with messages kept in
+// the store it was started with.
+type testAPI struct {
+	*httptest.Server
+	t  *testing.T
+	db *sql.DB
+}
+
+// testUser is a registered, logged in user.
+type testUser struct {
+	id    int
+	name  string
+	token string
+}
+
+// newTestAPI starts the API with the message store newStore returns for its database.
+func newTestAPI(t *testing.T, newStore func(d *sql.DB) storage.MessageStore) *testAPI {
+	t.Helper()
+	dir := t.TempDir()
+	d, err := db.OpenDB(filepath.Join(dir, "chat.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.Close() })
+	if err := db.RunMigrations(d); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("SESSION_SECRET", "test-secret")
+	auth.Init(d)
+	ws.Init(d)
+
+	blobs, err := storage.NewFSStore(filepath.Join(dir, "blobs"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	handlers.SetBlobStore(blobs)
+	handlers.SetMessageStore(newStore(d))
+
+	router := mux.NewRouter()
+	handlers.RegisterRoutes(router, d)
+	api := &testAPI{Server: httptest.NewServer(router), t: t, db: d}
+	t.Cleanup(api.Close)
+	return api
+}
+
+// call sends body as JSON and decodes the response's data into out, if given. It
+// fails the test unless the status is the wanted one.
+func (api *testAPI) call(method, path, token string, body interface{}, want int, out interface{}) {
+	api.t.Helper()
+	var buf bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			api.t.Fatal(err)
+		}
+	}
+	req, err := http.NewRequest(method, api.URL+path, &buf)
+	if err != nil {
+		api.t.Fatal(err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		api.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var res struct {
+		Message string          `json:"message"`
+		Data    json.RawMessage `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		api.t.Fatalf("%s %s: %v", method, path, err)
+	}
+	if resp.StatusCode != want {
+		api.t.Fatalf("%s %s: status %d (%s), want %d", method, path, resp.StatusCode, res.Message, want)
+	}
+	if out != nil {
+		if err := json.Unmarshal(res.Data, out); err != nil {
+			api.t.Fatalf("%s %s: %v", method, path, err)
+		}
+	}
+}
+
+// register creates and logs in a user whose password is "password".
+func (api *testAPI) register(username string) testUser {
+	api.t.Helper()
+	creds := map[string]string{"username": username, "password": "password"}
+	api.call("POST", "/api/register", "", creds, http.StatusCreated, nil)
+
+	var login struct {
+		UserID int    `json:"user_id"`
+		Token  string `json:"token"`
+	}
+	api.call("POST", "/api/login", "", creds, http.StatusOK, &login)
+	return testUser{id: login.UserID, name: username, token: login.Token}
+}
+
+// befriend makes a and b friends.
+func (api *testAPI) befriend(a, b testUser) {
+	api.t.Helper()
+	api.call("POST", "/api/friends/request", a.token, map[string]string{"username": b.name}, http.StatusOK, nil)
+	var requests []struct {
+		ID int `json:"id"`
+	}
+	api.call("GET", "/api/friends/requests", b.token, nil, http.StatusOK, &requests)
+	if len(requests) != 1 {
+		api.t.Fatalf("got %d friend requests, want 1", len(requests))
+	}
+	api.call("POST", "/api/friends/accept/"+strconv.Itoa(requests[0].ID), b.token, nil, http.StatusOK, nil)
+}
+
+// unread returns the total from /api/messages/unread and the per-friend badges
+// from /api/friends.
+func (api *testAPI) unread(u testUser) (int, map[int]int) {
+	api.t.Helper()
+	var total struct {
+		Count int `json:"count"`
+	}
+	api.call("GET", "/api/messages/unread", u.token, nil, http.StatusOK, &total)
+
+	var friends []struct {
+		ID          int `json:"id"`
+		UnreadCount int `json:"unread_count"`
+	}
+	api.call("GET", "/api/friends", u.token, nil, http.StatusOK, &friends)
+	badges := make(map[int]int)
+	for _, f := range friends {
+		badges[f.ID] = f.UnreadCount
+	}
+	return total.Count, badges
+}
+
+// memoryStore keeps messages in a MemoryMessageStore.
+func memoryStore(d *sql.DB) storage.MessageStore {
+	return storage.NewMemoryMessageStore()
+}
+
+// sqliteStore keeps messages in the test database, as main does without MONGO_URI.
+func sqliteStore(d *sql.DB) storage.MessageStore {
+	return dbsqlite.NewMessageStore(d, false)
+}
+
+// checkUnreadBadges sends alice three messages from bob and checks her badges
+// before and after she opens the conversation. settle, if given, runs before
+// every read.
+func checkUnreadBadges(t *testing.T, api *testAPI, settle func()) {
+	if settle == nil {
+		settle = func() {}
+	}
+	alice, bob := api.register("alice"), api.register("bob")
+	api.befriend(alice, bob)
+
+	for i := 0; i < 3; i++ {
+		msg := map[string]interface{}{"recipient_id": alice.id, "message": "hello " + strconv.Itoa(i)}
+		api.call("POST", "/api/messages", bob.token, msg, http.StatusCreated, nil)
+	}
+
+	settle()
+	total, badges := api.unread(alice)
+	if total != 3 || badges[bob.id] != 3 {
+		t.Errorf("before reading: total %d, badge %d; want 3 and 3", total, badges[bob.id])
+	}
+	if total, badges := api.unread(bob); total != 0 || badges[alice.id] != 0 {
+		t.Errorf("the sender has total %d, badge %d; want 0 and 0", total, badges[alice.id])
+	}
+
+	var history []models.Message
+	api.call("GET", "/api/messages/"+strconv.Itoa(bob.id), alice.token, nil, http.StatusOK, &history)
+	if len(history) != 3 {
+		t.Errorf("got %d messages, want 3", len(history))
+	}
+
+	settle()
+	total, badges = api.unread(alice)
+	if total != 0 || badges[bob.id] != 0 {
+		t.Errorf("after reading: total %d, badge %d; want 0 and 0", total, badges[bob.id])
+	}
+}
+
+func TestUnreadBadges(t *testing.T) {
+	checkUnreadBadges(t, newTestAPI(t, memoryStore), nil)
+}
+
+func TestGroupUnreadCount(t *testing.T) {
+	api := newTestAPI(t, memoryStore)
+	alice, bob := api.register("alice"), api.register("bob")
+	api.befriend(alice, bob)
+
+	var group struct {
+		ID int `json:"id"`
+	}
+	req := map[string]interface{}{"name": "team", "member_ids": []int{bob.id}}
+	api.call("POST", "/api/conversations", alice.token, req, http.StatusCreated, &group)
+
+	for i := 0; i < 2; i++ {
+		msg := map[string]interface{}{"conversation_id": group.ID, "message": "hi " + strconv.Itoa(i)}
+		api.call("POST", "/api/messages", alice.token, msg, http.StatusCreated, nil)
+	}
+	direct := map[string]interface{}{"recipient_id": bob.id, "message": "and one direct"}
+	api.call("POST", "/api/messages", alice.token, direct, http.StatusCreated, nil)
+
+	if total, _ := api.unread(bob); total != 3 {
+		t.Errorf("before reading: total %d, want 3", total)
+	}
+	if total, _ := api.unread(alice); total != 0 {
+		t.Errorf("the sender has total %d, want 0", total)
+	}
+
+	api.call("POST", "/api/conversations/"+strconv.Itoa(group.ID)+"/read", bob.token, nil, http.StatusOK, nil)
+	if total, _ := api.unread(bob); total != 1 {
+		t.Errorf("after reading the group: total %d, want 1", total)
+	}
+}
+
+func TestMessagesResponseShape(t *testing.T) {
+	api := newTestAPI(t, memoryStore)
+	alice, bob := api.register("alice"), api.register("bob")
+	api.befriend(alice, bob)
+
+	var sent []models.Message
+	for i := 0; i < 3; i++ {
+		var msg models.Message
+		req := map[string]interface{}{"recipient_id": alice.id, "message": "hello " + strconv.Itoa(i)}
+		api.call("POST", "/api/messages", bob.token, req, http.StatusCreated, &msg)
+		sent = append(sent, msg)
+	}
+	path := "/api/messages/" + strconv.Itoa(bob.id)
+
+	// Without a cursor: the original plain array, even with a limit
+	var history []models.Message
+	api.call("GET", path+"?limit=2", alice.token, nil, http.StatusOK, &history)
+	if len(history) != 2 || history[0].ID != sent[1].ID || history[1].ID != sent[2].ID {
+		t.Fatalf("got %+v, want the newest two messages", history)
+	}
+
+	// With one: a page
+	tests := []struct {
+		query string
+		want  []int
+		more  bool
+	}{
+		{"?limit=2&before=" + strconv.Itoa(history[0].ID), []int{sent[0].ID}, false},
+		{"?limit=1&after=" + strconv.Itoa(sent[0].ID), []int{sent[1].ID}, true},
+	}
+	for _, tt := range tests {
+		var page models.MessagePage
+		api.call("GET", path+tt.query, alice.token, nil, http.StatusOK, &page)
+		var got []int
+		for _, m := range page.Messages {
+			got = append(got, m.ID)
+		}
+		if !reflect.DeepEqual(got, tt.want) || page.HasMore != tt.more {
+			t.Errorf("%s: got %v (more %v), want %v (more %v)", tt.query, got, page.HasMore, tt.want, tt.more)
+		}
+	}
+}
+
+func TestGroupRoles(t *testing.T) {
+	api := newTestAPI(t, sqliteStore)
+	alice, bob, carol, dave, eve := api.register("alice"), api.register("bob"), api.register("carol"), api.register("dave"), api.register("eve")
+	for _, u := range []testUser{bob, carol, dave} {
+		api.befriend(alice, u)
+	}
+	api.befriend(bob, dave)
+
+	var group models.Conversation
+	req := map[string]interface{}{"name": "team", "member_ids": []int{bob.id, carol.id}}
+	api.call("POST", "/api/conversations", alice.token, req, http.StatusCreated, &group)
+	if group.Role != models.RoleOwner {
+		t.Fatalf("the creator's role is %q, want owner", group.Role)
+	}
+	req = map[string]interface{}{"name": "not a friend", "member_ids": []int{eve.id}}
+	api.call("POST", "/api/conversations", alice.token, req, http.StatusBadRequest, nil)
+
+	var direct models.Message
+	api.call("POST", "/api/messages", alice.token, map[string]interface{}{"recipient_id": bob.id, "message": "hi"}, http.StatusCreated, &direct)
+
+	g := "/api/conversations/" + strconv.Itoa(group.ID)
+	member := func(u testUser) string { return g + "/members/" + strconv.Itoa(u.id) }
+	role := func(r string) map[string]string { return map[string]string{"role": r} }
+	add := func(u testUser) map[string]int { return map[string]int{"user_id": u.id} }
+
+	// Run in order: each step sees the roles the previous ones left
+	steps := []struct {
+		name   string
+		as     testUser
+		method string
+		path   string
+		body   interface{}
+		status int
+	}{
+		{"owner promotes", alice, "PATCH", member(bob), role(models.RoleAdmin), http.StatusOK},
+		{"member cannot add", carol, "POST", g + "/members", add(dave), http.StatusForbidden},
+		{"admin adds a friend", bob, "POST", g + "/members", add(dave), http.StatusCreated},
+		{"no adding twice", bob, "POST", g + "/members", add(dave), http.StatusConflict},
+		{"only friends", bob, "POST", g + "/members", add(eve), http.StatusBadRequest},
+		{"admin cannot change roles", bob, "PATCH", member(carol), role(models.RoleAdmin), http.StatusForbidden},
+		{"unknown role", alice, "PATCH", member(carol), role("boss"), http.StatusBadRequest},
+		{"owner cannot change their own role", alice, "PATCH", member(alice), role(models.RoleMember), http.StatusBadRequest},
+		{"roles are for members", alice, "PATCH", member(eve), role(models.RoleAdmin), http.StatusNotFound},
+		{"admin cannot remove the owner", bob, "DELETE", member(alice), nil, http.StatusForbidden},
+		{"member cannot remove", carol, "DELETE", member(dave), nil, http.StatusForbidden},
+		{"admin removes a member", bob, "DELETE", member(carol), nil, http.StatusOK},
+		{"removed members lose access", carol, "GET", g, nil, http.StatusNotFound},
+		{"and cannot post", carol, "POST", "/api/messages", map[string]interface{}{"conversation_id": group.ID, "message": "hi"}, http.StatusNotFound},
+		{"outsiders cannot read", eve, "GET", g + "/messages", nil, http.StatusNotFound},
+		{"added members can post", dave, "POST", "/api/messages", map[string]interface{}{"conversation_id": group.ID, "message": "hi"}, http.StatusCreated},
+		{"owner transfers ownership", alice, "PATCH", member(dave), role(models.RoleOwner), http.StatusOK},
+		{"the old owner is an admin", alice, "PATCH", member(bob), role(models.RoleMember), http.StatusForbidden},
+		{"the new owner demotes", dave, "PATCH", member(bob), role(models.RoleMember), http.StatusOK},
+		{"direct conversations have fixed members", alice, "POST", "/api/conversations/" + strconv.Itoa(direct.ConversationID) + "/leave", nil, http.StatusBadRequest},
+	}
+	for _, s := range steps {
+		api.call(s.method, s.path, s.as.token, s.body, s.status, nil)
+	}
+
+	var conv models.Conversation
+	api.call("GET", g, dave.token, nil, http.StatusOK, &conv)
+	got := make(map[int]string)
+	for _, m := range conv.Members {
+		got[m.UserID] = m.Role
+	}
+	want := map[int]string{alice.id: models.RoleAdmin, bob.id: models.RoleMember, dave.id: models.RoleOwner}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got roles %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,108 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"DB-Presentation/models"
+
+	dbsqlite "DB-Presentation/database/sqlite"
+)
+
+// DefaultSessionTTL is used when SESSION_TTL is not set or invalid.
+const DefaultSessionTTL = 7 * 24 * time.Hour
+
+var (
+	ErrInvalidToken   = errors.New("invalid session token")
+	ErrSessionExpired = errors.New("session expired")
+	ErrSessionRevoked = errors.New("session revoked")
+)
+
+var dbase *sql.DB
+var secret []byte
+var sessionTTL = DefaultSessionTTL
+
+// Init configures the session store. The signing key comes from SESSION_SECRET and the
+// lifetime from SESSION_TTL (a Go duration such as "24h"). Without a secret a random key is
+// generated, so tokens will not survive a restart.
+func Init(db *sql.DB) {
+	dbase = db
+
+	if s := os.Getenv("SESSION_SECRET"); s != "" {
+		secret = []byte(s)
+	} else {
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			log.Fatal("auth: could not generate session secret:", err)
+		}
+		log.Println("warning: SESSION_SECRET not set, using a random key (sessions end on restart)")
+	}
+
+	if v := os.Getenv("SESSION_TTL"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			sessionTTL = d
+		} else {
+			log.Printf("warning: invalid SESSION_TTL %q, using %s", v, DefaultSessionTTL)
+		}
+	}
+}
+
+// NewSession creates a server-side session for userID and returns its signed token.
+func NewSession(userID int) (string, models.Session, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", models.Session{}, err
+	}
+	id := base64.RawURLEncoding.EncodeToString(raw)
+
+	s, err := dbsqlite.CreateSession(dbase, id, userID, time.Now().Add(sessionTTL))
+	if err != nil {
+		return "", models.Session{}, err
+	}
+	return id + "." + sign(id), s, nil
+}
+
+// Validate checks the token signature and that the backing session is still active.
+func Validate(token string) (models.Session, error) {
+	id, sig, ok := strings.Cut(token, ".")
+	if !ok || id == "" || !hmac.Equal([]byte(sig), []byte(sign(id))) {
+		return models.Session{}, ErrInvalidToken
+	}
+
+	s, err := dbsqlite.GetSession(dbase, id)
+	if err != nil {
+		return models.Session{}, ErrInvalidToken
+	}
+	if s.RevokedAt != nil {
+		return models.Session{}, ErrSessionRevoked
+	}
+	if !time.Now().Before(s.ExpiresAt) {
+		return models.Session{}, ErrSessionExpired
+	}
+	return s, nil
+}
+
+// Revoke ends a single session.
+func Revoke(sessionID string) error {
+	return dbsqlite.RevokeSession(dbase, sessionID)
+}
+
+// RevokeOthers ends every session of userID except keepID and returns the revoked ids.
+func RevokeOthers(userID int, keepID string) ([]string, error) {
+	return dbsqlite.RevokeUserSessions(dbase, userID, keepID)
+}
+
+// sign returns the base64url HMAC-SHA256 of a session id.
+func sign(id string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(id))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,190 @@
+package auth
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"DB-Presentation/db"
+
+	dbsqlite "DB-Presentation/database/sqlite"
+)
+
+// newTestDB initialises the package over a migrated temporary database with one
+// user, whose id it returns.
+func newTestDB(t *testing.T) (*sql.DB, int) {
+	t.Helper()
+	d, err := db.OpenDB(filepath.Join(t.TempDir(), "chat.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.Close() })
+	if err := db.RunMigrations(d); err != nil {
+		t.Fatal(err)
+	}
+	res, err := d.Exec("INSERT INTO users (username, password) VALUES ('alice', 'x')")
+	if err != nil {
+		t.Fatal(err)
+	}
+	userID, _ := res.LastInsertId()
+
+	t.Setenv("SESSION_SECRET", "test-secret")
+	t.Setenv("SESSION_TTL", "")
+	Init(d)
+	return d, int(userID)
+}
+
+func TestValidate(t *testing.T) {
+	d, userID := newTestDB(t)
+
+	token, session, err := NewSession(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, sig, _ := strings.Cut(token, ".")
+
+	// Sessions whose token is correctly signed but whose row says otherwise
+	expiredID, revokedID := "expired-session", "revoked-session"
+	if _, err := dbsqlite.CreateSession(d, expiredID, userID, time.Now().Add(-time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dbsqlite.CreateSession(d, revokedID, userID, time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if err := Revoke(revokedID); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		want  error
+	}{
+		{"valid", token, nil},
+		{"empty", "", ErrInvalidToken},
+		{"no signature", id, ErrInvalidToken},
+		{"no id", "." + sig, ErrInvalidToken},
+		{"wrong signature", id + "." + sign("another-session"), ErrInvalidToken},
+		{"tampered id", id + "x." + sig, ErrInvalidToken},
+		{"unknown session", "unknown." + sign("unknown"), ErrInvalidToken},
+		{"expired", expiredID + "." + sign(expiredID), ErrSessionExpired},
+		{"revoked", revokedID + "." + sign(revokedID), ErrSessionRevoked},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := Validate(tt.token)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("got %v, want %v", err, tt.want)
+			}
+			if tt.want == nil && (s.ID != session.ID || s.UserID != userID) {
+				t.Errorf("got session %+v, want %+v", s, session)
+			}
+		})
+	}
+}
+
+func TestTokensDependOnTheSecret(t *testing.T) {
+	_, userID := newTestDB(t)
+	token, _, err := NewSession(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secret = []byte("another-secret")
+	if _, err := Validate(token); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("token signed with the old secret: got %v, want %v", err, ErrInvalidToken)
+	}
+}
+
+func TestSessionTTL(t *testing.T) {
+	_, userID := newTestDB(t)
+	t.Cleanup(func() { sessionTTL = DefaultSessionTTL })
+
+	sessionTTL = 50 * time.Millisecond
+	token, _, err := NewSession(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Validate(token); err != nil {
+		t.Fatalf("fresh token: %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if _, err := Validate(token); !errors.Is(err, ErrSessionExpired) {
+		t.Errorf("after the ttl: got %v, want %v", err, ErrSessionExpired)
+	}
+}
+
+func TestRevokeOthers(t *testing.T) {
+	_, userID := newTestDB(t)
+	var tokens []string
+	for i := 0; i < 3; i++ {
+		token, _, err := NewSession(userID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tokens = append(tokens, token)
+	}
+	keep, _, _ := strings.Cut(tokens[0], ".")
+
+	revoked, err := RevokeOthers(userID, keep)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revoked) != 2 {
+		t.Errorf("revoked %d sessions, want 2", len(revoked))
+	}
+	for i, token := range tokens {
+		want := ErrSessionRevoked
+		if i == 0 {
+			want = nil
+		}
+		if _, err := Validate(token); !errors.Is(err, want) {
+			t.Errorf("token %d: got %v, want %v", i, err, want)
+		}
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	_, userID := newTestDB(t)
+	token, _, err := NewSession(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var seen int
+	h := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = UserID(r.Context())
+	}))
+
+	tests := []struct {
+		name   string
+		header string
+		status int
+		user   int
+	}{
+		{"no header", "", http.StatusUnauthorized, 0},
+		{"not bearer", "Basic " + token, http.StatusUnauthorized, 0},
+		{"bad token", "Bearer nonsense", http.StatusUnauthorized, 0},
+		{"valid", "Bearer " + token, http.StatusOK, userID},
+		{"lower case scheme", "bearer " + token, http.StatusOK, userID},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			seen = 0
+			r := httptest.NewRequest("GET", "/api/friends", nil)
+			if tt.header != "" {
+				r.Header.Set("Authorization", tt.header)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if w.Code != tt.status || seen != tt.user {
+				t.Errorf("got status %d and user %d, want %d and %d", w.Code, seen, tt.status, tt.user)
+			}
+		})
+	}
+}
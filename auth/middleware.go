@@ -0,0 +1,60 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"DB-Presentation/models"
+	"DB-Presentation/utils"
+)
+
+type contextKey int
+
+const sessionKey contextKey = iota
+
+// Middleware rejects requests without a valid session token and stores the session in the
+// request context. Handlers must take the caller's identity from the context only.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := TokenFromRequest(r)
+		if token == "" {
+			utils.SendJSON(w, models.Response{Success: false, Message: "Authentication required"}, http.StatusUnauthorized)
+			return
+		}
+
+		s, err := Validate(token)
+		if err != nil {
+			utils.SendJSON(w, models.Response{Success: false, Message: "Invalid or expired session"}, http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(WithSession(r.Context(), s)))
+	})
+}
+
+// TokenFromRequest extracts a bearer token from the Authorization header.
+func TokenFromRequest(r *http.Request) string {
+	h := r.Header.Get("Authorization")
+	if len(h) > 7 && strings.EqualFold(h[:7], "Bearer ") {
+		return strings.TrimSpace(h[7:])
+	}
+	return ""
+}
+
+// WithSession returns a copy of ctx carrying the authenticated session.
+func WithSession(ctx context.Context, s models.Session) context.Context {
+	return context.WithValue(ctx, sessionKey, s)
+}
+
+// SessionFromContext returns the authenticated session, if any.
+func SessionFromContext(ctx context.Context) (models.Session, bool) {
+	s, ok := ctx.Value(sessionKey).(models.Session)
+	return s, ok
+}
+
+// UserID returns the authenticated user's id, or 0 when the request is unauthenticated.
+func UserID(ctx context.Context) int {
+	s, _ := SessionFromContext(ctx)
+	return s.UserID
+}
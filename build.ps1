@@ -0,0 +1,5 @@
+# Builds the server into .\chat-server.exe. The sqlite_fts5 tag compiles FTS5 into
+# SQLite for full-text message search; without it search falls back to LIKE.
+$ErrorActionPreference = "Stop"
+Set-Location $PSScriptRoot
+go build -tags sqlite_fts5 -o chat-server.exe .
@@ -0,0 +1,6 @@
+#!/bin/sh
+# Builds the server into ./chat-server. The sqlite_fts5 tag compiles FTS5 into
+# SQLite for full-text message search; without it search falls back to LIKE.
+set -e
+cd "$(dirname "$0")"
+go build -tags sqlite_fts5 -o chat-server .
@@ -0,0 +1,85 @@
+// Command backfill-ids gives MongoDB message documents written before messages
+// carried their SQLite id that id, removes duplicate copies, and creates the unique
+// index on id:
+//
+//	go run ./cmd/backfill-ids [-db data/chat.db] [-mongo mongodb://...] [-dry-run]
+//
+// -mongo defaults to MONGO_URI. A document is only given an id when it matches
+// exactly one SQLite message that no other document copies; the others are listed.
+// Run it before reconcile -repair, which deletes documents without an id.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	dbmongo "DB-Presentation/database/mongo"
+	"DB-Presentation/db"
+)
+
+// maxListed is how many documents of each kind of conflict are printed.
+const maxListed = 20
+
+func main() {
+	dbPath := flag.String("db", "data/chat.db", "SQLite database `file`")
+	uri := flag.String("mongo", os.Getenv("MONGO_URI"), "MongoDB `uri`")
+	dryRun := flag.Bool("dry-run", false, "only report what would change")
+	flag.Parse()
+
+	if *uri == "" {
+		log.Fatal("a MongoDB URI is required (-mongo or MONGO_URI)")
+	}
+
+	d, err := db.OpenDB(*dbPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer d.Close()
+
+	mc, err := dbmongo.Connect(*uri)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer mc.Disconnect(context.Background())
+
+	ctx := context.Background()
+	report, err := dbmongo.BackfillIDs(ctx, mc, d, *dryRun)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Printf("documents without an id: %d\n", report.Missing)
+	fmt.Printf("  matched to sqlite:     %d\n", report.Assigned)
+	fmt.Printf("  unmatched:             %d\n", report.Unmatched)
+	fmt.Printf("  ambiguous:             %d\n", len(report.Ambiguous))
+	fmt.Printf("  id already taken:      %d\n", len(report.Taken))
+	fmt.Printf("duplicate copies:        %d\n", report.Duplicates)
+	printConflicts("matches several messages", report.Ambiguous)
+	printConflicts("matches a message another document has", report.Taken)
+
+	if *dryRun {
+		fmt.Println("ℹ️  Dry run; nothing was changed")
+		return
+	}
+	if err := dbmongo.EnsureMessageIndex(ctx, mc); err != nil {
+		log.Fatal("could not create the unique id index: ", err)
+	}
+	fmt.Println("✅ Message documents carry their SQLite id")
+	if report.Unmatched+len(report.Ambiguous)+len(report.Taken) > 0 {
+		fmt.Println("⚠️  Documents left without an id are deleted by reconcile -repair, which rewrites their messages from SQLite")
+	}
+}
+
+// printConflicts lists documents left without an id and the messages they matched.
+func printConflicts(reason string, conflicts []dbmongo.BackfillConflict) {
+	for i, c := range conflicts {
+		if i == maxListed {
+			fmt.Printf("  ... and %d more\n", len(conflicts)-maxListed)
+			return
+		}
+		fmt.Printf("  document %s %s: %v\n", c.Document.Hex(), reason, c.Candidates)
+	}
+}
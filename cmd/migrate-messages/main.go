@@ -0,0 +1,110 @@
+// Command migrate-messages copies the messages in SQLite into MongoDB, or verifies
+// that the two copies agree:
+//
+//	go run ./cmd/migrate-messages [-db data/chat.db] [-mongo mongodb://...] [-batch 500] [-restart] [-dry-run]
+//	go run ./cmd/migrate-messages -verify
+//
+// -mongo defaults to MONGO_URI. Messages are upserted by id, so the command can be
+// run again safely; it resumes after the last batch it finished unless -restart is
+// given, and first copies again the messages that changed since its last complete
+// run started. -verify compares message counts and checksums per conversation and
+// exits with status 1 if any differ.
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	mongodriver "go.mongodb.org/mongo-driver/mongo"
+
+	dbmongo "DB-Presentation/database/mongo"
+	"DB-Presentation/db"
+)
+
+// maxListed is how many differing conversations -verify prints.
+const maxListed = 20
+
+func main() {
+	dbPath := flag.String("db", "data/chat.db", "SQLite database `file`")
+	uri := flag.String("mongo", os.Getenv("MONGO_URI"), "MongoDB `uri`")
+	batch := flag.Int("batch", 500, "messages per bulk write")
+	restart := flag.Bool("restart", false, "ignore the checkpoint and copy every message")
+	dryRun := flag.Bool("dry-run", false, "only report what would be copied")
+	verify := flag.Bool("verify", false, "compare counts and checksums per conversation instead of copying")
+	flag.Parse()
+
+	if *uri == "" {
+		log.Fatal("a MongoDB URI is required (-mongo or MONGO_URI)")
+	}
+
+	d, err := db.OpenDB(*dbPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer d.Close()
+
+	mc, err := dbmongo.Connect(*uri)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer mc.Disconnect(context.Background())
+
+	ctx := context.Background()
+	if *verify {
+		runVerify(ctx, mc, d)
+		return
+	}
+
+	report, err := dbmongo.MigrateMessages(ctx, mc, d, dbmongo.MigrateOptions{
+		BatchSize: *batch,
+		Restart:   *restart,
+		DryRun:    *dryRun,
+	})
+	// Print progress even after a failure, so it is clear where a rerun resumes
+	fmt.Printf("resumed after message %d, reached message %d\n", report.ResumedAfter, report.LastID)
+	if !report.ChangedSince.IsZero() {
+		fmt.Printf("  changed since %s: %d\n", report.ChangedSince.Format(time.RFC3339), report.Changed)
+	}
+	fmt.Printf("  read from sqlite:  %d\n", report.Read)
+	fmt.Printf("  new in mongo:      %d\n", report.Inserted)
+	fmt.Printf("  already in mongo:  %d\n", report.Existing)
+	fmt.Printf("  edits copied:      %d\n", report.Edits)
+	fmt.Printf("  reactions copied:  %d\n", report.Reactions)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *dryRun {
+		fmt.Println("ℹ️  Dry run; nothing was written")
+	} else {
+		fmt.Println("✅ Messages copied to MongoDB")
+	}
+}
+
+// runVerify prints the conversations whose copies differ and exits 1 if there are any.
+func runVerify(ctx context.Context, mc *mongodriver.Client, d *sql.DB) {
+	report, err := dbmongo.VerifyMessages(ctx, mc, d)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Printf("compared %d conversations\n", report.Conversations)
+	if len(report.Differ) == 0 {
+		fmt.Println("✅ SQLite and MongoDB agree")
+		return
+	}
+	for i, diff := range report.Differ {
+		if i == maxListed {
+			fmt.Printf("  ... and %d more\n", len(report.Differ)-maxListed)
+			break
+		}
+		fmt.Printf("  conversation %d: %d messages in sqlite, %d in mongo\n", diff.ConversationID, diff.SQLiteCount, diff.MongoCount)
+	}
+	fmt.Printf("⚠️  %d conversations differ; run reconcile -repair to fix MongoDB\n", len(report.Differ))
+	os.Exit(1)
+}
@@ -0,0 +1,63 @@
+// Command reconcile compares the messages in SQLite, the source of truth, with
+// their MongoDB copies and can repair the differences:
+//
+//	go run ./cmd/reconcile [-db data/chat.db] [-mongo mongodb://...] [-repair]
+//
+// -mongo defaults to MONGO_URI. Without -repair it only reports, and exits with
+// status 1 if the copies diverged.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	dbmongo "DB-Presentation/database/mongo"
+	"DB-Presentation/db"
+)
+
+func main() {
+	dbPath := flag.String("db", "data/chat.db", "SQLite database `file`")
+	uri := flag.String("mongo", os.Getenv("MONGO_URI"), "MongoDB `uri`")
+	repair := flag.Bool("repair", false, "rewrite diverged MongoDB documents from SQLite")
+	flag.Parse()
+
+	if *uri == "" {
+		log.Fatal("a MongoDB URI is required (-mongo or MONGO_URI)")
+	}
+
+	d, err := db.OpenDB(*dbPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer d.Close()
+
+	mc, err := dbmongo.Connect(*uri)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer mc.Disconnect(context.Background())
+
+	report, err := dbmongo.Reconcile(context.Background(), mc, d, *repair)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Printf("checked %d messages\n", report.Checked)
+	fmt.Printf("  missing in mongo:      %d\n", report.Missing)
+	fmt.Printf("  read state differs:    %d\n", report.ReadState)
+	fmt.Printf("  content differs:       %d\n", report.Content)
+	fmt.Printf("  not in sqlite (extra): %d\n", report.Extra)
+
+	switch {
+	case !report.Diverged():
+		fmt.Println("✅ SQLite and MongoDB agree")
+	case *repair:
+		fmt.Println("✅ Repaired MongoDB from SQLite")
+	default:
+		fmt.Println("⚠️  SQLite and MongoDB diverged; run with -repair to fix MongoDB")
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,173 @@
+package mongo
+
+import (
+	"context"
+	"database/sql"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	mongodriver "go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	dbsqlite "DB-Presentation/database/sqlite"
+)
+
+// EnsureMessageIndex creates the unique index on the messages' SQLite id. Documents
+// written before messages carried an id are left out of it until BackfillIDs gives
+// them one. It fails while two documents share an id.
+func EnsureMessageIndex(ctx context.Context, client *mongodriver.Client) error {
+	_, err := client.Database("chat").Collection("messages").Indexes().CreateOne(ctx, mongodriver.IndexModel{
+		Keys: bson.D{{Key: "id", Value: 1}},
+		Options: options.Index().
+			SetName("message_id").
+			SetUnique(true).
+			SetPartialFilterExpression(bson.M{"id": bson.M{"$gt": 0}}),
+	})
+	return err
+}
+
+// BackfillReport counts what BackfillIDs found.
+type BackfillReport struct {
+	Missing    int                // documents without an id
+	Assigned   int                // of those, documents given the id of the SQLite message they copy
+	Unmatched  int                // of those, documents matching no SQLite message
+	Ambiguous  []BackfillConflict // of those, documents matching several SQLite messages
+	Taken      []BackfillConflict // of those, documents matching a message another document has
+	Duplicates int                // extra documents for a message that already had one
+}
+
+// BackfillConflict is a document BackfillIDs left without an id, and the SQLite
+// messages it matched.
+type BackfillConflict struct {
+	Document   primitive.ObjectID
+	Candidates []int
+}
+
+// BackfillIDs gives message documents written without an id the id of the SQLite
+// message they copy, matched by conversation (when the document has one), sender,
+// recipient, text and send time. A document is only given an id when exactly one
+// message matches and no other document has that message's id; the others are
+// reported and left without one, for Reconcile to delete and rewrite from SQLite.
+// It then keeps one document per id, deleting the others, so EnsureMessageIndex
+// can succeed. With dryRun set nothing is written.
+func BackfillIDs(ctx context.Context, client *mongodriver.Client, sqlDB *sql.DB, dryRun bool) (BackfillReport, error) {
+	var report BackfillReport
+	coll := client.Database("chat").Collection("messages")
+
+	// Read them all first: updated documents must not come back through the cursor
+	cur, err := coll.Find(ctx, bson.M{"$or": []interface{}{
+		bson.M{"id": nil},
+		bson.M{"id": bson.M{"$lte": 0}},
+	}})
+	if err != nil {
+		return report, err
+	}
+	var docs []bson.M
+	if err := cur.All(ctx, &docs); err != nil {
+		return report, err
+	}
+
+	assigned, err := assignIDs(sqlDB, docs, func(id int) (bool, error) {
+		n, err := coll.CountDocuments(ctx, bson.M{"id": id})
+		return n > 0, err
+	}, &report)
+	if err != nil {
+		return report, err
+	}
+	if !dryRun {
+		for _, a := range assigned {
+			if _, err := coll.UpdateOne(ctx, bson.M{"_id": a.Document}, bson.M{"$set": bson.M{"id": a.ID}}); err != nil {
+				return report, err
+			}
+		}
+	}
+
+	dups, err := duplicateDocuments(ctx, coll)
+	if err != nil {
+		return report, err
+	}
+	report.Duplicates = len(dups)
+	if !dryRun && len(dups) > 0 {
+		if _, err := coll.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": dups}}); err != nil {
+			return report, err
+		}
+	}
+	return report, nil
+}
+
+// backfillAssignment is an id BackfillIDs gives a document.
+type backfillAssignment struct {
+	Document primitive.ObjectID
+	ID       int
+}
+
+// assignIDs matches documents without an id to SQLite messages and returns the ids
+// they can be given, counting every document in report. taken reports whether a
+// document already has an id; ids given out earlier in the same run count as taken
+// too, since a dry run never writes them.
+func assignIDs(sqlDB *sql.DB, docs []bson.M, taken func(id int) (bool, error), report *BackfillReport) ([]backfillAssignment, error) {
+	var assigned []backfillAssignment
+	claimed := make(map[int]bool)
+	for _, doc := range docs {
+		report.Missing++
+		oid, _ := doc["_id"].(primitive.ObjectID)
+		msg := decodeMessage(doc)
+
+		candidates, err := dbsqlite.MatchMessageSQLite(sqlDB, msg.ConversationID, msg.SenderID, msg.RecipientID, msg.Message, msg.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		switch len(candidates) {
+		case 0:
+			report.Unmatched++
+			continue
+		case 1:
+		default:
+			report.Ambiguous = append(report.Ambiguous, BackfillConflict{Document: oid, Candidates: candidates})
+			continue
+		}
+
+		id := candidates[0]
+		isTaken := claimed[id]
+		if !isTaken {
+			if isTaken, err = taken(id); err != nil {
+				return nil, err
+			}
+		}
+		if isTaken {
+			report.Taken = append(report.Taken, BackfillConflict{Document: oid, Candidates: candidates})
+			continue
+		}
+		claimed[id] = true
+		report.Assigned++
+		assigned = append(assigned, backfillAssignment{Document: oid, ID: id})
+	}
+	return assigned, nil
+}
+
+// duplicateDocuments returns the _ids of every document sharing its id with an
+// earlier one.
+func duplicateDocuments(ctx context.Context, coll *mongodriver.Collection) ([]primitive.ObjectID, error) {
+	cur, err := coll.Aggregate(ctx, mongodriver.Pipeline{
+		{{Key: "$match", Value: bson.M{"id": bson.M{"$gt": 0}}}},
+		{{Key: "$sort", Value: bson.M{"_id": 1}}},
+		{{Key: "$group", Value: bson.M{"_id": "$id", "docs": bson.M{"$push": "$_id"}}}},
+		{{Key: "$match", Value: bson.M{"docs.1": bson.M{"$exists": true}}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	var dups []primitive.ObjectID
+	for cur.Next(ctx) {
+		var group struct {
+			Docs []primitive.ObjectID `bson:"docs"`
+		}
+		if err := cur.Decode(&group); err != nil {
+			return nil, err
+		}
+		dups = append(dups, group.Docs[1:]...)
+	}
+	return dups, cur.Err()
+}
@@ -0,0 +1,136 @@
+package mongo
+
+import (
+	"database/sql"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"DB-Presentation/db"
+	"DB-Presentation/models"
+
+	dbsqlite "DB-Presentation/database/sqlite"
+)
+
+// newTestDB returns a migrated temporary SQLite database.
+func newTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	d, err := db.OpenDB(filepath.Join(t.TempDir(), "chat.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.Close() })
+	if err := db.RunMigrations(d); err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+// addUser inserts a user and returns its id.
+func addUser(t *testing.T, d *sql.DB, username string) int {
+	t.Helper()
+	res, err := d.Exec("INSERT INTO users (username, password) VALUES (?, 'x')", username)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, _ := res.LastInsertId()
+	return int(id)
+}
+
+func TestAssignIDs(t *testing.T) {
+	d := newTestDB(t)
+	alice, bob, carol := addUser(t, d, "alice"), addUser(t, d, "bob"), addUser(t, d, "carol")
+	team, err := dbsqlite.CreateGroup(d, alice, "team", []int{bob})
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := dbsqlite.CreateGroup(d, alice, "other", []int{carol})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	insert := func(req models.SendMessageRequest) int {
+		msg, err := dbsqlite.InsertMessageSQLite(d, alice, req, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return msg.ID
+	}
+	unique := insert(models.SendMessageRequest{RecipientID: bob, Message: "unique"})
+	twice1 := insert(models.SendMessageRequest{RecipientID: bob, Message: "twice"})
+	twice2 := insert(models.SendMessageRequest{RecipientID: bob, Message: "twice"})
+	inTeam := insert(models.SendMessageRequest{ConversationID: team.ID, Message: "to both groups"})
+	inOther := insert(models.SendMessageRequest{ConversationID: other.ID, Message: "to both groups"})
+	copied := insert(models.SendMessageRequest{RecipientID: bob, Message: "already copied"})
+	copiedTwice := insert(models.SendMessageRequest{RecipientID: bob, Message: "copied twice"})
+
+	sent := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	if _, err := d.Exec("UPDATE messages SET created_at = ?", sent.Format("2006-01-02 15:04:05")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Legacy documents: no id, and ints of whichever width the writer used
+	doc := func(conversationID, recipientID int, text string, at time.Time) bson.M {
+		m := bson.M{
+			"_id":          primitive.NewObjectID(),
+			"sender_id":    int64(alice),
+			"recipient_id": int32(recipientID),
+			"message":      text,
+			"created_at":   primitive.NewDateTimeFromTime(at),
+		}
+		if conversationID != 0 {
+			m["conversation_id"] = int32(conversationID)
+		}
+		return m
+	}
+	docs := []bson.M{
+		doc(0, bob, "unique", sent.Add(time.Second)),
+		doc(0, bob, "twice", sent),
+		doc(team.ID, 0, "to both groups", sent),
+		doc(0, 0, "to both groups", sent),
+		doc(0, bob, "already copied", sent),
+		doc(0, bob, "copied twice", sent),
+		doc(0, bob, "copied twice", sent),
+		doc(0, bob, "unique", sent.Add(time.Minute)),
+		doc(0, carol, "unique", sent),
+	}
+	oid := func(i int) primitive.ObjectID { return docs[i]["_id"].(primitive.ObjectID) }
+
+	// Another document already has the id of "already copied"
+	taken := func(id int) (bool, error) { return id == copied, nil }
+
+	var report BackfillReport
+	assigned, err := assignIDs(d, docs, taken, &report)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantAssigned := []backfillAssignment{
+		{Document: oid(0), ID: unique},
+		{Document: oid(2), ID: inTeam},
+		{Document: oid(5), ID: copiedTwice},
+	}
+	if !reflect.DeepEqual(assigned, wantAssigned) {
+		t.Errorf("assigned %+v, want %+v", assigned, wantAssigned)
+	}
+	want := BackfillReport{
+		Missing:   len(docs),
+		Assigned:  3,
+		Unmatched: 2,
+		Ambiguous: []BackfillConflict{
+			{Document: oid(1), Candidates: []int{twice1, twice2}},
+			{Document: oid(3), Candidates: []int{inTeam, inOther}},
+		},
+		Taken: []BackfillConflict{
+			{Document: oid(4), Candidates: []int{copied}},
+			{Document: oid(6), Candidates: []int{copiedTwice}},
+		},
+	}
+	if !reflect.DeepEqual(report, want) {
+		t.Errorf("got report %+v, want %+v", report, want)
+	}
+}
@@ -0,0 +1,178 @@
+package mongo
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	mongodriver "go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"DB-Presentation/models"
+)
+
+const (
+	// brokerCollection is a capped collection every instance tails for websocket events.
+	brokerCollection = "ws_events"
+
+	// brokerCapBytes bounds the collection; events only need to live long enough to be tailed.
+	brokerCapBytes = 16 * 1024 * 1024
+)
+
+// Broker fans websocket events out between server instances through a capped
+// collection. Each instance delivers the events it publishes to its own sockets
+// directly, inserts them stamped with its origin, and tails the collection for the
+// other instances' events.
+type Broker struct {
+	coll   *mongodriver.Collection
+	origin string
+
+	mu      sync.Mutex
+	handler func(models.UserEvent)
+	cancel  context.CancelFunc
+	done    chan struct{}
+}
+
+// NewBroker creates the capped collection if needed and returns a broker using it.
+func NewBroker(ctx context.Context, client *mongodriver.Client) (*Broker, error) {
+	db := client.Database("chat")
+	opts := options.CreateCollection().SetCapped(true).SetSizeInBytes(brokerCapBytes)
+	if err := db.CreateCollection(ctx, brokerCollection, opts); err != nil {
+		var ce mongodriver.CommandError
+		// 48 = NamespaceExists: another instance created it first
+		if !errors.As(err, &ce) || ce.Code != 48 {
+			return nil, err
+		}
+	}
+
+	origin := make([]byte, 8)
+	if _, err := rand.Read(origin); err != nil {
+		return nil, err
+	}
+
+	return &Broker{coll: db.Collection(brokerCollection), origin: hex.EncodeToString(origin)}, nil
+}
+
+// Publish delivers the event to this instance's subscriber, then inserts it for the
+// others. The payload is stored as JSON so arbitrary event data round-trips exactly
+// as clients would see it.
+func (b *Broker) Publish(ctx context.Context, ev models.UserEvent) error {
+	ev.Origin = b.origin
+	b.mu.Lock()
+	handler := b.handler
+	b.mu.Unlock()
+	if handler != nil {
+		handler(ev)
+	}
+
+	payload, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	_, err = b.coll.InsertOne(ctx, bson.M{
+		"origin":     b.origin,
+		"user_id":    ev.UserID,
+		"payload":    string(payload),
+		"created_at": primitive.NewDateTimeFromTime(time.Now().UTC()),
+	})
+	return err
+}
+
+// Subscribe starts tailing the collection from now on and calls handler for every
+// event, this instance's as they are published and the others' in insertion order.
+// Only one subscription per broker is supported.
+func (b *Broker) Subscribe(handler func(models.UserEvent)) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.cancel != nil {
+		return errors.New("mongo broker: already subscribed")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	b.handler = handler
+	b.cancel = cancel
+	b.done = make(chan struct{})
+	go b.tail(ctx, handler)
+	return nil
+}
+
+// Close stops the subscription.
+func (b *Broker) Close() error {
+	b.mu.Lock()
+	cancel, done := b.cancel, b.done
+	b.handler = nil
+	b.mu.Unlock()
+	if cancel != nil {
+		cancel()
+		<-done
+	}
+	return nil
+}
+
+// tail follows the other instances' events in the capped collection with a tailable
+// cursor, reopening it after errors. On reopen it resumes from the last seen
+// created_at and skips documents already delivered at that instant.
+func (b *Broker) tail(ctx context.Context, handler func(models.UserEvent)) {
+	defer close(b.done)
+
+	since := primitive.NewDateTimeFromTime(time.Now().UTC())
+	seen := make(map[primitive.ObjectID]bool)
+
+	for ctx.Err() == nil {
+		opts := options.Find().SetCursorType(options.TailableAwait).SetMaxAwaitTime(time.Second)
+		filter := bson.M{"created_at": bson.M{"$gte": since}, "origin": bson.M{"$ne": b.origin}}
+		cur, err := b.coll.Find(ctx, filter, opts)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Println("warning: mongo broker tail failed:", err)
+				sleepCtx(ctx, time.Second)
+			}
+			continue
+		}
+
+		for cur.Next(ctx) {
+			var doc struct {
+				ID        primitive.ObjectID `bson:"_id"`
+				Payload   string             `bson:"payload"`
+				CreatedAt primitive.DateTime `bson:"created_at"`
+			}
+			if err := cur.Decode(&doc); err != nil {
+				continue
+			}
+			if seen[doc.ID] {
+				continue
+			}
+			if doc.CreatedAt > since {
+				since = doc.CreatedAt
+				seen = make(map[primitive.ObjectID]bool)
+			}
+			seen[doc.ID] = true
+
+			var ev models.UserEvent
+			if err := json.Unmarshal([]byte(doc.Payload), &ev); err != nil {
+				continue
+			}
+			handler(ev)
+		}
+		if err := cur.Err(); err != nil && ctx.Err() == nil {
+			log.Println("warning: mongo broker cursor closed:", err)
+		}
+		cur.Close(context.Background())
+		// An empty capped collection yields a dead cursor immediately; don't spin
+		sleepCtx(ctx, 200*time.Millisecond)
+	}
+}
+
+func sleepCtx(ctx context.Context, d time.Duration) {
+	select {
+	case <-ctx.Done():
+	case <-time.After(d):
+	}
+}
@@ -0,0 +1,101 @@
+package mongo
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	mongodriver "go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"DB-Presentation/models"
+)
+
+// SyncMessage writes a message's current state: its document (read state
+// included), and its edit history and reactions, replacing the copies in
+// message_edits and message_reactions. It can be repeated, so the relay may
+// deliver the same change twice.
+func SyncMessage(ctx context.Context, client *mongodriver.Client, msg models.Message, edits []models.MessageEdit, reactionList []models.MessageReaction) error {
+	if err := UpsertMessage(ctx, client, msg); err != nil {
+		return err
+	}
+
+	editDocs := make([]interface{}, len(edits))
+	for i, e := range edits {
+		editDocs[i] = editDoc(e)
+	}
+	reactionDocs := make([]interface{}, len(reactionList))
+	for i, r := range reactionList {
+		reactionDocs[i] = reactionDocFor(r)
+	}
+	for _, c := range []struct {
+		coll *mongodriver.Collection
+		docs []interface{}
+	}{
+		{client.Database("chat").Collection("message_edits"), editDocs},
+		{reactions(ctx, client), reactionDocs},
+	} {
+		if _, err := c.coll.DeleteMany(ctx, bson.M{"message_id": msg.ID}); err != nil {
+			return err
+		}
+		if len(c.docs) == 0 {
+			continue
+		}
+		if _, err := c.coll.InsertMany(ctx, c.docs, options.InsertMany().SetOrdered(true)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// editDoc is the message_edits document stored for e.
+func editDoc(e models.MessageEdit) bson.M {
+	return bson.M{
+		"message_id": e.MessageID,
+		"message":    e.Message,
+		"edited_at":  primitive.NewDateTimeFromTime(e.EditedAt.UTC()),
+	}
+}
+
+// MessageEdits returns a message's previous versions, oldest first.
+func MessageEdits(ctx context.Context, client *mongodriver.Client, id int) ([]models.MessageEdit, error) {
+	edits, err := messageEditsFor(ctx, client, []int{id})
+	if err != nil {
+		return nil, err
+	}
+	if edits[id] == nil {
+		return []models.MessageEdit{}, nil
+	}
+	return edits[id], nil
+}
+
+// messageEditsFor returns the previous versions of the given messages, oldest
+// first, keyed by message id.
+func messageEditsFor(ctx context.Context, client *mongodriver.Client, ids []int) (map[int][]models.MessageEdit, error) {
+	out := make(map[int][]models.MessageEdit)
+	if len(ids) == 0 {
+		return out, nil
+	}
+
+	coll := client.Database("chat").Collection("message_edits")
+	opts := options.Find().SetSort(bson.D{{Key: "edited_at", Value: 1}, {Key: "_id", Value: 1}})
+	cur, err := coll.Find(ctx, bson.M{"message_id": bson.M{"$in": ids}}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	for cur.Next(ctx) {
+		var doc struct {
+			MessageID int       `bson:"message_id"`
+			Message   string    `bson:"message"`
+			EditedAt  time.Time `bson:"edited_at"`
+		}
+		if err := cur.Decode(&doc); err != nil {
+			continue
+		}
+		out[doc.MessageID] = append(out[doc.MessageID], models.MessageEdit{MessageID: doc.MessageID, Message: doc.Message, EditedAt: doc.EditedAt.UTC()})
+	}
+	return out, cur.Err()
+}
@@ -0,0 +1,124 @@
+package mongo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	mongodriver "go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"DB-Presentation/models"
+)
+
+// EventLog keeps every user's websocket event log in Mongo, so instances sharing
+// users through the broker also share one sequence per user. Seqs come from a
+// per-user counter document, which outlives pruning, so they never repeat.
+type EventLog struct {
+	events   *mongodriver.Collection
+	counters *mongodriver.Collection
+}
+
+// NewEventLog returns the event log in client's chat database, creating its indexes.
+func NewEventLog(ctx context.Context, client *mongodriver.Client) (*EventLog, error) {
+	db := client.Database("chat")
+	l := &EventLog{events: db.Collection("user_events"), counters: db.Collection("user_event_seqs")}
+	_, err := l.events.Indexes().CreateMany(ctx, []mongodriver.IndexModel{
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "seq", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "created_at", Value: 1}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// Append stores msg under the user's next seq. The data is stored as JSON, as in SQLite.
+func (l *EventLog) Append(ctx context.Context, userID int, msg models.WSMessage) (int64, error) {
+	data, err := json.Marshal(msg.Data)
+	if err != nil {
+		return 0, err
+	}
+
+	var counter struct {
+		Seq int64 `bson:"seq"`
+	}
+	err = l.counters.FindOneAndUpdate(ctx,
+		bson.M{"_id": userID},
+		bson.M{"$inc": bson.M{"seq": 1}},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&counter)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = l.events.InsertOne(ctx, bson.M{
+		"user_id":    userID,
+		"seq":        counter.Seq,
+		"type":       msg.Type,
+		"data":       string(data),
+		"created_at": primitive.NewDateTimeFromTime(time.Now().UTC()),
+	})
+	return counter.Seq, err
+}
+
+// Since returns up to limit events of a user with seq greater than afterSeq, oldest first.
+func (l *EventLog) Since(ctx context.Context, userID int, afterSeq int64, limit int) ([]models.WSMessage, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "seq", Value: 1}}).SetLimit(int64(limit))
+	cur, err := l.events.Find(ctx, bson.M{"user_id": userID, "seq": bson.M{"$gt": afterSeq}}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	var events []models.WSMessage
+	for cur.Next(ctx) {
+		var doc struct {
+			Seq  int64  `bson:"seq"`
+			Type string `bson:"type"`
+			Data string `bson:"data"`
+		}
+		if err := cur.Decode(&doc); err != nil {
+			return nil, err
+		}
+		events = append(events, models.WSMessage{Seq: doc.Seq, Type: doc.Type, Data: json.RawMessage(doc.Data)})
+	}
+	return events, cur.Err()
+}
+
+// SeqRange returns the lowest retained seq and the highest assigned one. When every
+// event has been pruned, lo is hi+1.
+func (l *EventLog) SeqRange(ctx context.Context, userID int) (int64, int64, error) {
+	var counter struct {
+		Seq int64 `bson:"seq"`
+	}
+	err := l.counters.FindOne(ctx, bson.M{"_id": userID}).Decode(&counter)
+	if errors.Is(err, mongodriver.ErrNoDocuments) {
+		return 0, 0, nil
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var oldest struct {
+		Seq int64 `bson:"seq"`
+	}
+	opts := options.FindOne().SetSort(bson.D{{Key: "seq", Value: 1}})
+	err = l.events.FindOne(ctx, bson.M{"user_id": userID}, opts).Decode(&oldest)
+	if errors.Is(err, mongodriver.ErrNoDocuments) {
+		return counter.Seq + 1, counter.Seq, nil
+	}
+	return oldest.Seq, counter.Seq, err
+}
+
+// Prune deletes events older than the cutoff. The counters keep seqs increasing.
+func (l *EventLog) Prune(ctx context.Context, olderThan time.Time) (int64, error) {
+	res, err := l.events.DeleteMany(ctx, bson.M{"created_at": bson.M{"$lt": primitive.NewDateTimeFromTime(olderThan.UTC())}})
+	if err != nil {
+		return 0, err
+	}
+	return res.DeletedCount, nil
+}
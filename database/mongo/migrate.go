@@ -0,0 +1,394 @@
+package mongo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	mongodriver "go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"DB-Presentation/models"
+
+	dbsqlite "DB-Presentation/database/sqlite"
+)
+
+// messagesCheckpoint is the _id of the migration_checkpoints document recording how
+// far MigrateMessages got: the last message it copied, and when its last complete
+// run started.
+const messagesCheckpoint = "messages"
+
+// MigrateOptions controls MigrateMessages.
+type MigrateOptions struct {
+	BatchSize int  // messages per bulk write; 500 if not set
+	Restart   bool // ignore the checkpoint and copy from the first message
+	DryRun    bool // only count what would be written
+}
+
+// MigrateReport counts what MigrateMessages did, or would do on a dry run.
+type MigrateReport struct {
+	ResumedAfter int       // message id the run started after
+	ChangedSince time.Time // start of the last complete run; zero on the first
+	LastID       int       // last message id copied
+	Read         int       // SQLite messages read
+	Changed      int       // of those, messages at or below ResumedAfter copied again because they changed
+	Inserted     int       // of those, messages Mongo had no document for
+	Existing     int       // of those, messages whose document was overwritten
+	Edits        int       // previous versions copied with the messages
+	Reactions    int       // reactions copied with the messages
+}
+
+// migrationCheckpoint is the stored progress of MigrateMessages.
+type migrationCheckpoint struct {
+	LastID int `bson:"last_id"`
+	// SyncedAt is when the last run that reached the end of the table started
+	SyncedAt time.Time `bson:"synced_at"`
+}
+
+// MigrateMessages copies SQLite messages into Mongo, upserting each by id so a
+// repeated run never duplicates one, along with their edit history and reactions.
+// It first copies again the messages below the checkpoint that changed (were
+// edited, deleted, read, reacted to or given attachments) since
+// the last complete run started, then the messages after the checkpoint, in id
+// order. After every batch of new messages the last id is saved, and the next run
+// resumes after it; the start time is saved once a run reaches the end.
+func MigrateMessages(ctx context.Context, client *mongodriver.Client, sqlDB *sql.DB, opts MigrateOptions) (MigrateReport, error) {
+	var report MigrateReport
+	checkpoints := client.Database("chat").Collection("migration_checkpoints")
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = reconcileBatch
+	}
+	// Second resolution, like the SQLite timestamps it is compared with
+	started := time.Now().UTC().Truncate(time.Second)
+
+	var cp migrationCheckpoint
+	if !opts.Restart {
+		err := checkpoints.FindOne(ctx, bson.M{"_id": messagesCheckpoint}).Decode(&cp)
+		if err != nil && !errors.Is(err, mongodriver.ErrNoDocuments) {
+			return report, err
+		}
+	}
+	report.ResumedAfter = cp.LastID
+	report.ChangedSince = cp.SyncedAt
+
+	// Changed messages: nothing to do on a first run, which copies every message anyway
+	if !cp.SyncedAt.IsZero() {
+		for after := 0; ; {
+			batch, err := dbsqlite.ChangedMessagesSQLite(sqlDB, cp.SyncedAt, after, cp.LastID, opts.BatchSize)
+			if err != nil {
+				return report, err
+			}
+			if len(batch) == 0 {
+				break
+			}
+			after = batch[len(batch)-1].ID
+			report.Changed += len(batch)
+			if err := copyMessages(ctx, client, sqlDB, batch, opts.DryRun, &report); err != nil {
+				return report, err
+			}
+		}
+	}
+
+	report.LastID = report.ResumedAfter
+	for {
+		batch, err := dbsqlite.MessagesAfterSQLite(sqlDB, report.LastID, opts.BatchSize)
+		if err != nil {
+			return report, err
+		}
+		if len(batch) == 0 {
+			break
+		}
+		report.LastID = batch[len(batch)-1].ID
+		if err := copyMessages(ctx, client, sqlDB, batch, opts.DryRun, &report); err != nil {
+			return report, err
+		}
+		if opts.DryRun {
+			continue
+		}
+		_, err = checkpoints.UpdateOne(ctx,
+			bson.M{"_id": messagesCheckpoint},
+			bson.M{"$set": bson.M{"last_id": report.LastID, "updated_at": time.Now().UTC()}},
+			options.Update().SetUpsert(true),
+		)
+		if err != nil {
+			return report, err
+		}
+	}
+
+	if opts.DryRun {
+		return report, nil
+	}
+	_, err := checkpoints.UpdateOne(ctx,
+		bson.M{"_id": messagesCheckpoint},
+		bson.M{"$set": bson.M{"last_id": report.LastID, "synced_at": started, "updated_at": time.Now().UTC()}},
+		options.Update().SetUpsert(true),
+	)
+	return report, err
+}
+
+// copyMessages upserts a batch of messages by id in one bulk write, replaces their
+// edit history and reactions, and counts them in report. With dryRun set it only
+// counts them.
+func copyMessages(ctx context.Context, client *mongodriver.Client, sqlDB *sql.DB, batch []models.Message, dryRun bool, report *MigrateReport) error {
+	coll := client.Database("chat").Collection("messages")
+	report.Read += len(batch)
+
+	ids := make([]int, len(batch))
+	for i, msg := range batch {
+		ids[i] = msg.ID
+	}
+	edits, err := dbsqlite.MessageEditsForSQLite(sqlDB, ids)
+	if err != nil {
+		return err
+	}
+	reactionLists, err := dbsqlite.ReactionsForSQLite(sqlDB, ids)
+	if err != nil {
+		return err
+	}
+	var editDocs, reactionDocs []interface{}
+	for _, id := range ids {
+		for _, e := range edits[id] {
+			editDocs = append(editDocs, editDoc(e))
+		}
+		for _, r := range reactionLists[id] {
+			reactionDocs = append(reactionDocs, reactionDocFor(r))
+		}
+	}
+	report.Edits += len(editDocs)
+	report.Reactions += len(reactionDocs)
+
+	if dryRun {
+		n, err := coll.CountDocuments(ctx, bson.M{"id": bson.M{"$in": ids}})
+		if err != nil {
+			return err
+		}
+		report.Existing += int(n)
+		report.Inserted += len(batch) - int(n)
+		return nil
+	}
+
+	writes := make([]mongodriver.WriteModel, len(batch))
+	for i, msg := range batch {
+		writes[i] = mongodriver.NewUpdateOneModel().
+			SetFilter(bson.M{"id": msg.ID}).
+			SetUpdate(bson.M{"$set": messageDoc(msg)}).
+			SetUpsert(true)
+	}
+	res, err := coll.BulkWrite(ctx, writes, options.BulkWrite().SetOrdered(false))
+	if err != nil {
+		return err
+	}
+	report.Inserted += int(res.UpsertedCount)
+	report.Existing += int(res.MatchedCount)
+
+	// Replaced wholesale, so that a rerun after a failure here copies them again
+	byMessage := bson.M{"message_id": bson.M{"$in": ids}}
+	for _, c := range []struct {
+		coll *mongodriver.Collection
+		docs []interface{}
+	}{
+		{client.Database("chat").Collection("message_edits"), editDocs},
+		{reactions(ctx, client), reactionDocs},
+	} {
+		if _, err := c.coll.DeleteMany(ctx, byMessage); err != nil {
+			return err
+		}
+		if len(c.docs) == 0 {
+			continue
+		}
+		if _, err := c.coll.InsertMany(ctx, c.docs, options.InsertMany().SetOrdered(true)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ConversationDiff is a conversation whose messages differ between the two copies.
+type ConversationDiff struct {
+	ConversationID int
+	SQLiteCount    int
+	MongoCount     int
+}
+
+// VerifyReport is the result of VerifyMessages.
+type VerifyReport struct {
+	Conversations int                // conversations compared
+	Differ        []ConversationDiff // ordered by conversation id
+}
+
+// conversationSum is the message count and checksum of one conversation.
+type conversationSum struct {
+	count int
+	sum   uint64
+}
+
+// VerifyMessages compares the message count and checksum of every conversation in
+// SQLite and Mongo. The checksum covers each message's id, participants, text, send
+// time, read state, whether it was edited or deleted, the message it replies to,
+// its attachments, its edit history and its reactions, and does not depend on
+// order. Both copies are read in batches in id order, each batch with its history.
+func VerifyMessages(ctx context.Context, client *mongodriver.Client, sqlDB *sql.DB) (VerifyReport, error) {
+	var report VerifyReport
+
+	sqliteSums := make(map[int]conversationSum)
+	for after := 0; ; {
+		batch, err := dbsqlite.MessagesAfterSQLite(sqlDB, after, reconcileBatch)
+		if err != nil {
+			return report, err
+		}
+		if len(batch) == 0 {
+			break
+		}
+		after = batch[len(batch)-1].ID
+
+		ids := make([]int, len(batch))
+		for i, msg := range batch {
+			ids[i] = msg.ID
+		}
+		edits, err := dbsqlite.MessageEditsForSQLite(sqlDB, ids)
+		if err != nil {
+			return report, err
+		}
+		reactionLists, err := dbsqlite.ReactionsForSQLite(sqlDB, ids)
+		if err != nil {
+			return report, err
+		}
+		for _, msg := range batch {
+			addChecksum(sqliteSums, msg, edits[msg.ID], reactionLists[msg.ID])
+		}
+	}
+
+	mongoSums := make(map[int]conversationSum)
+	coll := client.Database("chat").Collection("messages")
+	filter := bson.M{"id": bson.M{"$gt": 0}}
+	for {
+		docs, err := nextMessageDocuments(ctx, coll, filter)
+		if err != nil {
+			return report, err
+		}
+		if len(docs) == 0 {
+			break
+		}
+		batch := make([]models.Message, len(docs))
+		ids := make([]int, len(docs))
+		for i, doc := range docs {
+			batch[i] = decodeMessage(doc)
+			ids[i] = batch[i].ID
+		}
+		edits, err := messageEditsFor(ctx, client, ids)
+		if err != nil {
+			return report, err
+		}
+		reactionLists, err := reactionListsFor(ctx, client, ids)
+		if err != nil {
+			return report, err
+		}
+		for _, msg := range batch {
+			addChecksum(mongoSums, msg, edits[msg.ID], reactionLists[msg.ID])
+		}
+		// Page on (id, _id): while ids are not unique yet, copies of one message
+		// may straddle two batches
+		last := docs[len(docs)-1]
+		filter = bson.M{"$or": []interface{}{
+			bson.M{"id": bson.M{"$gt": last["id"]}},
+			bson.M{"id": last["id"], "_id": bson.M{"$gt": last["_id"]}},
+		}}
+	}
+	// Documents without an id are left out of the pages above; each one makes its
+	// conversation differ
+	idless, err := findMessages(ctx, client, bson.M{"$or": []interface{}{
+		bson.M{"id": nil},
+		bson.M{"id": bson.M{"$lte": 0}},
+	}}, options.Find())
+	if err != nil {
+		return report, err
+	}
+	for _, msg := range idless {
+		addChecksum(mongoSums, msg, nil, nil)
+	}
+
+	seen := make(map[int]bool)
+	for _, sums := range []map[int]conversationSum{sqliteSums, mongoSums} {
+		for id := range sums {
+			if seen[id] {
+				continue
+			}
+			seen[id] = true
+			report.Conversations++
+			if sqliteSums[id] != mongoSums[id] {
+				report.Differ = append(report.Differ, ConversationDiff{
+					ConversationID: id,
+					SQLiteCount:    sqliteSums[id].count,
+					MongoCount:     mongoSums[id].count,
+				})
+			}
+		}
+	}
+	sort.Slice(report.Differ, func(i, j int) bool {
+		return report.Differ[i].ConversationID < report.Differ[j].ConversationID
+	})
+	return report, nil
+}
+
+// nextMessageDocuments returns the next batch of message documents matching filter
+// in (id, _id) order.
+func nextMessageDocuments(ctx context.Context, coll *mongodriver.Collection, filter bson.M) ([]bson.M, error) {
+	opts := options.Find().
+		SetSort(bson.D{{Key: "id", Value: 1}, {Key: "_id", Value: 1}}).
+		SetLimit(reconcileBatch)
+	cur, err := coll.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	var docs []bson.M
+	err = cur.All(ctx, &docs)
+	return docs, err
+}
+
+// addChecksum adds msg, with its previous versions and reactions, to its
+// conversation's count and checksum.
+func addChecksum(sums map[int]conversationSum, msg models.Message, edits []models.MessageEdit, reactionList []models.MessageReaction) {
+	replyTo := 0
+	if msg.ReplyToID != nil {
+		replyTo = *msg.ReplyToID
+	}
+
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%d|%d|%d|%s|%d|%t|%t|%t|%d",
+		msg.ID, msg.SenderID, msg.RecipientID, msg.Message, msg.CreatedAt.Unix(),
+		msg.IsRead, msg.EditedAt != nil, msg.DeletedAt != nil, replyTo)
+
+	// Attachments in id order, whatever order either copy keeps them in
+	attachments := append([]models.Attachment(nil), msg.Attachments...)
+	sort.Slice(attachments, func(i, j int) bool { return attachments[i].ID < attachments[j].ID })
+	for _, a := range attachments {
+		fmt.Fprintf(h, "|%s|%s|%s|%d|%s", a.ID, a.Filename, a.MimeType, a.Size, a.SHA256)
+	}
+
+	// Edits are kept oldest first by both copies
+	for _, e := range edits {
+		fmt.Fprintf(h, "|e|%s|%d", e.Message, e.EditedAt.Unix())
+	}
+
+	// Reactions by user and emoji; their times depend on which copy wrote them
+	reactionList = append([]models.MessageReaction(nil), reactionList...)
+	sort.Slice(reactionList, func(i, j int) bool {
+		if reactionList[i].UserID != reactionList[j].UserID {
+			return reactionList[i].UserID < reactionList[j].UserID
+		}
+		return reactionList[i].Emoji < reactionList[j].Emoji
+	})
+	for _, r := range reactionList {
+		fmt.Fprintf(h, "|r|%d|%s", r.UserID, r.Emoji)
+	}
+
+	s := sums[msg.ConversationID]
+	s.count++
+	s.sum += h.Sum64()
+	sums[msg.ConversationID] = s
+}
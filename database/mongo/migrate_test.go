@@ -0,0 +1,215 @@
+package mongo
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	mongodriver "go.mongodb.org/mongo-driver/mongo"
+
+	"DB-Presentation/models"
+
+	dbsqlite "DB-Presentation/database/sqlite"
+)
+
+// mongoTestClient connects to TEST_MONGO_URI, skipping the test when it is unset.
+// The chat database is dropped before and after the test.
+func mongoTestClient(t *testing.T) *mongodriver.Client {
+	t.Helper()
+	uri := os.Getenv("TEST_MONGO_URI")
+	if uri == "" {
+		t.Skip("TEST_MONGO_URI is not set")
+	}
+	mc, err := Connect(uri)
+	if err != nil {
+		t.Fatal(err)
+	}
+	drop := func() {
+		if err := mc.Database("chat").Drop(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	drop()
+	t.Cleanup(func() {
+		drop()
+		mc.Disconnect(context.Background())
+	})
+	return mc
+}
+
+// roundTrip returns doc as read back from Mongo, with the types the driver decodes.
+func roundTrip(t *testing.T, doc bson.M) bson.M {
+	t.Helper()
+	raw, err := bson.Marshal(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bson.M
+	if err := bson.Unmarshal(raw, &out); err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func TestChecksum(t *testing.T) {
+	d := newTestDB(t)
+	alice, bob := addUser(t, d, "alice"), addUser(t, d, "bob")
+	msg, err := dbsqlite.InsertMessageSQLite(d, alice, models.SendMessageRequest{RecipientID: bob, Message: "hello"}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg, err = dbsqlite.EditMessageSQLite(d, msg.ID, "hello, edited", time.Now(), false); err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range []struct {
+		user  int
+		emoji string
+	}{{bob, "👍"}, {alice, "👍"}, {bob, "🎉"}} {
+		if _, err := dbsqlite.AddReactionSQLite(d, msg.ID, r.user, r.emoji, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	edits, err := dbsqlite.MessageEditsSQLite(d, msg.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reactionList, err := dbsqlite.ReactionsSQLite(d, msg.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sum := func(msg models.Message, edits []models.MessageEdit, reactionList []models.MessageReaction) conversationSum {
+		sums := make(map[int]conversationSum)
+		addChecksum(sums, msg, edits, reactionList)
+		return sums[msg.ConversationID]
+	}
+	want := sum(msg, edits, reactionList)
+
+	// Mongo's copy, written the way migrations and the relay write it
+	copied := decodeMessage(roundTrip(t, messageDoc(msg)))
+	var copiedEdits []models.MessageEdit
+	for _, e := range edits {
+		doc := roundTrip(t, editDoc(e))
+		copiedEdits = append(copiedEdits, models.MessageEdit{MessageID: intValue(doc["message_id"]), Message: doc["message"].(string), EditedAt: *timeValue(doc["edited_at"])})
+	}
+	// In another order, and stamped when the mirror wrote them
+	var reordered []models.MessageReaction
+	for i := len(reactionList) - 1; i >= 0; i-- {
+		r := reactionList[i]
+		r.CreatedAt = r.CreatedAt.Add(time.Minute)
+		reordered = append(reordered, r)
+	}
+
+	edited := append([]models.MessageEdit(nil), edits...)
+	edited[0].Message = "tampered"
+
+	tests := []struct {
+		name      string
+		msg       models.Message
+		edits     []models.MessageEdit
+		reactions []models.MessageReaction
+		same      bool
+	}{
+		{"mongo's copy", copied, copiedEdits, reordered, true},
+		{"missing edit history", copied, nil, reordered, false},
+		{"different edit", copied, edited, reordered, false},
+		{"missing reaction", copied, copiedEdits, reordered[1:], false},
+		{"no reactions", copied, copiedEdits, nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := sum(tt.msg, tt.edits, tt.reactions)
+			if (got == want) != tt.same {
+				t.Errorf("checksum %v, want %v (same: %v)", got, want, tt.same)
+			}
+		})
+	}
+}
+
+func TestMigrateMessages(t *testing.T) {
+	mc := mongoTestClient(t)
+	d := newTestDB(t)
+	alice, bob := addUser(t, d, "alice"), addUser(t, d, "bob")
+	ctx := context.Background()
+
+	var ids []int
+	send := func(text string) {
+		msg, err := dbsqlite.InsertMessageSQLite(d, alice, models.SendMessageRequest{RecipientID: bob, Message: text}, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, msg.ID)
+	}
+	for i := 0; i < 5; i++ {
+		send("hello")
+	}
+	if _, err := dbsqlite.EditMessageSQLite(d, ids[0], "edited", time.Now(), false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dbsqlite.AddReactionSQLite(d, ids[1], bob, "👍", false); err != nil {
+		t.Fatal(err)
+	}
+
+	verify := func(wantDiffer int) {
+		t.Helper()
+		report, err := VerifyMessages(ctx, mc, d)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(report.Differ) != wantDiffer {
+			t.Errorf("verify: %d conversations differ, want %d: %+v", len(report.Differ), wantDiffer, report.Differ)
+		}
+	}
+
+	// First run, in batches smaller than the table
+	opts := MigrateOptions{BatchSize: 2}
+	report, err := MigrateMessages(ctx, mc, d, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.ResumedAfter != 0 || report.LastID != ids[4] || report.Inserted != 5 || report.Edits != 1 || report.Reactions != 1 {
+		t.Errorf("first run: %+v", report)
+	}
+	verify(0)
+
+	// Resuming copies the new message and again the ones changed since
+	time.Sleep(time.Second)
+	send("later")
+	if _, err := dbsqlite.AddReactionSQLite(d, ids[2], bob, "🎉", false); err != nil {
+		t.Fatal(err)
+	}
+	verify(1)
+	report, err = MigrateMessages(ctx, mc, d, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.ResumedAfter != ids[4] || report.LastID != ids[5] || report.Inserted != 1 || report.Changed < 1 {
+		t.Errorf("resumed run: %+v", report)
+	}
+	verify(0)
+
+	// Losing the copied history is noticed, and a restart copies it again
+	if _, err := mc.Database("chat").Collection("message_edits").DeleteMany(ctx, bson.M{}); err != nil {
+		t.Fatal(err)
+	}
+	verify(1)
+	report, err = MigrateMessages(ctx, mc, d, MigrateOptions{BatchSize: 2, Restart: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.ResumedAfter != 0 || report.Read != 6 || report.Existing != 6 || report.Edits != 1 || report.Reactions != 2 {
+		t.Errorf("restarted run: %+v", report)
+	}
+	verify(0)
+
+	// Mongo reads trust the copied history
+	edits, err := MessageEdits(ctx, mc, ids[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(edits) != 1 || edits[0].Message != "hello" {
+		t.Errorf("edits in mongo: %+v", edits)
+	}
+}
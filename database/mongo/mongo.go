@@ -2,7 +2,6 @@ package mongo
 
 import (
 	"context"
-	"database/sql"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
@@ -33,15 +32,73 @@ func Connect(uri string) (*mongodriver.Client, error) {
 	return client, nil
 }
 
-// GetMessages returns messages between two users ordered by created_at ascending.
-func GetMessages(ctx context.Context, client *mongodriver.Client, userID, friendID int) ([]models.Message, error) {
-	coll := client.Database("chat").Collection("messages")
-	filter := bson.M{"$or": []interface{}{
+// GetMessages returns one page of the conversation between two users, ordered by
+// (created_at, id) like GetMessagesSQLite. Cursors are SQLite message ids.
+func GetMessages(ctx context.Context, client *mongodriver.Client, userID, friendID int, req models.MessagePageRequest) (models.MessagePage, error) {
+	return pageMessages(ctx, client, bson.M{"$or": []interface{}{
 		bson.M{"sender_id": userID, "recipient_id": friendID},
 		bson.M{"sender_id": friendID, "recipient_id": userID},
-	}}
-	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}}).SetLimit(100)
+	}}, req)
+}
+
+// GetConversationMessages returns one page of a conversation's messages, ordered
+// and paged like GetMessages.
+func GetConversationMessages(ctx context.Context, client *mongodriver.Client, conversationID int, req models.MessagePageRequest) (models.MessagePage, error) {
+	return pageMessages(ctx, client, bson.M{"conversation_id": conversationID}, req)
+}
+
+// pageMessages returns one page of the messages matching conversation.
+func pageMessages(ctx context.Context, client *mongodriver.Client, conversation bson.M, req models.MessagePageRequest) (models.MessagePage, error) {
+	cursor, order, cmp := req.Before, -1, "$lt"
+	if req.After != 0 {
+		cursor, order, cmp = req.After, 1, "$gt"
+	}
+	filter := conversation
+	if cursor != 0 {
+		var anchor struct {
+			CreatedAt time.Time `bson:"created_at"`
+		}
+		coll := client.Database("chat").Collection("messages")
+		err := coll.FindOne(ctx, bson.M{"$and": []interface{}{conversation, bson.M{"id": cursor}}}).Decode(&anchor)
+		if err != nil {
+			return models.MessagePage{}, err
+		}
+		at := primitive.NewDateTimeFromTime(anchor.CreatedAt)
+		filter = bson.M{"$and": []interface{}{conversation, bson.M{"$or": []interface{}{
+			bson.M{"created_at": bson.M{cmp: at}},
+			bson.M{"created_at": at, "id": bson.M{cmp: cursor}},
+		}}}}
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: order}, {Key: "id", Value: order}}).
+		SetLimit(int64(req.Limit + 1))
+	messages, err := findMessages(ctx, client, filter, opts)
+	if err != nil {
+		return models.MessagePage{}, err
+	}
+	if err := fillMessages(ctx, client, messages); err != nil {
+		return models.MessagePage{}, err
+	}
+	return models.NewMessagePage(messages, req), nil
+}
+
+// Replies returns every message quoting the message with the given SQLite id, oldest first.
+func Replies(ctx context.Context, client *mongodriver.Client, id int) ([]models.Message, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}, {Key: "id", Value: 1}})
+	messages, err := findMessages(ctx, client, bson.M{"reply_to_id": id}, opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := fillMessages(ctx, client, messages); err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
 
+// findMessages decodes the message documents matching filter.
+func findMessages(ctx context.Context, client *mongodriver.Client, filter interface{}, opts *options.FindOptions) ([]models.Message, error) {
+	coll := client.Database("chat").Collection("messages")
 	cur, err := coll.Find(ctx, filter, opts)
 	if err != nil {
 		return nil, err
@@ -54,124 +111,210 @@ func GetMessages(ctx context.Context, client *mongodriver.Client, userID, friend
 		if err := cur.Decode(&doc); err != nil {
 			continue
 		}
+		messages = append(messages, decodeMessage(doc))
+	}
+	return messages, cur.Err()
+}
 
-		var msg models.Message
-		// map fields robustly
-		if v, ok := doc["sender_id"]; ok {
-			switch t := v.(type) {
-			case int32:
-				msg.SenderID = int(t)
-			case int64:
-				msg.SenderID = int(t)
-			case int:
-				msg.SenderID = t
-			case float64:
-				msg.SenderID = int(t)
-			}
+// fillMessages loads the reactions and reply previews of messages.
+func fillMessages(ctx context.Context, client *mongodriver.Client, messages []models.Message) error {
+	ids := make([]int, 0, len(messages))
+	var replyIDs []int
+	for _, msg := range messages {
+		if msg.ID != 0 {
+			ids = append(ids, msg.ID)
 		}
-		if v, ok := doc["recipient_id"]; ok {
-			switch t := v.(type) {
-			case int32:
-				msg.RecipientID = int(t)
-			case int64:
-				msg.RecipientID = int(t)
-			case int:
-				msg.RecipientID = t
-			case float64:
-				msg.RecipientID = int(t)
-			}
+		if msg.ReplyToID != nil {
+			replyIDs = append(replyIDs, *msg.ReplyToID)
 		}
-		if v, ok := doc["sender_name"]; ok {
-			if s, ok := v.(string); ok {
-				msg.SenderName = s
-			}
-		}
-		if v, ok := doc["message"]; ok {
-			if s, ok := v.(string); ok {
-				msg.Message = s
-			}
+	}
+
+	counts, err := reactionsFor(ctx, client, ids)
+	if err != nil {
+		return err
+	}
+	previews := make(map[int]models.MessagePreview)
+	if len(replyIDs) > 0 {
+		quoted, err := findMessages(ctx, client, bson.M{"id": bson.M{"$in": replyIDs}}, options.Find())
+		if err != nil {
+			return err
 		}
-		if v, ok := doc["is_read"]; ok {
-			if b, ok := v.(bool); ok {
-				msg.IsRead = b
-			}
+		for _, q := range quoted {
+			previews[q.ID] = q.Preview()
 		}
-		if v, ok := doc["created_at"]; ok {
-			switch t := v.(type) {
-			case primitive.DateTime:
-				msg.CreatedAt = t.Time().UTC()
-			case time.Time:
-				msg.CreatedAt = t.UTC()
+	}
+
+	for i := range messages {
+		messages[i].Reactions = counts[messages[i].ID]
+		if messages[i].ReplyToID != nil {
+			if p, ok := previews[*messages[i].ReplyToID]; ok {
+				messages[i].ReplyTo = &p
 			}
 		}
-
-		messages = append(messages, msg)
 	}
+	return nil
+}
 
-	return messages, nil
+// decodeMessage maps a message document to models.Message, tolerating the
+// different types older documents were written with.
+func decodeMessage(doc bson.M) models.Message {
+	var msg models.Message
+	// map fields robustly
+	if v, ok := doc["id"]; ok {
+		msg.ID = intValue(v)
+	}
+	if v, ok := doc["conversation_id"]; ok {
+		msg.ConversationID = intValue(v)
+	}
+	if v, ok := doc["sender_id"]; ok {
+		msg.SenderID = intValue(v)
+	}
+	if v, ok := doc["recipient_id"]; ok {
+		msg.RecipientID = intValue(v)
+	}
+	if v, ok := doc["sender_name"]; ok {
+		if s, ok := v.(string); ok {
+			msg.SenderName = s
+		}
+	}
+	if v, ok := doc["message"]; ok {
+		if s, ok := v.(string); ok {
+			msg.Message = s
+		}
+	}
+	if v, ok := doc["is_read"]; ok {
+		if b, ok := v.(bool); ok {
+			msg.IsRead = b
+		}
+	}
+	if v, ok := doc["created_at"]; ok {
+		switch t := v.(type) {
+		case primitive.DateTime:
+			msg.CreatedAt = t.Time().UTC()
+		case time.Time:
+			msg.CreatedAt = t.UTC()
+		}
+	}
+	if v, ok := doc["edited_at"]; ok {
+		msg.EditedAt = timeValue(v)
+	}
+	if v, ok := doc["deleted_at"]; ok {
+		msg.DeletedAt = timeValue(v)
+	}
+	if v, ok := doc["attachments"]; ok {
+		msg.Attachments = decodeAttachments(v)
+	}
+	if v, ok := doc["reply_to_id"]; ok && v != nil {
+		id := intValue(v)
+		msg.ReplyToID = &id
+	}
+	return msg
 }
 
 // InsertMessage inserts a message document into Mongo.
 func InsertMessage(ctx context.Context, client *mongodriver.Client, msg models.Message) error {
 	coll := client.Database("chat").Collection("messages")
-	// store created_at as UTC DateTime
-	created := primitive.NewDateTimeFromTime(msg.CreatedAt.UTC())
-	_, err := coll.InsertOne(ctx, bson.M{
-		"sender_id":    msg.SenderID,
-		"sender_name":  msg.SenderName,
-		"recipient_id": msg.RecipientID,
-		"message":      msg.Message,
-		"is_read":      msg.IsRead,
-		"created_at":   created,
-	})
+	_, err := coll.InsertOne(ctx, messageDoc(msg))
 	return err
 }
 
-// MarkMessagesRead marks messages sent by senderID to recipientID as read.
-func MarkMessagesRead(ctx context.Context, client *mongodriver.Client, senderID, recipientID int) error {
+// UpsertMessage writes msg's document, replacing the fields of an existing copy.
+func UpsertMessage(ctx context.Context, client *mongodriver.Client, msg models.Message) error {
 	coll := client.Database("chat").Collection("messages")
-	_, err := coll.UpdateMany(ctx, bson.M{"sender_id": senderID, "recipient_id": recipientID}, bson.M{"$set": bson.M{"is_read": true}})
+	_, err := coll.UpdateOne(ctx, bson.M{"id": msg.ID}, bson.M{"$set": messageDoc(msg)}, options.Update().SetUpsert(true))
 	return err
 }
 
-// CountUnread returns number of unread messages for a recipient.
-func CountUnread(ctx context.Context, client *mongodriver.Client, recipientID int) (int64, error) {
-	coll := client.Database("chat").Collection("messages")
-	cnt, err := coll.CountDocuments(ctx, bson.M{"recipient_id": recipientID, "is_read": false})
-	return cnt, err
-}
-
-// MigrateFromSQLite copies messages from SQLite into Mongo. It does not delete SQLite rows.
-func MigrateFromSQLite(ctx context.Context, client *mongodriver.Client, sqlDB *sql.DB) error {
-	rows, err := sqlDB.Query(`
-        SELECT m.id, m.sender_id, u.username, m.recipient_id, m.message, m.is_read, m.created_at
-        FROM messages m
-        JOIN users u ON m.sender_id = u.id
-        ORDER BY m.created_at ASC
-    `)
-	if err != nil {
-		return err
+// messageDoc is the document stored for msg. Dates are stored as UTC DateTimes.
+func messageDoc(msg models.Message) bson.M {
+	doc := bson.M{
+		"id":              msg.ID,
+		"conversation_id": msg.ConversationID,
+		"sender_id":       msg.SenderID,
+		"sender_name":     msg.SenderName,
+		"recipient_id":    msg.RecipientID,
+		"message":         msg.Message,
+		"is_read":         msg.IsRead,
+		"created_at":      primitive.NewDateTimeFromTime(msg.CreatedAt.UTC()),
+		"attachments":     attachmentDocs(msg.Attachments),
+		"reply_to_id":     msg.ReplyToID,
+	}
+	if msg.EditedAt != nil {
+		doc["edited_at"] = primitive.NewDateTimeFromTime(msg.EditedAt.UTC())
+	}
+	if msg.DeletedAt != nil {
+		doc["deleted_at"] = primitive.NewDateTimeFromTime(msg.DeletedAt.UTC())
 	}
-	defer rows.Close()
+	return doc
+}
 
-	coll := client.Database("chat").Collection("messages")
+// intValue reads a numeric field whatever BSON number type it was stored as.
+func intValue(v interface{}) int {
+	switch t := v.(type) {
+	case int32:
+		return int(t)
+	case int64:
+		return int(t)
+	case int:
+		return t
+	case float64:
+		return int(t)
+	}
+	return 0
+}
+
+// timeValue reads an optional date field; null or missing dates return nil.
+func timeValue(v interface{}) *time.Time {
+	var t time.Time
+	switch d := v.(type) {
+	case primitive.DateTime:
+		t = d.Time().UTC()
+	case time.Time:
+		t = d.UTC()
+	default:
+		return nil
+	}
+	return &t
+}
 
-	for rows.Next() {
-		var msg models.Message
-		if err := rows.Scan(&msg.ID, &msg.SenderID, &msg.SenderName, &msg.RecipientID, &msg.Message, &msg.IsRead, &msg.CreatedAt); err != nil {
+// attachmentDoc is the attachment metadata embedded in a message document.
+type attachmentDoc struct {
+	ID         string    `bson:"id"`
+	UploaderID int       `bson:"uploader_id"`
+	Filename   string    `bson:"filename"`
+	MimeType   string    `bson:"mime_type"`
+	Size       int64     `bson:"size"`
+	SHA256     string    `bson:"sha256"`
+	CreatedAt  time.Time `bson:"created_at"`
+}
+
+func attachmentDocs(attachments []models.Attachment) []attachmentDoc {
+	docs := make([]attachmentDoc, 0, len(attachments))
+	for _, a := range attachments {
+		docs = append(docs, attachmentDoc(a))
+	}
+	return docs
+}
+
+// decodeAttachments reads the embedded attachments array, whatever document type
+// the driver decoded its elements to.
+func decodeAttachments(v interface{}) []models.Attachment {
+	arr, ok := v.(primitive.A)
+	if !ok {
+		return nil
+	}
+	var out []models.Attachment
+	for _, item := range arr {
+		raw, err := bson.Marshal(item)
+		if err != nil {
+			continue
+		}
+		var d attachmentDoc
+		if err := bson.Unmarshal(raw, &d); err != nil {
 			continue
 		}
-		// Insert into Mongo; ignore errors to be resilient
-		created := primitive.NewDateTimeFromTime(msg.CreatedAt.UTC())
-		_, _ = coll.InsertOne(ctx, bson.M{
-			"sender_id":    msg.SenderID,
-			"sender_name":  msg.SenderName,
-			"recipient_id": msg.RecipientID,
-			"message":      msg.Message,
-			"is_read":      msg.IsRead,
-			"created_at":   created,
-		})
+		d.CreatedAt = d.CreatedAt.UTC()
+		out = append(out, models.Attachment(d))
 	}
-
-	return nil
+	return out
 }
@@ -0,0 +1,32 @@
+package mongo
+
+import (
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestDecodeMessageIDs(t *testing.T) {
+	tests := []struct {
+		name string
+		v    interface{}
+	}{
+		{"int32", int32(7)},
+		{"int64", int64(7)},
+		{"int", 7},
+		{"double", float64(7)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := decodeMessage(bson.M{"id": tt.v, "conversation_id": tt.v, "sender_id": tt.v, "recipient_id": tt.v, "reply_to_id": tt.v})
+			if msg.ID != 7 || msg.ConversationID != 7 || msg.SenderID != 7 || msg.RecipientID != 7 || msg.ReplyToID == nil || *msg.ReplyToID != 7 {
+				t.Errorf("got %+v, want every id 7", msg)
+			}
+		})
+	}
+
+	// Group messages have no recipient
+	if msg := decodeMessage(bson.M{"id": int32(1), "recipient_id": nil, "reply_to_id": nil}); msg.RecipientID != 0 || msg.ReplyToID != nil {
+		t.Errorf("null ids: got recipient %d and reply %v, want 0 and nil", msg.RecipientID, msg.ReplyToID)
+	}
+}
@@ -0,0 +1,168 @@
+package mongo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"expvar"
+	"log"
+	"sync"
+	"time"
+
+	mongodriver "go.mongodb.org/mongo-driver/mongo"
+
+	dbsqlite "DB-Presentation/database/sqlite"
+)
+
+const (
+	// relayBatch is how many outbox entries the relay reads at a time.
+	relayBatch = 100
+	// relayPollInterval is how often the relay looks for due entries when not notified.
+	relayPollInterval = 5 * time.Second
+	// relayMaxBackoff caps the wait between attempts to deliver one entry.
+	relayMaxBackoff = 5 * time.Minute
+	// outboxRetention is how long delivered entries are kept.
+	outboxRetention = 24 * time.Hour
+)
+
+// Outbox metrics, served by expvar alongside outbox_backlog.
+var (
+	outboxDelivered = expvar.NewInt("outbox_delivered")
+	outboxFailures  = expvar.NewInt("outbox_failures")
+	backlogOnce     sync.Once
+)
+
+// Relay copies the messages queued in SQLite's message_outbox to Mongo: new,
+// edited, deleted and read messages and reaction changes alike. A failed delivery is retried with exponential
+// backoff. Delivery writes the message's current SQLite state by id, so retries
+// never duplicate a message.
+type Relay struct {
+	client *mongodriver.Client
+	db     *sql.DB
+	wake   chan struct{}
+}
+
+// NewRelay returns a relay from sqlDB's outbox to client and publishes the
+// outbox_backlog expvar.
+func NewRelay(client *mongodriver.Client, sqlDB *sql.DB) *Relay {
+	backlogOnce.Do(func() {
+		expvar.Publish("outbox_backlog", expvar.Func(func() interface{} {
+			b, err := dbsqlite.OutboxBacklog(sqlDB)
+			if err != nil {
+				return map[string]string{"error": err.Error()}
+			}
+			return b
+		}))
+	})
+	return &Relay{client: client, db: sqlDB, wake: make(chan struct{}, 1)}
+}
+
+// Notify makes the relay deliver new entries without waiting for the next poll.
+func (r *Relay) Notify() {
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run delivers outbox entries until ctx is done.
+func (r *Relay) Run(ctx context.Context) {
+	poll := time.NewTicker(relayPollInterval)
+	defer poll.Stop()
+	prune := time.NewTicker(time.Hour)
+	defer prune.Stop()
+
+	for {
+		r.deliverPending(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-r.wake:
+		case <-poll.C:
+		case <-prune.C:
+			if _, err := dbsqlite.PruneOutbox(r.db, outboxRetention); err != nil {
+				log.Println("warning: could not prune the message outbox:", err)
+			}
+		}
+	}
+}
+
+// deliverPending delivers due entries in order. It stops at the first failure,
+// since Mongo is then most likely unavailable.
+func (r *Relay) deliverPending(ctx context.Context) {
+	for {
+		entries, err := dbsqlite.PendingOutbox(r.db, relayBatch)
+		if err != nil {
+			log.Println("warning: could not read the message outbox:", err)
+			return
+		}
+		for _, e := range entries {
+			if err := r.deliver(ctx, e.MessageID); err != nil {
+				outboxFailures.Add(1)
+				retryIn := relayBackoff(e.Attempts)
+				log.Printf("warning: could not copy message %d to mongo (attempt %d, retrying in %s): %v", e.MessageID, e.Attempts+1, retryIn, err)
+				if err := dbsqlite.MarkOutboxFailed(r.db, e.ID, err, retryIn); err != nil {
+					log.Println("warning: could not update the message outbox:", err)
+				}
+				return
+			}
+			if err := dbsqlite.MarkOutboxDone(r.db, e.ID); err != nil {
+				log.Println("warning: could not update the message outbox:", err)
+				return
+			}
+			outboxDelivered.Add(1)
+		}
+		if len(entries) < relayBatch {
+			return
+		}
+	}
+}
+
+// deliver copies a message's current state, with its edit history and reactions,
+// into Mongo.
+func (r *Relay) deliver(ctx context.Context, messageID int) error {
+	msg, err := dbsqlite.GetMessageSQLite(r.db, messageID)
+	if errors.Is(err, sql.ErrNoRows) {
+		// Nothing left to copy
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	edits, err := dbsqlite.MessageEditsSQLite(r.db, messageID)
+	if err != nil {
+		return err
+	}
+	reactionList, err := dbsqlite.ReactionsSQLite(r.db, messageID)
+	if err != nil {
+		return err
+	}
+
+	mctx, cancel := context.WithTimeout(ctx, storeTimeout)
+	defer cancel()
+	return SyncMessage(mctx, r.client, msg, edits, reactionList)
+}
+
+// Delivered reports whether Mongo has every change queued for the conversation, or
+// for any conversation when conversationID is 0. It reports false if the outbox
+// cannot be read.
+func (r *Relay) Delivered(conversationID int) bool {
+	pending, err := dbsqlite.OutboxPending(r.db, conversationID)
+	if err != nil {
+		log.Println("warning: could not read the message outbox:", err)
+		return false
+	}
+	return !pending
+}
+
+// relayBackoff is the wait after an entry's attempts+1'th failure: 1s, 2s, 4s, ...
+// up to relayMaxBackoff.
+func relayBackoff(attempts int) time.Duration {
+	if attempts >= 16 {
+		return relayMaxBackoff
+	}
+	if d := time.Second << attempts; d < relayMaxBackoff {
+		return d
+	}
+	return relayMaxBackoff
+}
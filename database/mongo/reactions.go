@@ -0,0 +1,136 @@
+package mongo
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	mongodriver "go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"DB-Presentation/models"
+)
+
+var reactionIndexOnce sync.Once
+
+// reactions returns the message_reactions collection, creating its unique
+// (message_id, user_id, emoji) index on first use.
+func reactions(ctx context.Context, client *mongodriver.Client) *mongodriver.Collection {
+	coll := client.Database("chat").Collection("message_reactions")
+	reactionIndexOnce.Do(func() {
+		_, _ = coll.Indexes().CreateOne(ctx, mongodriver.IndexModel{
+			Keys:    bson.D{{Key: "message_id", Value: 1}, {Key: "user_id", Value: 1}, {Key: "emoji", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		})
+	})
+	return coll
+}
+
+// reactionDocFor is the message_reactions document stored for r.
+func reactionDocFor(r models.MessageReaction) bson.M {
+	return bson.M{
+		"message_id": r.MessageID,
+		"user_id":    r.UserID,
+		"username":   r.Username,
+		"emoji":      r.Emoji,
+		"created_at": primitive.NewDateTimeFromTime(r.CreatedAt.UTC()),
+	}
+}
+
+// Reactions lists every reaction on a message, oldest first.
+func Reactions(ctx context.Context, client *mongodriver.Client, messageID int) ([]models.MessageReaction, error) {
+	reactions, err := reactionListsFor(ctx, client, []int{messageID})
+	if err != nil {
+		return nil, err
+	}
+	if reactions[messageID] == nil {
+		return []models.MessageReaction{}, nil
+	}
+	return reactions[messageID], nil
+}
+
+// reactionListsFor lists every reaction on the given messages, oldest first, keyed
+// by message id.
+func reactionListsFor(ctx context.Context, client *mongodriver.Client, messageIDs []int) (map[int][]models.MessageReaction, error) {
+	out := make(map[int][]models.MessageReaction)
+	if len(messageIDs) == 0 {
+		return out, nil
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}, {Key: "_id", Value: 1}})
+	cur, err := reactions(ctx, client).Find(ctx, bson.M{"message_id": bson.M{"$in": messageIDs}}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	for cur.Next(ctx) {
+		var doc reactionDoc
+		if err := cur.Decode(&doc); err != nil {
+			continue
+		}
+		out[doc.MessageID] = append(out[doc.MessageID], models.MessageReaction{
+			MessageID: doc.MessageID,
+			UserID:    doc.UserID,
+			Username:  doc.Username,
+			Emoji:     doc.Emoji,
+			CreatedAt: doc.CreatedAt.UTC(),
+		})
+	}
+	return out, cur.Err()
+}
+
+// ReactionCounts returns the aggregated reactions on one message.
+func ReactionCounts(ctx context.Context, client *mongodriver.Client, messageID int) ([]models.Reaction, error) {
+	counts, err := reactionsFor(ctx, client, []int{messageID})
+	if err != nil {
+		return nil, err
+	}
+	return counts[messageID], nil
+}
+
+type reactionDoc struct {
+	MessageID int       `bson:"message_id"`
+	UserID    int       `bson:"user_id"`
+	Username  string    `bson:"username"`
+	Emoji     string    `bson:"emoji"`
+	CreatedAt time.Time `bson:"created_at"`
+}
+
+// reactionsFor aggregates the reactions on the given messages per emoji, in the
+// order each emoji was first used.
+func reactionsFor(ctx context.Context, client *mongodriver.Client, messageIDs []int) (map[int][]models.Reaction, error) {
+	out := make(map[int][]models.Reaction)
+	if len(messageIDs) == 0 {
+		return out, nil
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}, {Key: "_id", Value: 1}})
+	cur, err := reactions(ctx, client).Find(ctx, bson.M{"message_id": bson.M{"$in": messageIDs}}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	for cur.Next(ctx) {
+		var doc reactionDoc
+		if err := cur.Decode(&doc); err != nil {
+			continue
+		}
+		out[doc.MessageID] = appendReaction(out[doc.MessageID], doc.Emoji, doc.UserID)
+	}
+	return out, cur.Err()
+}
+
+func appendReaction(reactions []models.Reaction, emoji string, userID int) []models.Reaction {
+	for i := range reactions {
+		if reactions[i].Emoji == emoji {
+			reactions[i].Count++
+			reactions[i].UserIDs = append(reactions[i].UserIDs, userID)
+			return reactions
+		}
+	}
+	return append(reactions, models.Reaction{Emoji: emoji, Count: 1, UserIDs: []int{userID}})
+}
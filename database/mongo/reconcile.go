@@ -0,0 +1,159 @@
+package mongo
+
+import (
+	"context"
+	"database/sql"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	mongodriver "go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"DB-Presentation/models"
+
+	dbsqlite "DB-Presentation/database/sqlite"
+)
+
+// reconcileBatch is how many messages Reconcile compares at a time.
+const reconcileBatch = 500
+
+// ReconcileReport counts the differences Reconcile found between the two copies.
+type ReconcileReport struct {
+	Checked   int // SQLite messages compared
+	Missing   int // SQLite messages with no Mongo document
+	ReadState int // documents whose is_read differs
+	Content   int // documents whose conversation, text, edit or deletion differs
+	Extra     int // Mongo documents with no SQLite message
+}
+
+// Diverged reports whether any difference was found.
+func (r ReconcileReport) Diverged() bool {
+	return r.Missing+r.ReadState+r.Content+r.Extra > 0
+}
+
+// Reconcile compares every message in SQLite, the source of truth, with its document
+// in Mongo. With repair set, diverged and missing documents are rewritten from SQLite
+// and documents with no SQLite message (including ones without an id) are deleted.
+// Edit history and reactions are not compared.
+func Reconcile(ctx context.Context, client *mongodriver.Client, sqlDB *sql.DB, repair bool) (ReconcileReport, error) {
+	var report ReconcileReport
+	coll := client.Database("chat").Collection("messages")
+
+	for after := 0; ; {
+		batch, err := dbsqlite.MessagesAfterSQLite(sqlDB, after, reconcileBatch)
+		if err != nil {
+			return report, err
+		}
+		if len(batch) == 0 {
+			break
+		}
+		after = batch[len(batch)-1].ID
+
+		ids := make([]int, len(batch))
+		for i, msg := range batch {
+			ids[i] = msg.ID
+		}
+		docs, err := findMessages(ctx, client, bson.M{"id": bson.M{"$in": ids}}, options.Find())
+		if err != nil {
+			return report, err
+		}
+		copies := make(map[int]models.Message, len(docs))
+		for _, doc := range docs {
+			copies[doc.ID] = doc
+		}
+
+		for _, msg := range batch {
+			report.Checked++
+			doc, ok := copies[msg.ID]
+			diverged := !ok
+			switch {
+			case !ok:
+				report.Missing++
+			case doc.IsRead != msg.IsRead:
+				report.ReadState++
+				diverged = true
+			}
+			if ok && contentDiffers(doc, msg) {
+				report.Content++
+				diverged = true
+			}
+			if diverged && repair {
+				// Upserting also covers missing documents; UpdateMany fixes every duplicate
+				_, err := coll.UpdateMany(ctx, bson.M{"id": msg.ID}, bson.M{"$set": messageDoc(msg)}, options.Update().SetUpsert(true))
+				if err != nil {
+					return report, err
+				}
+			}
+		}
+	}
+
+	extra, err := extraDocuments(ctx, coll, sqlDB)
+	if err != nil {
+		return report, err
+	}
+	report.Extra = len(extra)
+	if repair && len(extra) > 0 {
+		if _, err := coll.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": extra}}); err != nil {
+			return report, err
+		}
+	}
+	return report, nil
+}
+
+// contentDiffers compares the fields of a Mongo copy that edits and deletes change.
+func contentDiffers(doc, msg models.Message) bool {
+	return doc.ConversationID != msg.ConversationID ||
+		doc.Message != msg.Message ||
+		(doc.EditedAt == nil) != (msg.EditedAt == nil) ||
+		(doc.DeletedAt == nil) != (msg.DeletedAt == nil)
+}
+
+// extraDocuments returns the _ids of message documents whose id is not a SQLite message.
+func extraDocuments(ctx context.Context, coll *mongodriver.Collection, sqlDB *sql.DB) ([]primitive.ObjectID, error) {
+	cur, err := coll.Find(ctx, bson.M{}, options.Find().SetProjection(bson.M{"id": 1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	var extra []primitive.ObjectID
+	pending := make(map[primitive.ObjectID]int)
+	check := func() error {
+		ids := make([]int, 0, len(pending))
+		for _, id := range pending {
+			ids = append(ids, id)
+		}
+		exists, err := dbsqlite.ExistingMessageIDs(sqlDB, ids)
+		if err != nil {
+			return err
+		}
+		for oid, id := range pending {
+			if !exists[id] {
+				extra = append(extra, oid)
+			}
+		}
+		pending = make(map[primitive.ObjectID]int)
+		return nil
+	}
+
+	for cur.Next(ctx) {
+		var doc bson.M
+		if err := cur.Decode(&doc); err != nil {
+			return nil, err
+		}
+		oid, _ := doc["_id"].(primitive.ObjectID)
+		pending[oid] = intValue(doc["id"])
+		if len(pending) == reconcileBatch {
+			if err := check(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := cur.Err(); err != nil {
+		return nil, err
+	}
+	if err := check(); err != nil {
+		return nil, err
+	}
+	return extra, nil
+}
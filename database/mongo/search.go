@@ -0,0 +1,114 @@
+package mongo
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	mongodriver "go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"DB-Presentation/models"
+	"DB-Presentation/utils"
+)
+
+// textIndex records whether the text index on message has been created.
+var textIndex struct {
+	sync.Mutex
+	ready bool
+}
+
+// searchableMessages returns the messages collection, creating the text index on
+// message on first use. A failed attempt is returned and retried on the next search.
+func searchableMessages(ctx context.Context, client *mongodriver.Client) (*mongodriver.Collection, error) {
+	coll := client.Database("chat").Collection("messages")
+	textIndex.Lock()
+	defer textIndex.Unlock()
+	if textIndex.ready {
+		return coll, nil
+	}
+
+	_, err := coll.Indexes().CreateOne(ctx, mongodriver.IndexModel{
+		Keys:    bson.D{{Key: "message", Value: "text"}},
+		Options: options.Index().SetName("message_text"),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("creating the message text index: %w", err)
+	}
+	textIndex.ready = true
+	return coll, nil
+}
+
+// SearchMessages finds messages in the user's conversations matching every search
+// term through the text index (so words match by stem), newest first. Deleted
+// messages are never returned. Before is a SQLite message id from a previous page.
+func SearchMessages(ctx context.Context, client *mongodriver.Client, userID int, req models.SearchRequest) (models.SearchPage, error) {
+	coll, err := searchableMessages(ctx, client)
+	if err != nil {
+		return models.SearchPage{}, err
+	}
+
+	// Quoting each term makes $text require all of them
+	quoted := make([]string, len(req.Terms))
+	for i, t := range req.Terms {
+		quoted[i] = `"` + t + `"`
+	}
+	// $in needs an array, never null
+	ids := append([]int{}, req.ConversationIDs...)
+	// Documents written before conversations existed only have sender and recipient
+	member := bson.M{"$or": []interface{}{
+		bson.M{"conversation_id": bson.M{"$in": ids}},
+		bson.M{"sender_id": userID, "conversation_id": bson.M{"$exists": false}},
+		bson.M{"recipient_id": userID, "conversation_id": bson.M{"$exists": false}},
+	}}
+	and := []interface{}{
+		bson.M{"$text": bson.M{"$search": strings.Join(quoted, " ")}},
+		member,
+		bson.M{"deleted_at": nil},
+	}
+	if req.FriendID != 0 {
+		and = append(and, bson.M{"recipient_id": bson.M{"$gt": 0}}, bson.M{"$or": []interface{}{bson.M{"sender_id": req.FriendID}, bson.M{"recipient_id": req.FriendID}}})
+	}
+	if req.ConversationID != 0 {
+		and = append(and, bson.M{"conversation_id": req.ConversationID})
+	}
+	if req.From != nil {
+		and = append(and, bson.M{"created_at": bson.M{"$gte": primitive.NewDateTimeFromTime(req.From.UTC())}})
+	}
+	if req.To != nil {
+		and = append(and, bson.M{"created_at": bson.M{"$lt": primitive.NewDateTimeFromTime(req.To.UTC())}})
+	}
+	if req.Before != 0 {
+		var anchor struct {
+			CreatedAt primitive.DateTime `bson:"created_at"`
+		}
+		visible := bson.M{"$and": []interface{}{bson.M{"id": req.Before}, member}}
+		if err := coll.FindOne(ctx, visible).Decode(&anchor); err != nil {
+			return models.SearchPage{}, err
+		}
+		and = append(and, bson.M{"$or": []interface{}{
+			bson.M{"created_at": bson.M{"$lt": anchor.CreatedAt}},
+			bson.M{"created_at": anchor.CreatedAt, "id": bson.M{"$lt": req.Before}},
+		}})
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: -1}, {Key: "id", Value: -1}}).
+		SetLimit(int64(req.Limit + 1))
+	found, err := findMessages(ctx, client, bson.M{"$and": and}, opts)
+	if err != nil {
+		return models.SearchPage{}, err
+	}
+	if err := fillMessages(ctx, client, found); err != nil {
+		return models.SearchPage{}, err
+	}
+
+	results := make([]models.SearchResult, len(found))
+	for i, msg := range found {
+		results[i] = models.SearchResult{Message: msg, Snippet: utils.HighlightSnippet(msg.Message, req.Terms)}
+	}
+	return models.NewSearchPage(results, req.Limit), nil
+}
@@ -0,0 +1,198 @@
+package mongo
+
+import (
+	"context"
+	"log"
+	"time"
+
+	mongodriver "go.mongodb.org/mongo-driver/mongo"
+
+	"DB-Presentation/models"
+	"DB-Presentation/storage"
+
+	dbsqlite "DB-Presentation/database/sqlite"
+)
+
+// storeTimeout bounds each Mongo call made by MessageStore.
+const storeTimeout = 5 * time.Second
+
+// MessageStore serves messages from Mongo in front of a primary store. Writes go
+// to the primary first (it assigns ids and owns attachments, memberships and read
+// state). Every change to a message, its read state and its reactions included,
+// reaches Mongo through the relay, which the primary's outbox feeds.
+//
+// The relay lags behind the primary, so message reads go to Mongo only once it
+// has delivered everything queued for the conversation, and to the primary until
+// then. Reads that fail in Mongo fall back to the primary too. Unread counts
+// always come from the primary.
+type MessageStore struct {
+	client  *mongodriver.Client
+	primary storage.MessageStore
+	relay   *Relay
+}
+
+var _ storage.MessageStore = (*MessageStore)(nil)
+
+// NewMessageStore returns a MessageStore mirroring primary into client's chat
+// database. primary must queue every changed message in the outbox relay delivers.
+func NewMessageStore(client *mongodriver.Client, primary storage.MessageStore, relay *Relay) *MessageStore {
+	return &MessageStore{client: client, primary: primary, relay: relay}
+}
+
+func (s *MessageStore) Insert(ctx context.Context, senderID int, req models.SendMessageRequest) (models.Message, error) {
+	msg, err := s.primary.Insert(ctx, senderID, req)
+	if err == nil {
+		s.relay.Notify()
+	}
+	return msg, err
+}
+
+// Get reads the primary, which has the message's attachments.
+func (s *MessageStore) Get(ctx context.Context, id int) (models.Message, error) {
+	return s.primary.Get(ctx, id)
+}
+
+func (s *MessageStore) List(ctx context.Context, userID, friendID int, req models.MessagePageRequest) (models.MessagePage, error) {
+	// Without a direct conversation there is nothing to read, and the primary says so
+	if id, err := dbsqlite.DirectConversationID(s.relay.db, userID, friendID); err == nil && s.relay.Delivered(id) {
+		mctx, cancel := context.WithTimeout(ctx, storeTimeout)
+		defer cancel()
+		if page, err := GetMessages(mctx, s.client, userID, friendID, req); err == nil {
+			return page, nil
+		}
+	}
+	return s.primary.List(ctx, userID, friendID, req)
+}
+
+func (s *MessageStore) ListConversation(ctx context.Context, conversationID int, req models.MessagePageRequest) (models.MessagePage, error) {
+	if s.relay.Delivered(conversationID) {
+		mctx, cancel := context.WithTimeout(ctx, storeTimeout)
+		defer cancel()
+		if page, err := GetConversationMessages(mctx, s.client, conversationID, req); err == nil {
+			return page, nil
+		}
+	}
+	return s.primary.ListConversation(ctx, conversationID, req)
+}
+
+func (s *MessageStore) Replies(ctx context.Context, id int) ([]models.Message, error) {
+	if s.delivered(ctx, id) {
+		mctx, cancel := context.WithTimeout(ctx, storeTimeout)
+		defer cancel()
+		if replies, err := Replies(mctx, s.client, id); err == nil {
+			return replies, nil
+		}
+	}
+	return s.primary.Replies(ctx, id)
+}
+
+func (s *MessageStore) MarkRead(ctx context.Context, userID, friendID int) error {
+	err := s.primary.MarkRead(ctx, userID, friendID)
+	if err == nil {
+		s.relay.Notify()
+	}
+	return err
+}
+
+// MarkConversationRead only updates the primary: group read state lives with the
+// memberships, which are not mirrored.
+func (s *MessageStore) MarkConversationRead(ctx context.Context, conversationID, userID int) error {
+	return s.primary.MarkConversationRead(ctx, conversationID, userID)
+}
+
+// CountUnread reads the primary: read state is authoritative there and reaches
+// Mongo later, so badges agree with the per-conversation counts.
+func (s *MessageStore) CountUnread(ctx context.Context, userID int, conversationIDs []int) (int, error) {
+	return s.primary.CountUnread(ctx, userID, conversationIDs)
+}
+
+// UnreadCounts reads the primary, like CountUnread.
+func (s *MessageStore) UnreadCounts(ctx context.Context, userID int) (map[int]int, error) {
+	return s.primary.UnreadCounts(ctx, userID)
+}
+
+func (s *MessageStore) Edit(ctx context.Context, id int, message string, editedAt time.Time) (models.Message, error) {
+	msg, err := s.primary.Edit(ctx, id, message, editedAt)
+	if err == nil {
+		s.relay.Notify()
+	}
+	return msg, err
+}
+
+func (s *MessageStore) Delete(ctx context.Context, id int, deletedAt time.Time) ([]string, error) {
+	attachmentIDs, err := s.primary.Delete(ctx, id, deletedAt)
+	if err == nil {
+		s.relay.Notify()
+	}
+	return attachmentIDs, err
+}
+
+// Edits reads Mongo only for edited messages, and only trusts a history it finds
+// there: messages copied before migrations carried their edits have none.
+func (s *MessageStore) Edits(ctx context.Context, id int) ([]models.MessageEdit, error) {
+	msg, err := s.primary.Get(ctx, id)
+	if err == nil && msg.EditedAt != nil && s.relay.Delivered(msg.ConversationID) {
+		mctx, cancel := context.WithTimeout(ctx, storeTimeout)
+		defer cancel()
+		if edits, err := MessageEdits(mctx, s.client, id); err == nil && len(edits) > 0 {
+			return edits, nil
+		}
+	}
+	return s.primary.Edits(ctx, id)
+}
+
+func (s *MessageStore) AddReaction(ctx context.Context, r models.MessageReaction) (bool, error) {
+	changed, err := s.primary.AddReaction(ctx, r)
+	if changed {
+		s.relay.Notify()
+	}
+	return changed, err
+}
+
+func (s *MessageStore) RemoveReaction(ctx context.Context, messageID, userID int, emoji string) (bool, error) {
+	changed, err := s.primary.RemoveReaction(ctx, messageID, userID, emoji)
+	if changed {
+		s.relay.Notify()
+	}
+	return changed, err
+}
+
+func (s *MessageStore) Reactions(ctx context.Context, messageID int) ([]models.MessageReaction, error) {
+	if s.delivered(ctx, messageID) {
+		mctx, cancel := context.WithTimeout(ctx, storeTimeout)
+		defer cancel()
+		if reactions, err := Reactions(mctx, s.client, messageID); err == nil {
+			return reactions, nil
+		}
+	}
+	return s.primary.Reactions(ctx, messageID)
+}
+
+// ReactionCounts reads the primary so that counts returned right after a change
+// never lag behind it.
+func (s *MessageStore) ReactionCounts(ctx context.Context, messageID int) ([]models.Reaction, error) {
+	return s.primary.ReactionCounts(ctx, messageID)
+}
+
+// Search needs req.ConversationIDs to find the caller's conversations. It spans
+// conversations, so it reads Mongo only when the relay has nothing left to deliver.
+func (s *MessageStore) Search(ctx context.Context, userID int, req models.SearchRequest) (models.SearchPage, error) {
+	if !s.relay.Delivered(0) {
+		return s.primary.Search(ctx, userID, req)
+	}
+	mctx, cancel := context.WithTimeout(ctx, storeTimeout)
+	defer cancel()
+	page, err := SearchMessages(mctx, s.client, userID, req)
+	if err == nil {
+		return page, nil
+	}
+	log.Printf("warning: mongo search failed, searching sqlite instead: %v", err)
+	return s.primary.Search(ctx, userID, req)
+}
+
+// delivered reports whether the relay has delivered everything queued for the
+// conversation of message id.
+func (s *MessageStore) delivered(ctx context.Context, id int) bool {
+	msg, err := s.primary.Get(ctx, id)
+	return err == nil && s.relay.Delivered(msg.ConversationID)
+}
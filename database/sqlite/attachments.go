@@ -0,0 +1,124 @@
+package sqlite
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	"DB-Presentation/models"
+	"DB-Presentation/storage"
+)
+
+// ErrAttachmentUnavailable is returned when a message references attachments that
+// do not exist, belong to someone else or were already sent.
+var ErrAttachmentUnavailable = storage.ErrAttachmentUnavailable
+
+// InsertAttachment records an uploaded, not yet sent attachment.
+func InsertAttachment(db *sql.DB, a models.Attachment) error {
+	_, err := db.Exec(`
+		INSERT INTO attachments (id, uploader_id, filename, mime_type, size, sha256, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, a.ID, a.UploaderID, a.Filename, a.MimeType, a.Size, a.SHA256, a.CreatedAt.UTC())
+	return err
+}
+
+// GetAttachment returns an attachment and the conversation of the message it was
+// sent with (0 while unsent). It returns sql.ErrNoRows for unknown ids.
+func GetAttachment(db *sql.DB, id string) (a models.Attachment, conversationID int, err error) {
+	var c sql.NullInt64
+	err = db.QueryRow(`
+		SELECT a.id, a.uploader_id, a.filename, a.mime_type, a.size, a.sha256, a.created_at, m.conversation_id
+		FROM attachments a
+		LEFT JOIN messages m ON m.id = a.message_id
+		WHERE a.id = ?
+	`, id).Scan(&a.ID, &a.UploaderID, &a.Filename, &a.MimeType, &a.Size, &a.SHA256, &a.CreatedAt, &c)
+	return a, int(c.Int64), err
+}
+
+// claimAttachments attaches the uploader's unsent attachments to a message, inside
+// the transaction that inserts it.
+func claimAttachments(tx *sql.Tx, messageID int64, uploaderID int, ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	args := []interface{}{messageID, uploaderID}
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	res, err := tx.Exec(`
+		UPDATE attachments SET message_id = ?
+		WHERE uploader_id = ? AND message_id IS NULL AND id IN (?`+strings.Repeat(",?", len(ids)-1)+`)
+	`, args...)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); int(n) != len(ids) {
+		return ErrAttachmentUnavailable
+	}
+	return nil
+}
+
+// attachmentsFor loads the attachments of the given messages, keyed by message id.
+func attachmentsFor(db *sql.DB, messageIDs []int) (map[int][]models.Attachment, error) {
+	out := make(map[int][]models.Attachment)
+	if len(messageIDs) == 0 {
+		return out, nil
+	}
+
+	args := make([]interface{}, len(messageIDs))
+	for i, id := range messageIDs {
+		args[i] = id
+	}
+	rows, err := db.Query(`
+		SELECT message_id, id, uploader_id, filename, mime_type, size, sha256, created_at
+		FROM attachments
+		WHERE message_id IN (?`+strings.Repeat(",?", len(messageIDs)-1)+`)
+		ORDER BY created_at, id
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var messageID int
+		var a models.Attachment
+		if err := rows.Scan(&messageID, &a.ID, &a.UploaderID, &a.Filename, &a.MimeType, &a.Size, &a.SHA256, &a.CreatedAt); err != nil {
+			return nil, err
+		}
+		out[messageID] = append(out[messageID], a)
+	}
+	return out, rows.Err()
+}
+
+// OrphanAttachments returns attachments uploaded before olderThan that were never sent.
+func OrphanAttachments(db *sql.DB, olderThan time.Time) ([]string, error) {
+	rows, err := db.Query("SELECT id FROM attachments WHERE message_id IS NULL AND created_at < ?", olderThan.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// DeleteAttachment removes an attachment record if it is still unsent, reporting
+// whether it did. An attachment claimed by a message in the meantime is kept, so
+// its file must be kept too.
+func DeleteAttachment(db *sql.DB, id string) (bool, error) {
+	res, err := db.Exec("DELETE FROM attachments WHERE id = ? AND message_id IS NULL", id)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
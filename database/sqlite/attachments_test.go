@@ -0,0 +1,58 @@
+package sqlite
+
+import (
+	"testing"
+	"time"
+
+	"DB-Presentation/models"
+)
+
+func TestDeleteAttachment(t *testing.T) {
+	d := newTestDB(t)
+	alice, bob := addUser(t, d, "alice"), addUser(t, d, "bob")
+
+	old := time.Now().Add(-48 * time.Hour)
+	for _, id := range []string{"unsent", "sent"} {
+		a := models.Attachment{ID: id, UploaderID: alice, Filename: id + ".txt", MimeType: "text/plain", Size: 1, SHA256: "x", CreatedAt: old}
+		if err := InsertAttachment(d, a); err != nil {
+			t.Fatal(err)
+		}
+	}
+	orphans, err := OrphanAttachments(d, time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(orphans) != 2 {
+		t.Fatalf("got %d orphans, want 2", len(orphans))
+	}
+
+	// Sent after the prune listed it
+	req := models.SendMessageRequest{RecipientID: bob, Message: "file", AttachmentIDs: []string{"sent"}}
+	if _, err := InsertMessageSQLite(d, alice, req, false); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		id      string
+		deleted bool
+	}{
+		{"unsent", true},
+		{"sent", false},
+		{"unknown", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.id, func(t *testing.T) {
+			deleted, err := DeleteAttachment(d, tt.id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if deleted != tt.deleted {
+				t.Errorf("deleted %v, want %v", deleted, tt.deleted)
+			}
+		})
+	}
+
+	if _, c, err := GetAttachment(d, "sent"); err != nil || c == 0 {
+		t.Errorf("the sent attachment: conversation %d, %v", c, err)
+	}
+}
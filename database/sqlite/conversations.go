@@ -0,0 +1,337 @@
+package sqlite
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"DB-Presentation/models"
+)
+
+// directKey identifies the direct conversation of two users, whatever their order.
+func directKey(a, b int) string {
+	if a > b {
+		a, b = b, a
+	}
+	return fmt.Sprintf("%d:%d", a, b)
+}
+
+// directConversation returns the id of the direct conversation between two users,
+// creating it (and its two members) inside tx on first use.
+func directConversation(tx *sql.Tx, a, b int) (int, error) {
+	key := directKey(a, b)
+	if _, err := tx.Exec("INSERT OR IGNORE INTO conversations (type, direct_key) VALUES (?, ?)", models.ConversationDirect, key); err != nil {
+		return 0, err
+	}
+	var id int
+	if err := tx.QueryRow("SELECT id FROM conversations WHERE direct_key = ?", key).Scan(&id); err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec("INSERT OR IGNORE INTO conversation_members (conversation_id, user_id) VALUES (?, ?), (?, ?)", id, a, id, b); err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// DirectConversationID returns the id of the direct conversation between two users.
+// It returns sql.ErrNoRows if they have never exchanged a message.
+func DirectConversationID(db *sql.DB, a, b int) (int, error) {
+	var id int
+	err := db.QueryRow("SELECT id FROM conversations WHERE direct_key = ?", directKey(a, b)).Scan(&id)
+	return id, err
+}
+
+// CreateGroup creates a group conversation owned by ownerID with the given members.
+func CreateGroup(db *sql.DB, ownerID int, name string, memberIDs []int) (models.Conversation, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return models.Conversation{}, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec("INSERT INTO conversations (type, name, created_by) VALUES (?, ?, ?)", models.ConversationGroup, name, ownerID)
+	if err != nil {
+		return models.Conversation{}, err
+	}
+	id, _ := res.LastInsertId()
+
+	if _, err := tx.Exec("INSERT INTO conversation_members (conversation_id, user_id, role) VALUES (?, ?, ?)", id, ownerID, models.RoleOwner); err != nil {
+		return models.Conversation{}, err
+	}
+	for _, memberID := range memberIDs {
+		if memberID == ownerID {
+			continue
+		}
+		if _, err := tx.Exec("INSERT OR IGNORE INTO conversation_members (conversation_id, user_id) VALUES (?, ?)", id, memberID); err != nil {
+			return models.Conversation{}, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return models.Conversation{}, err
+	}
+
+	return GetConversation(db, int(id), ownerID)
+}
+
+// conversationColumns is the select list read by scanConversation, for the
+// conversation c seen by the member cm.
+const conversationColumns = `c.id, c.type, c.name, c.created_by, c.created_at, cm.role,
+	CASE c.type
+		WHEN 'direct' THEN (SELECT COUNT(*) FROM messages m WHERE m.conversation_id = c.id AND m.recipient_id = cm.user_id AND m.is_read = 0)
+		ELSE (SELECT COUNT(*) FROM messages m WHERE m.conversation_id = c.id AND m.id > cm.last_read_message_id AND m.sender_id != cm.user_id AND m.deleted_at IS NULL)
+	END`
+
+func scanConversation(row rowScanner) (models.Conversation, error) {
+	var c models.Conversation
+	var name sql.NullString
+	var createdBy sql.NullInt64
+	err := row.Scan(&c.ID, &c.Type, &name, &createdBy, &c.CreatedAt, &c.Role, &c.UnreadCount)
+	c.Name = name.String
+	c.CreatedBy = int(createdBy.Int64)
+	return c, err
+}
+
+// GetConversation returns a conversation with its members, as seen by userID. It
+// returns sql.ErrNoRows if the conversation does not exist or userID is not a member.
+func GetConversation(db *sql.DB, id, userID int) (models.Conversation, error) {
+	c, err := scanConversation(db.QueryRow(`
+		SELECT `+conversationColumns+`
+		FROM conversations c
+		JOIN conversation_members cm ON cm.conversation_id = c.id AND cm.user_id = ?
+		WHERE c.id = ?
+	`, userID, id))
+	if err != nil {
+		return models.Conversation{}, err
+	}
+
+	members, err := membersFor(db, []int{c.ID})
+	if err != nil {
+		return models.Conversation{}, err
+	}
+	c.Members = members[c.ID]
+	return c, nil
+}
+
+// ListConversations returns every conversation the user is a member of, most
+// recently active first.
+func ListConversations(db *sql.DB, userID int) ([]models.Conversation, error) {
+	rows, err := db.Query(`
+		SELECT `+conversationColumns+`
+		FROM conversations c
+		JOIN conversation_members cm ON cm.conversation_id = c.id AND cm.user_id = ?
+		ORDER BY COALESCE((SELECT MAX(created_at) FROM messages WHERE conversation_id = c.id), c.created_at) DESC, c.id DESC
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	conversations := []models.Conversation{}
+	var ids []int
+	for rows.Next() {
+		c, err := scanConversation(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		conversations = append(conversations, c)
+		ids = append(ids, c.ID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	members, err := membersFor(db, ids)
+	if err != nil {
+		return nil, err
+	}
+	for i := range conversations {
+		conversations[i].Members = members[conversations[i].ID]
+	}
+	return conversations, nil
+}
+
+// membersFor loads the members of the given conversations, keyed by conversation id.
+func membersFor(db *sql.DB, conversationIDs []int) (map[int][]models.ConversationMember, error) {
+	out := make(map[int][]models.ConversationMember)
+	if len(conversationIDs) == 0 {
+		return out, nil
+	}
+
+	args := make([]interface{}, len(conversationIDs))
+	for i, id := range conversationIDs {
+		args[i] = id
+	}
+	rows, err := db.Query(`
+		SELECT cm.conversation_id, cm.user_id, u.username, cm.role, cm.joined_at
+		FROM conversation_members cm
+		JOIN users u ON u.id = cm.user_id
+		WHERE cm.conversation_id IN (?`+strings.Repeat(",?", len(conversationIDs)-1)+`)
+		ORDER BY cm.joined_at, cm.user_id
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var conversationID int
+		var m models.ConversationMember
+		if err := rows.Scan(&conversationID, &m.UserID, &m.Username, &m.Role, &m.JoinedAt); err != nil {
+			return nil, err
+		}
+		out[conversationID] = append(out[conversationID], m)
+	}
+	return out, rows.Err()
+}
+
+// ConversationType returns "direct" or "group". It returns sql.ErrNoRows for unknown ids.
+func ConversationType(db *sql.DB, id int) (string, error) {
+	var t string
+	err := db.QueryRow("SELECT type FROM conversations WHERE id = ?", id).Scan(&t)
+	return t, err
+}
+
+// MemberRole returns the user's role in a conversation, or "" if they are not a member.
+func MemberRole(db *sql.DB, conversationID, userID int) (string, error) {
+	var role string
+	err := db.QueryRow("SELECT role FROM conversation_members WHERE conversation_id = ? AND user_id = ?", conversationID, userID).Scan(&role)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return role, err
+}
+
+// IsMember reports whether the user belongs to the conversation.
+func IsMember(db *sql.DB, conversationID, userID int) (bool, error) {
+	role, err := MemberRole(db, conversationID, userID)
+	return role != "", err
+}
+
+// MemberIDs returns the ids of a conversation's members.
+func MemberIDs(db *sql.DB, conversationID int) ([]int, error) {
+	return queryIDs(db, "SELECT user_id FROM conversation_members WHERE conversation_id = ?", conversationID)
+}
+
+// ConversationIDs returns the ids of the conversations the user is a member of.
+func ConversationIDs(db *sql.DB, userID int) ([]int, error) {
+	return queryIDs(db, "SELECT conversation_id FROM conversation_members WHERE user_id = ?", userID)
+}
+
+func queryIDs(db *sql.DB, query string, args ...interface{}) ([]int, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// AddMember adds a user to a group as a plain member. Messages sent before they
+// joined are visible but do not count as unread.
+func AddMember(db *sql.DB, conversationID, userID int) error {
+	_, err := db.Exec(`
+		INSERT INTO conversation_members (conversation_id, user_id, last_read_message_id)
+		VALUES (?, ?, COALESCE((SELECT MAX(id) FROM messages WHERE conversation_id = ?), 0))
+	`, conversationID, userID, conversationID)
+	return err
+}
+
+// RemoveMember removes a user from a conversation.
+func RemoveMember(db *sql.DB, conversationID, userID int) error {
+	_, err := db.Exec("DELETE FROM conversation_members WHERE conversation_id = ? AND user_id = ?", conversationID, userID)
+	return err
+}
+
+// SetMemberRole changes a member's role to admin or member.
+func SetMemberRole(db *sql.DB, conversationID, userID int, role string) error {
+	_, err := db.Exec("UPDATE conversation_members SET role = ? WHERE conversation_id = ? AND user_id = ?", role, conversationID, userID)
+	return err
+}
+
+// TransferOwnership makes toID the owner of a group and demotes the current owner to admin.
+func TransferOwnership(db *sql.DB, conversationID, fromID, toID int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("UPDATE conversation_members SET role = ? WHERE conversation_id = ? AND user_id = ?", models.RoleAdmin, conversationID, fromID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE conversation_members SET role = ? WHERE conversation_id = ? AND user_id = ?", models.RoleOwner, conversationID, toID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// LeaveConversation removes a user from a group. When the owner leaves, the
+// longest-standing admin (or, failing that, member) becomes owner; its id is
+// returned, or 0 if ownership did not change.
+func LeaveConversation(db *sql.DB, conversationID, userID int) (int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var role string
+	if err := tx.QueryRow("SELECT role FROM conversation_members WHERE conversation_id = ? AND user_id = ?", conversationID, userID).Scan(&role); err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec("DELETE FROM conversation_members WHERE conversation_id = ? AND user_id = ?", conversationID, userID); err != nil {
+		return 0, err
+	}
+
+	var newOwner int
+	if role == models.RoleOwner {
+		err := tx.QueryRow(`
+			SELECT user_id FROM conversation_members
+			WHERE conversation_id = ?
+			ORDER BY role = 'admin' DESC, joined_at, user_id
+			LIMIT 1
+		`, conversationID).Scan(&newOwner)
+		if err != nil && err != sql.ErrNoRows {
+			return 0, err
+		}
+		if newOwner != 0 {
+			if _, err := tx.Exec("UPDATE conversation_members SET role = ? WHERE conversation_id = ? AND user_id = ?", models.RoleOwner, conversationID, newOwner); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return newOwner, tx.Commit()
+}
+
+// MarkConversationRead marks every message of a group as read by the user.
+func MarkConversationRead(db *sql.DB, conversationID, userID int) error {
+	_, err := db.Exec(`
+		UPDATE conversation_members
+		SET last_read_message_id = COALESCE((SELECT MAX(id) FROM messages WHERE conversation_id = ?), 0)
+		WHERE conversation_id = ? AND user_id = ?
+	`, conversationID, conversationID, userID)
+	return err
+}
+
+// CountGroupUnread returns the number of unread messages across the user's groups.
+func CountGroupUnread(db *sql.DB, userID int) (int, error) {
+	var count int
+	err := db.QueryRow(`
+		SELECT COUNT(*)
+		FROM conversation_members cm
+		JOIN conversations c ON c.id = cm.conversation_id AND c.type = 'group'
+		JOIN messages m ON m.conversation_id = cm.conversation_id
+		WHERE cm.user_id = ? AND m.id > cm.last_read_message_id AND m.sender_id != cm.user_id AND m.deleted_at IS NULL
+	`, userID).Scan(&count)
+	return count, err
+}
@@ -0,0 +1,109 @@
+package sqlite
+
+import (
+	"testing"
+
+	"DB-Presentation/models"
+)
+
+func TestLeaveConversation(t *testing.T) {
+	d := newTestDB(t)
+	alice, bob, carol, dave := addUser(t, d, "alice"), addUser(t, d, "bob"), addUser(t, d, "carol"), addUser(t, d, "dave")
+
+	conv, err := CreateGroup(d, alice, "team", []int{bob, carol})
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := conv.ID
+	// dave joins last but is the only admin
+	if err := AddMember(d, id, dave); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetMemberRole(d, id, dave, models.RoleAdmin); err != nil {
+		t.Fatal(err)
+	}
+
+	// Each step leaves and checks who, if anyone, became owner
+	steps := []struct {
+		name     string
+		leaver   int
+		newOwner int
+	}{
+		{"a member leaves", carol, 0},
+		{"the owner leaves for an admin", alice, dave},
+		{"the owner leaves for the longest-standing member", dave, bob},
+		{"the last member leaves", bob, 0},
+	}
+	for _, s := range steps {
+		newOwner, err := LeaveConversation(d, id, s.leaver)
+		if err != nil {
+			t.Fatalf("%s: %v", s.name, err)
+		}
+		if newOwner != s.newOwner {
+			t.Errorf("%s: new owner %d, want %d", s.name, newOwner, s.newOwner)
+		}
+		if member, _ := IsMember(d, id, s.leaver); member {
+			t.Errorf("%s: still a member", s.name)
+		}
+		if s.newOwner != 0 {
+			if role, _ := MemberRole(d, id, s.newOwner); role != models.RoleOwner {
+				t.Errorf("%s: the new owner's role is %q", s.name, role)
+			}
+		}
+	}
+}
+
+func TestGroupUnread(t *testing.T) {
+	d := newTestDB(t)
+	alice, bob, carol := addUser(t, d, "alice"), addUser(t, d, "bob"), addUser(t, d, "carol")
+	conv, err := CreateGroup(d, alice, "team", []int{bob})
+	if err != nil {
+		t.Fatal(err)
+	}
+	post := func(senderID int) models.Message {
+		msg, err := InsertMessageSQLite(d, senderID, models.SendMessageRequest{ConversationID: conv.ID, Message: "hi"}, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return msg
+	}
+	post(alice)
+	post(alice)
+
+	// Messages from before carol joined are not unread for her
+	if err := AddMember(d, conv.ID, carol); err != nil {
+		t.Fatal(err)
+	}
+	post(alice)
+	deleted := post(bob)
+	if _, err := DeleteMessageSQLite(d, deleted.ID, deleted.CreatedAt, false); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		userID int
+		want   int
+	}{
+		{"sender", alice, 0},
+		{"member since the start", bob, 3},
+		{"member who joined later", carol, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n, err := CountGroupUnread(d, tt.userID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != tt.want {
+				t.Errorf("got %d unread, want %d", n, tt.want)
+			}
+			if err := MarkConversationRead(d, conv.ID, tt.userID); err != nil {
+				t.Fatal(err)
+			}
+			if n, _ := CountGroupUnread(d, tt.userID); n != 0 {
+				t.Errorf("after reading: got %d unread, want 0", n)
+			}
+		})
+	}
+}
@@ -0,0 +1,153 @@
+package sqlite
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	"DB-Presentation/models"
+	"DB-Presentation/storage"
+)
+
+// ErrMessageDeleted is returned when editing a message that was deleted.
+var ErrMessageDeleted = storage.ErrMessageDeleted
+
+// EditMessageSQLite replaces a message's text, saving the previous text to
+// message_edits in the same transaction, and returns the updated message. It
+// returns ErrMessageDeleted for a deleted message, even one deleted after the
+// caller checked. With queue set, the message is also added to message_outbox in
+// that transaction.
+func EditMessageSQLite(db *sql.DB, id int, message string, editedAt time.Time, queue bool) (models.Message, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return models.Message{}, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`
+		INSERT INTO message_edits (message_id, message, edited_at)
+		SELECT id, message, ? FROM messages WHERE id = ? AND deleted_at IS NULL
+	`, editedAt.UTC(), id); err != nil {
+		return models.Message{}, err
+	}
+	res, err := tx.Exec("UPDATE messages SET message = ?, edited_at = ? WHERE id = ? AND deleted_at IS NULL", message, editedAt.UTC(), id)
+	if err != nil {
+		return models.Message{}, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return models.Message{}, err
+	}
+	if n == 0 {
+		// Either deleted or missing; unknown ids get sql.ErrNoRows, as from GetMessageSQLite
+		var exists int
+		if err := tx.QueryRow("SELECT 1 FROM messages WHERE id = ?", id).Scan(&exists); err != nil {
+			return models.Message{}, err
+		}
+		return models.Message{}, ErrMessageDeleted
+	}
+	if queue {
+		if err := queueOutbox(tx, id); err != nil {
+			return models.Message{}, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return models.Message{}, err
+	}
+
+	return GetMessageSQLite(db, id)
+}
+
+// DeleteMessageSQLite turns a message into a tombstone: the row stays (so the
+// conversation keeps its shape) but its text, edit history, reactions and
+// attachments are removed. It returns the ids of the removed attachments so their
+// files can be deleted. With queue set, the message is also added to message_outbox.
+func DeleteMessageSQLite(db *sql.DB, id int, deletedAt time.Time, queue bool) ([]string, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query("SELECT id FROM attachments WHERE message_id = ?", id)
+	if err != nil {
+		return nil, err
+	}
+	var attachmentIDs []string
+	for rows.Next() {
+		var aid string
+		if err := rows.Scan(&aid); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		attachmentIDs = append(attachmentIDs, aid)
+	}
+	rows.Close()
+
+	statements := []struct {
+		query string
+		args  []interface{}
+	}{
+		{"UPDATE messages SET message = '', deleted_at = ? WHERE id = ?", []interface{}{deletedAt.UTC(), id}},
+		{"DELETE FROM message_edits WHERE message_id = ?", []interface{}{id}},
+		{"DELETE FROM message_reactions WHERE message_id = ?", []interface{}{id}},
+		{"DELETE FROM attachments WHERE message_id = ?", []interface{}{id}},
+	}
+	for _, stmt := range statements {
+		if _, err := tx.Exec(stmt.query, stmt.args...); err != nil {
+			return nil, err
+		}
+	}
+	if queue {
+		if err := queueOutbox(tx, id); err != nil {
+			return nil, err
+		}
+	}
+
+	return attachmentIDs, tx.Commit()
+}
+
+// MessageEditsSQLite returns a message's previous versions, oldest first.
+func MessageEditsSQLite(db *sql.DB, id int) ([]models.MessageEdit, error) {
+	edits, err := MessageEditsForSQLite(db, []int{id})
+	if err != nil {
+		return nil, err
+	}
+	if edits[id] == nil {
+		return []models.MessageEdit{}, nil
+	}
+	return edits[id], nil
+}
+
+// MessageEditsForSQLite returns the previous versions of the given messages, oldest
+// first, keyed by message id. Messages never edited are left out.
+func MessageEditsForSQLite(db *sql.DB, ids []int) (map[int][]models.MessageEdit, error) {
+	out := make(map[int][]models.MessageEdit)
+	if len(ids) == 0 {
+		return out, nil
+	}
+
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	rows, err := db.Query(`
+		SELECT message_id, message, edited_at FROM message_edits
+		WHERE message_id IN (?`+strings.Repeat(",?", len(ids)-1)+`)
+		ORDER BY edited_at, id
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e models.MessageEdit
+		if err := rows.Scan(&e.MessageID, &e.Message, &e.EditedAt); err != nil {
+			return nil, err
+		}
+		e.EditedAt = e.EditedAt.UTC()
+		out[e.MessageID] = append(out[e.MessageID], e)
+	}
+	return out, rows.Err()
+}
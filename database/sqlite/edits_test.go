@@ -0,0 +1,183 @@
+package sqlite
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"DB-Presentation/storage"
+)
+
+func TestEditMessage(t *testing.T) {
+	d := newTestDB(t)
+	alice, bob := addUser(t, d, "alice"), addUser(t, d, "bob")
+	store := NewMessageStore(d, false)
+	ctx := context.Background()
+
+	live := send(t, d, alice, bob, "hello")
+	deleted := send(t, d, alice, bob, "goodbye")
+	if _, err := store.Delete(ctx, deleted.ID, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		id      int
+		want    error
+		history int
+	}{
+		{"live", live.ID, nil, 1},
+		{"deleted", deleted.ID, storage.ErrMessageDeleted, 0},
+		{"unknown", deleted.ID + 1, storage.ErrMessageNotFound, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg, err := store.Edit(ctx, tt.id, "edited", time.Now())
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("got %v, want %v", err, tt.want)
+			}
+			if tt.want == nil && (msg.Message != "edited" || msg.EditedAt == nil) {
+				t.Errorf("got %+v, want an edited message", msg)
+			}
+			edits, err := store.Edits(ctx, tt.id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(edits) != tt.history {
+				t.Errorf("got %d previous versions, want %d", len(edits), tt.history)
+			}
+		})
+	}
+
+	// The tombstone keeps its empty text
+	msg, err := store.Get(ctx, deleted.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Message != "" {
+		t.Errorf("the deleted message reads %q", msg.Message)
+	}
+}
+
+func TestChangedMessages(t *testing.T) {
+	d := newTestDB(t)
+	alice, bob := addUser(t, d, "alice"), addUser(t, d, "bob")
+	var ids []int
+	for i := 0; i < 7; i++ {
+		ids = append(ids, send(t, d, alice, bob, "hello").ID)
+	}
+	if _, err := AddReactionSQLite(d, ids[2], bob, "👍", false); err != nil {
+		t.Fatal(err)
+	}
+	// Only ids[3] and ids[4] are left for bob to read
+	if _, err := d.Exec("UPDATE messages SET is_read = 1 WHERE id NOT IN (?, ?)", ids[3], ids[4]); err != nil {
+		t.Fatal(err)
+	}
+	// Everything so far happened long before the last migration
+	if _, err := d.Exec("UPDATE messages SET created_at = '2024-01-01 00:00:00', updated_at = NULL"); err != nil {
+		t.Fatal(err)
+	}
+	since := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	changes := []struct {
+		name string
+		run  func() error
+	}{
+		{"edited", func() error {
+			_, err := EditMessageSQLite(d, ids[0], "edited", time.Now(), false)
+			return err
+		}},
+		{"reacted to", func() error {
+			_, err := AddReactionSQLite(d, ids[1], bob, "🎉", false)
+			return err
+		}},
+		{"reaction removed", func() error {
+			_, err := RemoveReactionSQLite(d, ids[2], bob, "👍", false)
+			return err
+		}},
+		{"read", func() error { return MarkMessagesReadSQLite(d, alice, bob, false) }},
+		{"deleted", func() error {
+			_, err := DeleteMessageSQLite(d, ids[6], time.Now(), false)
+			return err
+		}},
+	}
+	for _, c := range changes {
+		if err := c.run(); err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+	}
+
+	// ids[5] was left alone
+	want := []int{ids[0], ids[1], ids[2], ids[3], ids[4], ids[6]}
+	var got []int
+	for after := 0; ; {
+		batch, err := ChangedMessagesSQLite(d, since, after, ids[6], 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(batch) == 0 {
+			break
+		}
+		for _, m := range batch {
+			got = append(got, m.ID)
+		}
+		after = batch[len(batch)-1].ID
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("changed: got %v, want %v", got, want)
+	}
+
+	// maxID bounds the walk to the messages below a checkpoint
+	batch, err := ChangedMessagesSQLite(d, since, 0, ids[1], 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(batch) != 2 {
+		t.Errorf("up to message %d: got %d changed messages, want 2", ids[1], len(batch))
+	}
+}
+
+func TestHistoryFor(t *testing.T) {
+	d := newTestDB(t)
+	alice, bob := addUser(t, d, "alice"), addUser(t, d, "bob")
+	a, b, c := send(t, d, alice, bob, "one").ID, send(t, d, alice, bob, "two").ID, send(t, d, alice, bob, "three").ID
+
+	for _, text := range []string{"one, edited", "one, edited twice"} {
+		if _, err := EditMessageSQLite(d, a, text, time.Now(), false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, r := range []struct {
+		id, user int
+		emoji    string
+	}{{a, bob, "👍"}, {b, bob, "👍"}, {b, alice, "🎉"}} {
+		if _, err := AddReactionSQLite(d, r.id, r.user, r.emoji, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	edits, err := MessageEditsForSQLite(d, []int{a, b, c})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(edits) != 1 || len(edits[a]) != 2 || edits[a][0].Message != "one" || edits[a][1].Message != "one, edited" {
+		t.Errorf("got edits %+v", edits)
+	}
+	reactions, err := ReactionsForSQLite(d, []int{a, b, c})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reactions) != 2 || len(reactions[a]) != 1 || len(reactions[b]) != 2 || reactions[b][1].Username != "alice" {
+		t.Errorf("got reactions %+v", reactions)
+	}
+
+	// The single-message forms return empty lists, not nil
+	if e, err := MessageEditsSQLite(d, c); err != nil || e == nil || len(e) != 0 {
+		t.Errorf("edits of an unedited message: %v, %v", e, err)
+	}
+	if r, err := ReactionsSQLite(d, c); err != nil || r == nil || len(r) != 0 {
+		t.Errorf("reactions on a message without any: %v, %v", r, err)
+	}
+}
@@ -0,0 +1,73 @@
+package sqlite
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"DB-Presentation/models"
+)
+
+// AppendEvent stores an event for a user and returns its sequence number, which is
+// one more than the user's previous event. The single INSERT keeps numbering atomic.
+func AppendEvent(db *sql.DB, userID int, msg models.WSMessage) (int64, error) {
+	data, err := json.Marshal(msg.Data)
+	if err != nil {
+		return 0, err
+	}
+
+	var seq int64
+	err = db.QueryRow(`
+        INSERT INTO user_events (user_id, seq, type, data, created_at)
+        SELECT ?, COALESCE(MAX(seq), 0) + 1, ?, ?, ? FROM user_events WHERE user_id = ?
+        RETURNING seq
+    `, userID, msg.Type, string(data), time.Now().UTC(), userID).Scan(&seq)
+	return seq, err
+}
+
+// EventsSince returns up to limit events of a user with seq greater than afterSeq, oldest first.
+func EventsSince(db *sql.DB, userID int, afterSeq int64, limit int) ([]models.WSMessage, error) {
+	rows, err := db.Query(`
+        SELECT seq, type, data FROM user_events
+        WHERE user_id = ? AND seq > ?
+        ORDER BY seq ASC
+        LIMIT ?
+    `, userID, afterSeq, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []models.WSMessage
+	for rows.Next() {
+		var msg models.WSMessage
+		var data string
+		if err := rows.Scan(&msg.Seq, &msg.Type, &data); err != nil {
+			return nil, err
+		}
+		msg.Data = json.RawMessage(data)
+		events = append(events, msg)
+	}
+	return events, rows.Err()
+}
+
+// EventSeqRange returns the lowest and highest retained seq for a user (0, 0 if none).
+func EventSeqRange(db *sql.DB, userID int) (int64, int64, error) {
+	var lo, hi int64
+	err := db.QueryRow("SELECT COALESCE(MIN(seq), 0), COALESCE(MAX(seq), 0) FROM user_events WHERE user_id = ?", userID).Scan(&lo, &hi)
+	return lo, hi, err
+}
+
+// PruneEvents deletes events older than the cutoff, always keeping each user's newest
+// event so sequence numbers keep increasing.
+func PruneEvents(db *sql.DB, olderThan time.Time) (int64, error) {
+	res, err := db.Exec(`
+        DELETE FROM user_events
+        WHERE created_at < ?
+          AND seq < (SELECT MAX(e.seq) FROM user_events e WHERE e.user_id = user_events.user_id)
+    `, olderThan.UTC())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,39 @@
+package sqlite
+
+import (
+	"database/sql"
+)
+
+// AreFriends reports whether the two users have an accepted friendship, in either direction.
+func AreFriends(db *sql.DB, userID, otherID int) (bool, error) {
+	var n int
+	err := db.QueryRow(`
+		SELECT COUNT(*) FROM friendships
+		WHERE status = 'accepted'
+		AND ((user_id = ? AND friend_id = ?) OR (user_id = ? AND friend_id = ?))
+	`, userID, otherID, otherID, userID).Scan(&n)
+	return n > 0, err
+}
+
+// FriendIDs returns the ids of the user's accepted friends.
+func FriendIDs(db *sql.DB, userID int) ([]int, error) {
+	rows, err := db.Query(`
+		SELECT CASE WHEN user_id = ? THEN friend_id ELSE user_id END
+		FROM friendships
+		WHERE status = 'accepted' AND (user_id = ? OR friend_id = ?)
+	`, userID, userID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
@@ -0,0 +1,95 @@
+package sqlite
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"DB-Presentation/models"
+)
+
+// queueOutbox adds a message to message_outbox inside tx. The relay copies the
+// message's state at delivery time, so one entry covers any number of changes.
+func queueOutbox(tx *sql.Tx, messageID int) error {
+	_, err := tx.Exec("INSERT INTO message_outbox (message_id) VALUES (?)", messageID)
+	return err
+}
+
+// PendingOutbox returns up to limit undelivered outbox entries that are due, oldest first.
+func PendingOutbox(db *sql.DB, limit int) ([]models.OutboxEntry, error) {
+	rows, err := db.Query(`
+		SELECT id, message_id, attempts
+		FROM message_outbox
+		WHERE done_at IS NULL AND next_attempt_at <= datetime('now')
+		ORDER BY id
+		LIMIT ?
+	`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []models.OutboxEntry
+	for rows.Next() {
+		var e models.OutboxEntry
+		if err := rows.Scan(&e.ID, &e.MessageID, &e.Attempts); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// OutboxPending reports whether any undelivered entry is for a message of the
+// conversation, or for any message when conversationID is 0.
+func OutboxPending(db *sql.DB, conversationID int) (bool, error) {
+	var pending bool
+	err := db.QueryRow(`
+		SELECT EXISTS (
+			SELECT 1
+			FROM message_outbox o
+			JOIN messages m ON m.id = o.message_id
+			WHERE o.done_at IS NULL AND (? = 0 OR m.conversation_id = ?)
+		)
+	`, conversationID, conversationID).Scan(&pending)
+	return pending, err
+}
+
+// MarkOutboxDone records that an outbox entry was delivered.
+func MarkOutboxDone(db *sql.DB, id int) error {
+	_, err := db.Exec("UPDATE message_outbox SET done_at = datetime('now'), last_error = NULL WHERE id = ?", id)
+	return err
+}
+
+// MarkOutboxFailed records a failed delivery and schedules the next attempt after retryIn.
+func MarkOutboxFailed(db *sql.DB, id int, deliveryErr error, retryIn time.Duration) error {
+	_, err := db.Exec(`
+		UPDATE message_outbox
+		SET attempts = attempts + 1, last_error = ?, next_attempt_at = datetime('now', ?)
+		WHERE id = ?
+	`, deliveryErr.Error(), fmt.Sprintf("+%d seconds", int(retryIn.Seconds())), id)
+	return err
+}
+
+// OutboxBacklog returns how many outbox entries are undelivered and how long the
+// oldest of them has waited.
+func OutboxBacklog(db *sql.DB) (models.OutboxBacklog, error) {
+	var b models.OutboxBacklog
+	var oldest sql.NullFloat64
+	err := db.QueryRow(`
+		SELECT COUNT(*), COALESCE(SUM(attempts > 0), 0), (julianday('now') - julianday(MIN(created_at))) * 86400
+		FROM message_outbox
+		WHERE done_at IS NULL
+	`).Scan(&b.Pending, &b.Retrying, &oldest)
+	b.OldestSeconds = oldest.Float64
+	return b, err
+}
+
+// PruneOutbox deletes entries delivered more than olderThan ago.
+func PruneOutbox(db *sql.DB, olderThan time.Duration) (int64, error) {
+	res, err := db.Exec("DELETE FROM message_outbox WHERE done_at < datetime('now', ?)", fmt.Sprintf("-%d seconds", int(olderThan.Seconds())))
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,85 @@
+package sqlite
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"DB-Presentation/models"
+)
+
+func TestOutboxQueue(t *testing.T) {
+	d := newTestDB(t)
+	alice, bob := addUser(t, d, "alice"), addUser(t, d, "bob")
+	var ids []int
+	for i := 0; i < 3; i++ {
+		msg, err := InsertMessageSQLite(d, alice, models.SendMessageRequest{RecipientID: bob, Message: "hello"}, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, msg.ID)
+	}
+	if err := MarkMessagesReadSQLite(d, alice, bob, false); err != nil {
+		t.Fatal(err)
+	}
+	unread, err := InsertMessageSQLite(d, alice, models.SendMessageRequest{RecipientID: bob, Message: "again"}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// queued returns the message ids queued since the last call
+	var seen int
+	queued := func() []int {
+		t.Helper()
+		entries, err := PendingOutbox(d, 100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out := []int{}
+		for _, e := range entries[seen:] {
+			out = append(out, e.MessageID)
+		}
+		seen = len(entries)
+		return out
+	}
+	if got, want := queued(), append(append([]int{}, ids...), unread.ID); !reflect.DeepEqual(got, want) {
+		t.Fatalf("inserts: got %v, want %v", got, want)
+	}
+
+	steps := []struct {
+		name string
+		run  func() error
+		want []int
+	}{
+		{"read", func() error { return MarkMessagesReadSQLite(d, alice, bob, true) }, []int{unread.ID}},
+		{"read again", func() error { return MarkMessagesReadSQLite(d, alice, bob, true) }, []int{}},
+		{"reacted to", func() error {
+			_, err := AddReactionSQLite(d, ids[1], bob, "👍", true)
+			return err
+		}, []int{ids[1]}},
+		{"same reaction", func() error {
+			_, err := AddReactionSQLite(d, ids[1], bob, "👍", true)
+			return err
+		}, []int{}},
+		{"reaction removed", func() error {
+			_, err := RemoveReactionSQLite(d, ids[1], bob, "👍", true)
+			return err
+		}, []int{ids[1]}},
+		{"no reaction to remove", func() error {
+			_, err := RemoveReactionSQLite(d, ids[1], bob, "👍", true)
+			return err
+		}, []int{}},
+		{"edited", func() error {
+			_, err := EditMessageSQLite(d, ids[2], "edited", time.Now(), true)
+			return err
+		}, []int{ids[2]}},
+	}
+	for _, s := range steps {
+		if err := s.run(); err != nil {
+			t.Fatalf("%s: %v", s.name, err)
+		}
+		if got := queued(); !reflect.DeepEqual(got, s.want) {
+			t.Errorf("%s: queued %v, want %v", s.name, got, s.want)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package sqlite
+
+import (
+	"database/sql"
+	"time"
+)
+
+// presenceTime is how presence_instances stores times, so they compare as text.
+const presenceTime = "2006-01-02 15:04:05"
+
+// SetInstancePresence records a user's status on one server instance ("online",
+// "away", or "offline" once the instance has no connection for them left), stores
+// their combined status (see combinePresence) in users.status and returns it.
+func SetInstancePresence(db *sql.DB, instance string, userID int, status string, now, staleBefore time.Time) (string, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	if status == "offline" {
+		_, err = tx.Exec("DELETE FROM presence_instances WHERE instance = ? AND user_id = ?", instance, userID)
+	} else {
+		_, err = tx.Exec(`
+			INSERT INTO presence_instances (instance, user_id, status, heartbeat_at) VALUES (?, ?, ?, ?)
+			ON CONFLICT (instance, user_id) DO UPDATE SET status = excluded.status, heartbeat_at = excluded.heartbeat_at
+		`, instance, userID, status, now.UTC().Format(presenceTime))
+	}
+	if err != nil {
+		return "", err
+	}
+
+	combined, err := combinePresence(tx, userID, staleBefore)
+	if err != nil {
+		return "", err
+	}
+	if _, err := tx.Exec("UPDATE users SET status = ?, last_seen_at = ? WHERE id = ?", combined, now.UTC(), userID); err != nil {
+		return "", err
+	}
+	return combined, tx.Commit()
+}
+
+// combinePresence returns a user's status across instances: online if any instance
+// with a heartbeat since staleBefore has them online, else away if one has them
+// away, else offline.
+func combinePresence(tx *sql.Tx, userID int, staleBefore time.Time) (string, error) {
+	var online, away int
+	err := tx.QueryRow(`
+		SELECT COALESCE(SUM(status = 'online'), 0), COALESCE(SUM(status = 'away'), 0)
+		FROM presence_instances
+		WHERE user_id = ? AND heartbeat_at >= ?
+	`, userID, staleBefore.UTC().Format(presenceTime)).Scan(&online, &away)
+	switch {
+	case err != nil:
+		return "", err
+	case online > 0:
+		return "online", nil
+	case away > 0:
+		return "away", nil
+	}
+	return "offline", nil
+}
+
+// TouchPresence renews the heartbeat of every row an instance owns.
+func TouchPresence(db *sql.DB, instance string, now time.Time) error {
+	_, err := db.Exec("UPDATE presence_instances SET heartbeat_at = ? WHERE instance = ?", now.UTC().Format(presenceTime), instance)
+	return err
+}
+
+// ExpirePresence deletes the rows of instances whose last heartbeat is before
+// staleBefore, and, when instance is not empty, that instance's own rows (it is
+// starting up, so it has no connections yet). The users those rows were for, and
+// any user shown as present without rows, get their combined status again. It
+// returns the users whose status changed, with their new status.
+func ExpirePresence(db *sql.DB, instance string, staleBefore time.Time) (map[int]string, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	stale := staleBefore.UTC().Format(presenceTime)
+	rows, err := tx.Query(`
+		SELECT id, status FROM users
+		WHERE id IN (SELECT user_id FROM presence_instances WHERE heartbeat_at < ? OR instance = ?)
+		   OR (status != 'offline' AND id NOT IN (SELECT user_id FROM presence_instances))
+	`, stale, instance)
+	if err != nil {
+		return nil, err
+	}
+	affected := make(map[int]string)
+	for rows.Next() {
+		var id int
+		var status string
+		if err := rows.Scan(&id, &status); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		affected[id] = status
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.Exec("DELETE FROM presence_instances WHERE heartbeat_at < ? OR instance = ?", stale, instance); err != nil {
+		return nil, err
+	}
+	changed := make(map[int]string)
+	for id, was := range affected {
+		status, err := combinePresence(tx, id, staleBefore)
+		if err != nil {
+			return nil, err
+		}
+		if status == was {
+			continue
+		}
+		if _, err := tx.Exec("UPDATE users SET status = ? WHERE id = ?", status, id); err != nil {
+			return nil, err
+		}
+		changed[id] = status
+	}
+	return changed, tx.Commit()
+}
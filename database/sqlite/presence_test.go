@@ -0,0 +1,125 @@
+package sqlite
+
+import (
+	"database/sql"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// userStatus returns the status stored for a user.
+func userStatus(t *testing.T, d *sql.DB, userID int) string {
+	t.Helper()
+	var status string
+	if err := d.QueryRow("SELECT status FROM users WHERE id = ?", userID).Scan(&status); err != nil {
+		t.Fatal(err)
+	}
+	return status
+}
+
+func TestInstancePresence(t *testing.T) {
+	d := newTestDB(t)
+	alice := addUser(t, d, "alice")
+	now := time.Now().UTC()
+	stale := now.Add(-time.Minute)
+
+	steps := []struct {
+		name     string
+		instance string
+		status   string
+		want     string
+	}{
+		{"online on a", "a", "online", "online"},
+		{"away on b", "b", "away", "online"},
+		{"offline on a", "a", "offline", "away"},
+		{"online on b", "b", "online", "online"},
+		{"offline on b", "b", "offline", "offline"},
+	}
+	for _, s := range steps {
+		got, err := SetInstancePresence(d, s.instance, alice, s.status, now, stale)
+		if err != nil {
+			t.Fatalf("%s: %v", s.name, err)
+		}
+		if got != s.want || userStatus(t, d, alice) != s.want {
+			t.Errorf("%s: got %q (stored %q), want %q", s.name, got, userStatus(t, d, alice), s.want)
+		}
+	}
+}
+
+func TestExpirePresence(t *testing.T) {
+	d := newTestDB(t)
+	alice := addUser(t, d, "alice")
+	bob := addUser(t, d, "bob")
+	carol := addUser(t, d, "carol")
+	dave := addUser(t, d, "dave")
+
+	now := time.Now().UTC()
+	old := now.Add(-10 * time.Minute)
+	staleBefore := now.Add(-time.Minute)
+
+	set := func(instance string, userID int, status string, at time.Time) {
+		t.Helper()
+		if _, err := SetInstancePresence(d, instance, userID, status, at, at.Add(-time.Minute)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// alice is only on the stale instance a, bob on a and the live instance b,
+	// carol only on b, which restarts, and dave is left online without any rows.
+	set("a", alice, "online", old)
+	set("b", bob, "away", now)
+	set("a", bob, "online", old)
+	set("b", carol, "online", now)
+	if _, err := d.Exec("UPDATE users SET status = 'online' WHERE id = ?", dave); err != nil {
+		t.Fatal(err)
+	}
+
+	changed, err := ExpirePresence(d, "", staleBefore)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[int]string{alice: "offline", bob: "away", dave: "offline"}
+	if !reflect.DeepEqual(changed, want) {
+		t.Errorf("sweep: got %v, want %v", changed, want)
+	}
+	if got := userStatus(t, d, carol); got != "online" {
+		t.Errorf("carol on the live instance: got %q, want online", got)
+	}
+
+	// Restarting b drops its own rows even though they are fresh
+	changed, err = ExpirePresence(d, "b", staleBefore)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = map[int]string{bob: "offline", carol: "offline"}
+	if !reflect.DeepEqual(changed, want) {
+		t.Errorf("restart: got %v, want %v", changed, want)
+	}
+	var rows int
+	if err := d.QueryRow("SELECT COUNT(*) FROM presence_instances").Scan(&rows); err != nil {
+		t.Fatal(err)
+	}
+	if rows != 0 {
+		t.Errorf("%d presence rows left, want 0", rows)
+	}
+}
+
+func TestTouchPresence(t *testing.T) {
+	d := newTestDB(t)
+	alice := addUser(t, d, "alice")
+	old := time.Now().UTC().Add(-10 * time.Minute)
+	if _, err := SetInstancePresence(d, "a", alice, "online", old, old.Add(-time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now().UTC()
+	if err := TouchPresence(d, "a", now); err != nil {
+		t.Fatal(err)
+	}
+	changed, err := ExpirePresence(d, "", now.Add(-time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changed) != 0 || userStatus(t, d, alice) != "online" {
+		t.Errorf("renewed row expired: changed %v, status %q", changed, userStatus(t, d, alice))
+	}
+}
@@ -0,0 +1,148 @@
+package sqlite
+
+import (
+	"database/sql"
+	"strings"
+
+	"DB-Presentation/models"
+)
+
+// AddReactionSQLite records userID reacting to a message with emoji. It reports
+// false if that reaction already existed. With queue set, a new reaction also
+// adds the message to message_outbox in the same transaction.
+func AddReactionSQLite(db *sql.DB, messageID, userID int, emoji string, queue bool) (bool, error) {
+	return changeReaction(db, messageID, queue,
+		"INSERT OR IGNORE INTO message_reactions (message_id, user_id, emoji) VALUES (?, ?, ?)", messageID, userID, emoji)
+}
+
+// RemoveReactionSQLite removes a reaction. It reports false if there was none.
+// queue is as for AddReactionSQLite.
+func RemoveReactionSQLite(db *sql.DB, messageID, userID int, emoji string, queue bool) (bool, error) {
+	return changeReaction(db, messageID, queue,
+		"DELETE FROM message_reactions WHERE message_id = ? AND user_id = ? AND emoji = ?", messageID, userID, emoji)
+}
+
+// changeReaction runs query and, with queue set, queues the message if a row changed.
+func changeReaction(db *sql.DB, messageID int, queue bool, query string, args ...interface{}) (bool, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(query, args...)
+	if err != nil {
+		return false, err
+	}
+	n, _ := result.RowsAffected()
+	if n == 0 {
+		return false, nil
+	}
+	if queue {
+		if err := queueOutbox(tx, messageID); err != nil {
+			return false, err
+		}
+	}
+	return true, tx.Commit()
+}
+
+// ReactionsSQLite lists every reaction on a message, oldest first.
+func ReactionsSQLite(db *sql.DB, messageID int) ([]models.MessageReaction, error) {
+	reactions, err := ReactionsForSQLite(db, []int{messageID})
+	if err != nil {
+		return nil, err
+	}
+	if reactions[messageID] == nil {
+		return []models.MessageReaction{}, nil
+	}
+	return reactions[messageID], nil
+}
+
+// ReactionsForSQLite lists every reaction on the given messages, oldest first,
+// keyed by message id. Messages without reactions are left out.
+func ReactionsForSQLite(db *sql.DB, messageIDs []int) (map[int][]models.MessageReaction, error) {
+	out := make(map[int][]models.MessageReaction)
+	if len(messageIDs) == 0 {
+		return out, nil
+	}
+
+	args := make([]interface{}, len(messageIDs))
+	for i, id := range messageIDs {
+		args[i] = id
+	}
+	rows, err := db.Query(`
+		SELECT r.message_id, r.user_id, u.username, r.emoji, r.created_at
+		FROM message_reactions r
+		JOIN users u ON r.user_id = u.id
+		WHERE r.message_id IN (?`+strings.Repeat(",?", len(messageIDs)-1)+`)
+		ORDER BY r.created_at, r.rowid
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var r models.MessageReaction
+		if err := rows.Scan(&r.MessageID, &r.UserID, &r.Username, &r.Emoji, &r.CreatedAt); err != nil {
+			return nil, err
+		}
+		r.CreatedAt = r.CreatedAt.UTC()
+		out[r.MessageID] = append(out[r.MessageID], r)
+	}
+	return out, rows.Err()
+}
+
+// ReactionCountsSQLite returns the aggregated reactions on one message.
+func ReactionCountsSQLite(db *sql.DB, messageID int) ([]models.Reaction, error) {
+	counts, err := reactionsFor(db, []int{messageID})
+	if err != nil {
+		return nil, err
+	}
+	return counts[messageID], nil
+}
+
+// reactionsFor aggregates the reactions on the given messages per emoji, in the
+// order each emoji was first used.
+func reactionsFor(db *sql.DB, messageIDs []int) (map[int][]models.Reaction, error) {
+	out := make(map[int][]models.Reaction)
+	if len(messageIDs) == 0 {
+		return out, nil
+	}
+
+	args := make([]interface{}, len(messageIDs))
+	for i, id := range messageIDs {
+		args[i] = id
+	}
+	rows, err := db.Query(`
+		SELECT message_id, emoji, user_id
+		FROM message_reactions
+		WHERE message_id IN (?`+strings.Repeat(",?", len(messageIDs)-1)+`)
+		ORDER BY created_at, rowid
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var messageID, userID int
+		var emoji string
+		if err := rows.Scan(&messageID, &emoji, &userID); err != nil {
+			return nil, err
+		}
+		out[messageID] = appendReaction(out[messageID], emoji, userID)
+	}
+	return out, rows.Err()
+}
+
+func appendReaction(reactions []models.Reaction, emoji string, userID int) []models.Reaction {
+	for i := range reactions {
+		if reactions[i].Emoji == emoji {
+			reactions[i].Count++
+			reactions[i].UserIDs = append(reactions[i].UserIDs, userID)
+			return reactions
+		}
+	}
+	return append(reactions, models.Reaction{Emoji: emoji, Count: 1, UserIDs: []int{userID}})
+}
@@ -0,0 +1,148 @@
+package sqlite
+
+import (
+	"database/sql"
+	"strings"
+
+	"DB-Presentation/models"
+	"DB-Presentation/utils"
+)
+
+// searchTriggers keep messages_fts in step with messages. The table uses messages
+// as external content, so the index holds only tokens, not a second copy of the text.
+var searchTriggers = map[string]string{
+	"messages_fts_insert": `CREATE TRIGGER messages_fts_insert AFTER INSERT ON messages BEGIN
+		INSERT INTO messages_fts (rowid, message) VALUES (new.id, new.message);
+	END`,
+	"messages_fts_delete": `CREATE TRIGGER messages_fts_delete AFTER DELETE ON messages BEGIN
+		INSERT INTO messages_fts (messages_fts, rowid, message) VALUES ('delete', old.id, old.message);
+	END`,
+	"messages_fts_update": `CREATE TRIGGER messages_fts_update AFTER UPDATE OF message ON messages BEGIN
+		INSERT INTO messages_fts (messages_fts, rowid, message) VALUES ('delete', old.id, old.message);
+		INSERT INTO messages_fts (rowid, message) VALUES (new.id, new.message);
+	END`,
+}
+
+// EnsureSearchIndex sets up the FTS5 message index and reports whether it is in use.
+// FTS5 is only compiled into go-sqlite3 with the sqlite_fts5 build tag. Without it
+// the triggers are dropped (they would make every write to messages fail) and
+// search falls back to LIKE; the index is rebuilt once FTS5 is available again.
+func EnsureSearchIndex(db *sql.DB) (bool, error) {
+	if _, err := db.Exec("CREATE VIRTUAL TABLE IF NOT EXISTS temp.fts5_probe USING fts5(x)"); err != nil {
+		for name := range searchTriggers {
+			if _, err := db.Exec("DROP TRIGGER IF EXISTS " + name); err != nil {
+				return false, err
+			}
+		}
+		return false, nil
+	}
+	_, _ = db.Exec("DROP TABLE temp.fts5_probe")
+
+	if _, err := db.Exec(`CREATE VIRTUAL TABLE IF NOT EXISTS messages_fts USING fts5(
+		message, content='messages', content_rowid='id', tokenize='unicode61 remove_diacritics 2'
+	)`); err != nil {
+		return false, err
+	}
+
+	// Missing triggers mean the index is new or was left stale by a build without FTS5
+	rebuild := false
+	for name, stmt := range searchTriggers {
+		if hasTrigger(db, name) {
+			continue
+		}
+		if _, err := db.Exec(stmt); err != nil {
+			return false, err
+		}
+		rebuild = true
+	}
+	if rebuild {
+		if _, err := db.Exec("INSERT INTO messages_fts (messages_fts) VALUES ('rebuild')"); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func hasTrigger(db *sql.DB, name string) bool {
+	var n int
+	_ = db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'trigger' AND name = ?", name).Scan(&n)
+	return n > 0
+}
+
+// SearchMessagesSQLite finds messages in the user's conversations containing every
+// search term (as a word prefix with FTS5, as a substring otherwise), newest first.
+// Deleted messages are never returned. Before must be a message the user can see
+// (ErrInvalidCursor otherwise).
+func SearchMessagesSQLite(db *sql.DB, userID int, req models.SearchRequest) (models.SearchPage, error) {
+	member := "m.conversation_id IN (SELECT conversation_id FROM conversation_members WHERE user_id = ?)"
+	where := []string{member, "m.deleted_at IS NULL"}
+	args := []interface{}{userID}
+	from := "messages m"
+
+	if hasTrigger(db, "messages_fts_insert") {
+		phrases := make([]string, len(req.Terms))
+		for i, t := range req.Terms {
+			phrases[i] = `"` + t + `"*`
+		}
+		from = "messages_fts JOIN messages m ON m.id = messages_fts.rowid"
+		where = append(where, "messages_fts MATCH ?")
+		args = append(args, strings.Join(phrases, " "))
+	} else {
+		for _, t := range req.Terms {
+			where = append(where, "m.message LIKE ?")
+			args = append(args, "%"+t+"%")
+		}
+	}
+
+	if req.FriendID != 0 {
+		where = append(where, "m.recipient_id IS NOT NULL AND (m.sender_id = ? OR m.recipient_id = ?)")
+		args = append(args, req.FriendID, req.FriendID)
+	}
+	if req.ConversationID != 0 {
+		where = append(where, "m.conversation_id = ?")
+		args = append(args, req.ConversationID)
+	}
+	// created_at is stored as "YYYY-MM-DD HH:MM:SS" in UTC
+	if req.From != nil {
+		where = append(where, "m.created_at >= ?")
+		args = append(args, req.From.UTC().Format("2006-01-02 15:04:05"))
+	}
+	if req.To != nil {
+		where = append(where, "m.created_at < ?")
+		args = append(args, req.To.UTC().Format("2006-01-02 15:04:05"))
+	}
+	if req.Before != 0 {
+		var n int
+		if err := db.QueryRow("SELECT COUNT(*) FROM messages m WHERE m.id = ? AND "+member, req.Before, userID).Scan(&n); err != nil {
+			return models.SearchPage{}, err
+		}
+		if n == 0 {
+			return models.SearchPage{}, ErrInvalidCursor
+		}
+		where = append(where, "(m.created_at, m.id) < (SELECT created_at, id FROM messages WHERE id = ?)")
+		args = append(args, req.Before)
+	}
+
+	rows, err := db.Query(`
+        SELECT `+messageColumns+`
+        FROM `+from+`
+        JOIN users u ON m.sender_id = u.id
+        WHERE `+strings.Join(where, " AND ")+`
+        ORDER BY m.created_at DESC, m.id DESC
+        LIMIT ?
+    `, append(args, req.Limit+1)...)
+	if err != nil {
+		return models.SearchPage{}, err
+	}
+	defer rows.Close()
+
+	messages, err := scanMessages(db, rows)
+	if err != nil {
+		return models.SearchPage{}, err
+	}
+	results := make([]models.SearchResult, len(messages))
+	for i, msg := range messages {
+		results[i] = models.SearchResult{Message: msg, Snippet: utils.HighlightSnippet(msg.Message, req.Terms)}
+	}
+	return models.NewSearchPage(results, req.Limit), nil
+}
@@ -0,0 +1,6 @@
+//go:build !sqlite_fts5
+
+package sqlite
+
+// fts5Built reports whether the tests were built with FTS5, so search uses the index.
+const fts5Built = false
@@ -0,0 +1,119 @@
+package sqlite
+
+import (
+	"database/sql"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"DB-Presentation/models"
+)
+
+// searchIDs runs a search as userID and returns the ids found.
+func searchIDs(t *testing.T, d *sql.DB, userID int, req models.SearchRequest) []int {
+	t.Helper()
+	if req.Limit == 0 {
+		req.Limit = 10
+	}
+	page, err := SearchMessagesSQLite(d, userID, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := []int{}
+	for _, r := range page.Results {
+		ids = append(ids, r.ID)
+	}
+	return ids
+}
+
+func TestSearchMessages(t *testing.T) {
+	d := newTestDB(t)
+	enabled, err := EnsureSearchIndex(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if enabled != fts5Built {
+		t.Fatalf("EnsureSearchIndex reports %v in a build with sqlite_fts5 %v", enabled, fts5Built)
+	}
+
+	alice, bob, carol := addUser(t, d, "alice"), addUser(t, d, "bob"), addUser(t, d, "carol")
+	hello := send(t, d, alice, bob, "Hello there")
+	cafe := send(t, d, bob, alice, "Meet at the café?")
+	theirs := send(t, d, bob, carol, "hello carol")
+	gone := send(t, d, alice, bob, "hello again")
+	if _, err := DeleteMessageSQLite(d, gone.ID, gone.CreatedAt, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := EditMessageSQLite(d, hello.ID, "Hello there, bob", hello.CreatedAt, false); err != nil {
+		t.Fatal(err)
+	}
+
+	// Behaviour that differs between the FTS5 index and the LIKE fallback
+	onlyFTS, onlyLike := []int{}, []int{}
+	if fts5Built {
+		onlyFTS = []int{cafe.ID}
+	} else {
+		onlyLike = []int{hello.ID}
+	}
+
+	tests := []struct {
+		name  string
+		terms string
+		want  []int
+	}{
+		{"word", "hello", []int{hello.ID}},
+		{"case", "HELLO", []int{hello.ID}},
+		{"prefix", "hel", []int{hello.ID}},
+		{"every term", "hello bob", []int{hello.ID}},
+		{"edited text", "bob", []int{hello.ID}},
+		{"missing term", "hello nobody", []int{}},
+		{"accents", "cafe", onlyFTS},
+		{"inside a word", "ello", onlyLike},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := searchIDs(t, d, alice, models.SearchRequest{Terms: strings.Fields(tt.terms)})
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// Other people's conversations stay out of reach, results and cursors alike
+	if got := searchIDs(t, d, carol, models.SearchRequest{Terms: []string{"hello"}}); !reflect.DeepEqual(got, []int{theirs.ID}) {
+		t.Errorf("carol: got %v, want %v", got, []int{theirs.ID})
+	}
+	req := models.SearchRequest{Terms: []string{"hello"}, Before: theirs.ID, Limit: 10}
+	if _, err := SearchMessagesSQLite(d, alice, req); !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("cursor in another conversation: got %v, want %v", err, ErrInvalidCursor)
+	}
+}
+
+func TestSearchIndexRebuild(t *testing.T) {
+	if !fts5Built {
+		t.Skip("built without sqlite_fts5")
+	}
+	d := newTestDB(t)
+	alice, bob := addUser(t, d, "alice"), addUser(t, d, "bob")
+	if _, err := EnsureSearchIndex(d); err != nil {
+		t.Fatal(err)
+	}
+	before := send(t, d, alice, bob, "indexed")
+
+	// A build without FTS5 drops the triggers, so what it writes is not indexed
+	for name := range searchTriggers {
+		if _, err := d.Exec("DROP TRIGGER " + name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	missed := send(t, d, alice, bob, "indexed later")
+
+	if _, err := EnsureSearchIndex(d); err != nil {
+		t.Fatal(err)
+	}
+	want := []int{missed.ID, before.ID}
+	if got := searchIDs(t, d, alice, models.SearchRequest{Terms: []string{"indexed"}}); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,65 @@
+package sqlite
+
+import (
+	"database/sql"
+	"time"
+
+	"DB-Presentation/models"
+)
+
+// CreateSession stores a new session for a user.
+func CreateSession(db *sql.DB, id string, userID int, expiresAt time.Time) (models.Session, error) {
+	now := time.Now().UTC()
+	_, err := db.Exec("INSERT INTO sessions (id, user_id, created_at, expires_at) VALUES (?, ?, ?, ?)", id, userID, now, expiresAt.UTC())
+	if err != nil {
+		return models.Session{}, err
+	}
+	return models.Session{ID: id, UserID: userID, CreatedAt: now, ExpiresAt: expiresAt.UTC()}, nil
+}
+
+// GetSession loads a session by id, including revoked and expired ones.
+func GetSession(db *sql.DB, id string) (models.Session, error) {
+	var s models.Session
+	var revokedAt sql.NullTime
+	err := db.QueryRow("SELECT id, user_id, created_at, expires_at, revoked_at FROM sessions WHERE id = ?", id).
+		Scan(&s.ID, &s.UserID, &s.CreatedAt, &s.ExpiresAt, &revokedAt)
+	if err != nil {
+		return models.Session{}, err
+	}
+	if revokedAt.Valid {
+		t := revokedAt.Time
+		s.RevokedAt = &t
+	}
+	return s, nil
+}
+
+// RevokeSession marks a single session as revoked.
+func RevokeSession(db *sql.DB, id string) error {
+	_, err := db.Exec("UPDATE sessions SET revoked_at = ? WHERE id = ? AND revoked_at IS NULL", time.Now().UTC(), id)
+	return err
+}
+
+// RevokeUserSessions revokes every active session of a user except keepID (pass "" to revoke all)
+// and returns the ids that were revoked.
+func RevokeUserSessions(db *sql.DB, userID int, keepID string) ([]string, error) {
+	rows, err := db.Query("SELECT id FROM sessions WHERE user_id = ? AND id != ? AND revoked_at IS NULL", userID, keepID)
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+
+	for _, id := range ids {
+		if err := RevokeSession(db, id); err != nil {
+			return nil, err
+		}
+	}
+	return ids, nil
+}
@@ -5,68 +5,384 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"strings"
+	"time"
 
 	"DB-Presentation/models"
+	"DB-Presentation/storage"
 
 	"golang.org/x/crypto/bcrypt"
 )
 
-// GetMessagesSQLite fetches messages between two users from SQLite.
-func GetMessagesSQLite(db *sql.DB, userID, friendID int) ([]models.Message, error) {
-	rows, err := db.Query(`
-        SELECT m.id, m.sender_id, u.username, m.recipient_id, m.message, m.is_read, m.created_at
-        FROM messages m
-        JOIN users u ON m.sender_id = u.id
-        WHERE (m.sender_id = ? AND m.recipient_id = ?) 
-           OR (m.sender_id = ? AND m.recipient_id = ?)
-        ORDER BY m.created_at ASC
-        LIMIT 100
-    `, userID, friendID, friendID, userID)
-	if err != nil {
-		return nil, err
+// messageColumns is the select list read by scanMessage. Queries alias messages as m
+// and the sender's users row as u.
+const messageColumns = `m.id, m.conversation_id, m.sender_id, u.username, m.recipient_id, m.message, m.is_read, m.created_at, m.edited_at, m.deleted_at, m.reply_to_id`
+
+// ErrInvalidCursor is returned for page cursors outside the requested conversation.
+var ErrInvalidCursor = storage.ErrInvalidCursor
+
+// ErrInvalidReply is returned when reply_to_id is not a message in the same conversation.
+var ErrInvalidReply = storage.ErrInvalidReply
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanMessage reads one row selected with messageColumns.
+func scanMessage(row rowScanner) (models.Message, error) {
+	var msg models.Message
+	var editedAt, deletedAt sql.NullTime
+	var recipientID, replyTo sql.NullInt64
+	if err := row.Scan(&msg.ID, &msg.ConversationID, &msg.SenderID, &msg.SenderName, &recipientID, &msg.Message, &msg.IsRead, &msg.CreatedAt, &editedAt, &deletedAt, &replyTo); err != nil {
+		return msg, err
 	}
-	defer rows.Close()
+	msg.RecipientID = int(recipientID.Int64)
+	if editedAt.Valid {
+		t := editedAt.Time.UTC()
+		msg.EditedAt = &t
+	}
+	if deletedAt.Valid {
+		t := deletedAt.Time.UTC()
+		msg.DeletedAt = &t
+	}
+	if replyTo.Valid {
+		id := int(replyTo.Int64)
+		msg.ReplyToID = &id
+	}
+	return msg, nil
+}
 
+// scanMessages reads every row of a query selecting messageColumns and fills in
+// attachments, reactions and reply previews.
+func scanMessages(db *sql.DB, rows *sql.Rows) ([]models.Message, error) {
 	var messages []models.Message
 	for rows.Next() {
-		var msg models.Message
-		if err := rows.Scan(&msg.ID, &msg.SenderID, &msg.SenderName, &msg.RecipientID, &msg.Message, &msg.IsRead, &msg.CreatedAt); err != nil {
+		msg, err := scanMessage(rows)
+		if err != nil {
 			continue
 		}
 		messages = append(messages, msg)
 	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
 
+	if err := fillMessages(db, messages); err != nil {
+		return nil, err
+	}
 	return messages, nil
 }
 
+// fillMessages loads the attachments, reactions and reply previews of messages.
+func fillMessages(db *sql.DB, messages []models.Message) error {
+	ids := make([]int, 0, len(messages))
+	var replyIDs []int
+	for _, msg := range messages {
+		ids = append(ids, msg.ID)
+		if msg.ReplyToID != nil {
+			replyIDs = append(replyIDs, *msg.ReplyToID)
+		}
+	}
+
+	attachments, err := attachmentsFor(db, ids)
+	if err != nil {
+		return err
+	}
+	reactions, err := reactionsFor(db, ids)
+	if err != nil {
+		return err
+	}
+	previews, err := previewsFor(db, replyIDs)
+	if err != nil {
+		return err
+	}
+	for i := range messages {
+		messages[i].Attachments = attachments[messages[i].ID]
+		messages[i].Reactions = reactions[messages[i].ID]
+		if messages[i].ReplyToID != nil {
+			if p, ok := previews[*messages[i].ReplyToID]; ok {
+				messages[i].ReplyTo = &p
+			}
+		}
+	}
+	return nil
+}
+
+// previewsFor loads previews of the given (quoted) messages.
+func previewsFor(db *sql.DB, messageIDs []int) (map[int]models.MessagePreview, error) {
+	out := make(map[int]models.MessagePreview)
+	if len(messageIDs) == 0 {
+		return out, nil
+	}
+
+	args := make([]interface{}, len(messageIDs))
+	for i, id := range messageIDs {
+		args[i] = id
+	}
+	rows, err := db.Query(`
+		SELECT `+messageColumns+`
+		FROM messages m
+		JOIN users u ON m.sender_id = u.id
+		WHERE m.id IN (?`+strings.Repeat(",?", len(messageIDs)-1)+`)
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		msg, err := scanMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+		out[msg.ID] = msg.Preview()
+	}
+	return out, rows.Err()
+}
+
+// GetMessagesSQLite fetches one page of the conversation between two users, ordered
+// by (created_at, id) so messages sent in the same second keep a stable order.
+// Cursors must be messages of this conversation (ErrInvalidCursor otherwise).
+func GetMessagesSQLite(db *sql.DB, userID, friendID int, req models.MessagePageRequest) (models.MessagePage, error) {
+	return pageMessages(db, `((m.sender_id = ? AND m.recipient_id = ?) OR (m.sender_id = ? AND m.recipient_id = ?))`,
+		[]interface{}{userID, friendID, friendID, userID}, req)
+}
+
+// GetConversationMessagesSQLite fetches one page of a conversation's messages,
+// ordered and paged like GetMessagesSQLite.
+func GetConversationMessagesSQLite(db *sql.DB, conversationID int, req models.MessagePageRequest) (models.MessagePage, error) {
+	return pageMessages(db, `m.conversation_id = ?`, []interface{}{conversationID}, req)
+}
+
+// pageMessages fetches one page of the messages matching filter.
+func pageMessages(db *sql.DB, filter string, args []interface{}, req models.MessagePageRequest) (models.MessagePage, error) {
+	cursor, order := req.Before, "DESC"
+	if req.After != 0 {
+		cursor, order = req.After, "ASC"
+	}
+	where := filter
+	if cursor != 0 {
+		var n int
+		if err := db.QueryRow("SELECT COUNT(*) FROM messages m WHERE m.id = ? AND "+filter, append([]interface{}{cursor}, args...)...).Scan(&n); err != nil {
+			return models.MessagePage{}, err
+		}
+		if n == 0 {
+			return models.MessagePage{}, ErrInvalidCursor
+		}
+		cmp := "<"
+		if order == "ASC" {
+			cmp = ">"
+		}
+		where += ` AND (m.created_at, m.id) ` + cmp + ` (SELECT created_at, id FROM messages WHERE id = ?)`
+		args = append(args, cursor)
+	}
+
+	rows, err := db.Query(`
+        SELECT `+messageColumns+`
+        FROM messages m
+        JOIN users u ON m.sender_id = u.id
+        WHERE `+where+`
+        ORDER BY m.created_at `+order+`, m.id `+order+`
+        LIMIT ?
+    `, append(args, req.Limit+1)...)
+	if err != nil {
+		return models.MessagePage{}, err
+	}
+	defer rows.Close()
+
+	messages, err := scanMessages(db, rows)
+	if err != nil {
+		return models.MessagePage{}, err
+	}
+	return models.NewMessagePage(messages, req), nil
+}
+
 // InsertMessageSQLite inserts a message into SQLite and returns the created message (with created_at filled).
-func InsertMessageSQLite(db *sql.DB, senderID, recipientID int, message string) (models.Message, error) {
-	result, err := db.Exec("INSERT INTO messages (sender_id, recipient_id, message) VALUES (?, ?, ?)", senderID, recipientID, message)
+// A message without req.ConversationID goes to the direct conversation with req.RecipientID,
+// which is created on first use; group messages have no recipient.
+// The given attachments, uploaded by the sender and not yet sent, are attached in the same transaction.
+// A non-zero reply_to_id must be a message in the same conversation (ErrInvalidReply otherwise).
+// With queue set, the message is also added to message_outbox in the same transaction.
+func InsertMessageSQLite(db *sql.DB, senderID int, req models.SendMessageRequest, queue bool) (models.Message, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return models.Message{}, err
+	}
+	defer tx.Rollback()
+
+	conversationID := req.ConversationID
+	var recipient interface{}
+	if conversationID == 0 {
+		if conversationID, err = directConversation(tx, senderID, req.RecipientID); err != nil {
+			return models.Message{}, err
+		}
+		recipient = req.RecipientID
+	}
+
+	var replyTo interface{}
+	if req.ReplyToID != 0 {
+		var n int
+		if err := tx.QueryRow("SELECT COUNT(*) FROM messages WHERE id = ? AND conversation_id = ?", req.ReplyToID, conversationID).Scan(&n); err != nil {
+			return models.Message{}, err
+		}
+		if n == 0 {
+			return models.Message{}, ErrInvalidReply
+		}
+		replyTo = req.ReplyToID
+	}
+
+	result, err := tx.Exec("INSERT INTO messages (conversation_id, sender_id, recipient_id, message, reply_to_id) VALUES (?, ?, ?, ?, ?)",
+		conversationID, senderID, recipient, req.Message, replyTo)
 	if err != nil {
 		return models.Message{}, err
 	}
 
 	messageID, _ := result.LastInsertId()
+	if err := claimAttachments(tx, messageID, senderID, req.AttachmentIDs); err != nil {
+		return models.Message{}, err
+	}
+	if queue {
+		if err := queueOutbox(tx, int(messageID)); err != nil {
+			return models.Message{}, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return models.Message{}, err
+	}
 
-	var msg models.Message
-	err = db.QueryRow(`
-        SELECT m.id, m.sender_id, u.username, m.recipient_id, m.message, m.is_read, m.created_at
+	return GetMessageSQLite(db, int(messageID))
+}
+
+// GetMessageSQLite fetches a single message. It returns sql.ErrNoRows for unknown ids.
+func GetMessageSQLite(db *sql.DB, id int) (models.Message, error) {
+	msg, err := scanMessage(db.QueryRow(`
+        SELECT `+messageColumns+`
         FROM messages m
         JOIN users u ON m.sender_id = u.id
         WHERE m.id = ?
-    `, messageID).Scan(&msg.ID, &msg.SenderID, &msg.SenderName, &msg.RecipientID, &msg.Message, &msg.IsRead, &msg.CreatedAt)
-
+    `, id))
 	if err != nil {
 		return models.Message{}, err
 	}
 
-	return msg, nil
+	messages := []models.Message{msg}
+	if err := fillMessages(db, messages); err != nil {
+		return models.Message{}, err
+	}
+	return messages[0], nil
+}
+
+// MessagesAfterSQLite returns up to limit messages with ids above afterID in id
+// order, for tools that walk the whole table.
+func MessagesAfterSQLite(db *sql.DB, afterID, limit int) ([]models.Message, error) {
+	rows, err := db.Query(`
+        SELECT `+messageColumns+`
+        FROM messages m
+        JOIN users u ON m.sender_id = u.id
+        WHERE m.id > ?
+        ORDER BY m.id
+        LIMIT ?
+    `, afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanMessages(db, rows)
+}
+
+// ChangedMessagesSQLite returns up to limit messages with ids in (afterID, maxID]
+// that changed at or after since, in id order. Messages never changed after being
+// sent count as changed when they were sent.
+func ChangedMessagesSQLite(db *sql.DB, since time.Time, afterID, maxID, limit int) ([]models.Message, error) {
+	rows, err := db.Query(`
+        SELECT `+messageColumns+`
+        FROM messages m
+        JOIN users u ON m.sender_id = u.id
+        WHERE m.id > ? AND m.id <= ? AND COALESCE(m.updated_at, m.created_at) >= ?
+        ORDER BY m.id
+        LIMIT ?
+    `, afterID, maxID, since.UTC().Format("2006-01-02 15:04:05"), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanMessages(db, rows)
+}
+
+// ExistingMessageIDs reports which of the given message ids exist.
+func ExistingMessageIDs(db *sql.DB, ids []int) (map[int]bool, error) {
+	exists := make(map[int]bool)
+	if len(ids) == 0 {
+		return exists, nil
+	}
+
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	found, err := queryIDs(db, "SELECT id FROM messages WHERE id IN (?"+strings.Repeat(",?", len(ids)-1)+")", args...)
+	for _, id := range found {
+		exists[id] = true
+	}
+	return exists, err
 }
 
-// MarkMessagesReadSQLite marks messages as read in SQLite.
-func MarkMessagesReadSQLite(db *sql.DB, senderID, recipientID int) error {
-	_, err := db.Exec("UPDATE messages SET is_read = 1 WHERE sender_id = ? AND recipient_id = ?", senderID, recipientID)
-	return err
+// MatchMessageSQLite returns the ids of messages from senderID to recipientID with the
+// given text sent within a second of createdAt, lowest first. A non-zero
+// conversationID must match too. It identifies copies written before messages
+// carried their id.
+func MatchMessageSQLite(db *sql.DB, conversationID, senderID, recipientID int, message string, createdAt time.Time) ([]int, error) {
+	return queryIDs(db, `
+        SELECT id FROM messages
+        WHERE sender_id = ? AND COALESCE(recipient_id, 0) = ? AND message = ?
+          AND (? = 0 OR conversation_id = ?)
+          AND ABS(julianday(created_at) - julianday(?)) * 86400 <= 1
+        ORDER BY id
+    `, senderID, recipientID, message, conversationID, conversationID, createdAt.UTC().Format("2006-01-02 15:04:05"))
+}
+
+// RepliesSQLite returns every message quoting the given one, oldest first.
+func RepliesSQLite(db *sql.DB, id int) ([]models.Message, error) {
+	rows, err := db.Query(`
+        SELECT `+messageColumns+`
+        FROM messages m
+        JOIN users u ON m.sender_id = u.id
+        WHERE m.reply_to_id = ?
+        ORDER BY m.created_at ASC, m.id ASC
+    `, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanMessages(db, rows)
+}
+
+// MarkMessagesReadSQLite marks messages as read in SQLite. With queue set, the
+// messages it marks are also added to message_outbox in the same transaction.
+func MarkMessagesReadSQLite(db *sql.DB, senderID, recipientID int, queue bool) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if queue {
+		_, err := tx.Exec(`
+			INSERT INTO message_outbox (message_id)
+			SELECT id FROM messages WHERE sender_id = ? AND recipient_id = ? AND is_read = 0
+		`, senderID, recipientID)
+		if err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec("UPDATE messages SET is_read = 1 WHERE sender_id = ? AND recipient_id = ? AND is_read = 0", senderID, recipientID); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
 // CountUnreadSQLite returns unread count for a recipient.
@@ -76,6 +392,25 @@ func CountUnreadSQLite(db *sql.DB, recipientID int) (int, error) {
 	return count, err
 }
 
+// UnreadCountsSQLite returns a recipient's unread direct messages counted per sender.
+func UnreadCountsSQLite(db *sql.DB, recipientID int) (map[int]int, error) {
+	rows, err := db.Query("SELECT sender_id, COUNT(*) FROM messages WHERE recipient_id = ? AND is_read = 0 GROUP BY sender_id", recipientID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make(map[int]int)
+	for rows.Next() {
+		var senderID, count int
+		if err := rows.Scan(&senderID, &count); err != nil {
+			return nil, err
+		}
+		counts[senderID] = count
+	}
+	return counts, rows.Err()
+}
+
 // SeedData inserts the default admin user from mock_user.json if it doesn't exist.
 func SeedData(db *sql.DB) error {
 	// Read the mock user JSON file
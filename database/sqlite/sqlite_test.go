@@ -0,0 +1,111 @@
+package sqlite
+
+import (
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"DB-Presentation/db"
+	"DB-Presentation/models"
+)
+
+// newTestDB returns a migrated temporary database.
+func newTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	d, err := db.OpenDB(filepath.Join(t.TempDir(), "chat.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.Close() })
+	if err := db.RunMigrations(d); err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+// addUser inserts a user and returns its id.
+func addUser(t *testing.T, d *sql.DB, username string) int {
+	t.Helper()
+	res, err := d.Exec("INSERT INTO users (username, password) VALUES (?, 'x')", username)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, _ := res.LastInsertId()
+	return int(id)
+}
+
+// send inserts a message from senderID to recipientID.
+func send(t *testing.T, d *sql.DB, senderID, recipientID int, text string) models.Message {
+	t.Helper()
+	msg, err := InsertMessageSQLite(d, senderID, models.SendMessageRequest{RecipientID: recipientID, Message: text}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return msg
+}
+
+// pageIDs returns the ids of a page's messages.
+func pageIDs(page models.MessagePage) []int {
+	ids := []int{}
+	for _, m := range page.Messages {
+		ids = append(ids, m.ID)
+	}
+	return ids
+}
+
+func TestGetMessagesCursors(t *testing.T) {
+	d := newTestDB(t)
+	alice, bob, carol := addUser(t, d, "alice"), addUser(t, d, "bob"), addUser(t, d, "carol")
+
+	// Five messages in the same second, so only the id keeps them in order
+	var ids []int
+	for i := 0; i < 5; i++ {
+		ids = append(ids, send(t, d, alice, bob, "hello").ID)
+	}
+	if _, err := d.Exec("UPDATE messages SET created_at = '2024-01-01 12:00:00'"); err != nil {
+		t.Fatal(err)
+	}
+	other := send(t, d, alice, carol, "elsewhere")
+
+	tests := []struct {
+		name   string
+		req    models.MessagePageRequest
+		want   []int
+		cursor string
+		err    error
+	}{
+		{"newest", models.MessagePageRequest{Limit: 2}, ids[3:], strconv.Itoa(ids[3]), nil},
+		{"everything", models.MessagePageRequest{Limit: 5}, ids, "", nil},
+		{"before", models.MessagePageRequest{Before: ids[3], Limit: 2}, ids[1:3], strconv.Itoa(ids[1]), nil},
+		{"before the last page", models.MessagePageRequest{Before: ids[2], Limit: 2}, ids[:2], "", nil},
+		{"before the oldest", models.MessagePageRequest{Before: ids[0], Limit: 2}, []int{}, "", nil},
+		{"after", models.MessagePageRequest{After: ids[0], Limit: 2}, ids[1:3], strconv.Itoa(ids[2]), nil},
+		{"after to the end", models.MessagePageRequest{After: ids[2], Limit: 2}, ids[3:], "", nil},
+		{"after the newest", models.MessagePageRequest{After: ids[4], Limit: 2}, []int{}, "", nil},
+		{"unknown cursor", models.MessagePageRequest{Before: other.ID + 1, Limit: 2}, nil, "", ErrInvalidCursor},
+		{"cursor from another conversation", models.MessagePageRequest{After: other.ID, Limit: 2}, nil, "", ErrInvalidCursor},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Either side of the conversation sees the same pages
+			for _, viewer := range [][2]int{{alice, bob}, {bob, alice}} {
+				page, err := GetMessagesSQLite(d, viewer[0], viewer[1], tt.req)
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("got %v, want %v", err, tt.err)
+				}
+				if err != nil {
+					continue
+				}
+				if got := pageIDs(page); !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("got %v, want %v", got, tt.want)
+				}
+				if page.NextCursor != tt.cursor || page.HasMore != (tt.cursor != "") {
+					t.Errorf("got cursor %q (more %v), want %q", page.NextCursor, page.HasMore, tt.cursor)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"DB-Presentation/models"
+	"DB-Presentation/storage"
+)
+
+// MessageStore is the storage.MessageStore kept in the SQLite database. It is also
+// the primary store other backends mirror.
+type MessageStore struct {
+	db     *sql.DB
+	outbox bool
+}
+
+var _ storage.MessageStore = (*MessageStore)(nil)
+
+// NewMessageStore returns a MessageStore over db, which must be migrated. With
+// outbox set, inserted, edited, deleted and read messages, and messages whose
+// reactions change, are also queued in message_outbox for a relay to copy elsewhere.
+func NewMessageStore(db *sql.DB, outbox bool) *MessageStore {
+	return &MessageStore{db: db, outbox: outbox}
+}
+
+func (s *MessageStore) Insert(ctx context.Context, senderID int, req models.SendMessageRequest) (models.Message, error) {
+	return InsertMessageSQLite(s.db, senderID, req, s.outbox)
+}
+
+func (s *MessageStore) Get(ctx context.Context, id int) (models.Message, error) {
+	msg, err := GetMessageSQLite(s.db, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return msg, storage.ErrMessageNotFound
+	}
+	return msg, err
+}
+
+func (s *MessageStore) List(ctx context.Context, userID, friendID int, req models.MessagePageRequest) (models.MessagePage, error) {
+	return GetMessagesSQLite(s.db, userID, friendID, req)
+}
+
+func (s *MessageStore) ListConversation(ctx context.Context, conversationID int, req models.MessagePageRequest) (models.MessagePage, error) {
+	return GetConversationMessagesSQLite(s.db, conversationID, req)
+}
+
+func (s *MessageStore) Replies(ctx context.Context, id int) ([]models.Message, error) {
+	return RepliesSQLite(s.db, id)
+}
+
+func (s *MessageStore) MarkRead(ctx context.Context, userID, friendID int) error {
+	return MarkMessagesReadSQLite(s.db, friendID, userID, s.outbox)
+}
+
+func (s *MessageStore) MarkConversationRead(ctx context.Context, conversationID, userID int) error {
+	return MarkConversationRead(s.db, conversationID, userID)
+}
+
+// CountUnread joins against conversation_members, so conversationIDs is not needed.
+func (s *MessageStore) CountUnread(ctx context.Context, userID int, conversationIDs []int) (int, error) {
+	direct, err := CountUnreadSQLite(s.db, userID)
+	if err != nil {
+		return 0, err
+	}
+	groups, err := CountGroupUnread(s.db, userID)
+	return direct + groups, err
+}
+
+func (s *MessageStore) UnreadCounts(ctx context.Context, userID int) (map[int]int, error) {
+	return UnreadCountsSQLite(s.db, userID)
+}
+
+func (s *MessageStore) Edit(ctx context.Context, id int, message string, editedAt time.Time) (models.Message, error) {
+	msg, err := EditMessageSQLite(s.db, id, message, editedAt, s.outbox)
+	if errors.Is(err, sql.ErrNoRows) {
+		return msg, storage.ErrMessageNotFound
+	}
+	return msg, err
+}
+
+func (s *MessageStore) Delete(ctx context.Context, id int, deletedAt time.Time) ([]string, error) {
+	return DeleteMessageSQLite(s.db, id, deletedAt, s.outbox)
+}
+
+func (s *MessageStore) Edits(ctx context.Context, id int) ([]models.MessageEdit, error) {
+	return MessageEditsSQLite(s.db, id)
+}
+
+// AddReaction stores r; the username and time are filled in by the database.
+func (s *MessageStore) AddReaction(ctx context.Context, r models.MessageReaction) (bool, error) {
+	return AddReactionSQLite(s.db, r.MessageID, r.UserID, r.Emoji, s.outbox)
+}
+
+func (s *MessageStore) RemoveReaction(ctx context.Context, messageID, userID int, emoji string) (bool, error) {
+	return RemoveReactionSQLite(s.db, messageID, userID, emoji, s.outbox)
+}
+
+func (s *MessageStore) Reactions(ctx context.Context, messageID int) ([]models.MessageReaction, error) {
+	return ReactionsSQLite(s.db, messageID)
+}
+
+func (s *MessageStore) ReactionCounts(ctx context.Context, messageID int) ([]models.Reaction, error) {
+	return ReactionCountsSQLite(s.db, messageID)
+}
+
+// Search joins against conversation_members, so req.ConversationIDs is not needed.
+func (s *MessageStore) Search(ctx context.Context, userID int, req models.SearchRequest) (models.SearchPage, error) {
+	return SearchMessagesSQLite(s.db, userID, req)
+}
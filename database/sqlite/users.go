@@ -0,0 +1,73 @@
+package sqlite
+
+import (
+	"database/sql"
+
+	"DB-Presentation/models"
+)
+
+// GetProfile loads a user's full profile. It returns sql.ErrNoRows for unknown users.
+func GetProfile(db *sql.DB, userID int) (models.Profile, error) {
+	var p models.Profile
+	var email, bio, avatarColor, avatarID, status sql.NullString
+	var lastSeen, createdAt sql.NullTime
+	err := db.QueryRow(`
+		SELECT id, username, email, bio, avatar_color, avatar_id, status, last_seen_at, created_at
+		FROM users WHERE id = ?
+	`, userID).Scan(&p.ID, &p.Username, &email, &bio, &avatarColor, &avatarID, &status, &lastSeen, &createdAt)
+	if err != nil {
+		return p, err
+	}
+
+	p.Email = email.String
+	p.Bio = bio.String
+	p.AvatarColor = avatarColor.String
+	p.AvatarID = avatarID.String
+	p.Status = status.String
+	if lastSeen.Valid {
+		t := lastSeen.Time.UTC()
+		p.LastSeenAt = &t
+	}
+	if createdAt.Valid {
+		t := createdAt.Time.UTC()
+		p.CreatedAt = &t
+	}
+	return p, nil
+}
+
+// UpdateProfile applies the fields set in req. Empty email or bio are stored as NULL.
+func UpdateProfile(db *sql.DB, userID int, req models.UpdateProfileRequest) error {
+	_, err := db.Exec(`
+		UPDATE users SET
+			email = CASE WHEN ? THEN NULLIF(?, '') ELSE email END,
+			bio = CASE WHEN ? THEN NULLIF(?, '') ELSE bio END,
+			avatar_color = COALESCE(?, avatar_color)
+		WHERE id = ?
+	`, req.Email != nil, deref(req.Email), req.Bio != nil, deref(req.Bio), req.AvatarColor, userID)
+	return err
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+// SetAvatarID replaces the user's avatar id ("" removes it) and returns the previous one.
+func SetAvatarID(db *sql.DB, userID int, avatarID string) (string, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	var old sql.NullString
+	if err := tx.QueryRow("SELECT avatar_id FROM users WHERE id = ?", userID).Scan(&old); err != nil {
+		return "", err
+	}
+	if _, err := tx.Exec("UPDATE users SET avatar_id = NULLIF(?, '') WHERE id = ?", avatarID, userID); err != nil {
+		return "", err
+	}
+	return old.String, tx.Commit()
+}
@@ -5,14 +5,15 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"path/filepath"
 
 	_ "github.com/mattn/go-sqlite3"
 )
 
 // OpenDB opens (and creates) the SQLite database file and returns the DB handle.
 func OpenDB(path string) (*sql.DB, error) {
-	// Ensure data directory exists
-	if err := os.MkdirAll("data", 0755); err != nil {
+	// Ensure the database's directory exists
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
 		return nil, err
 	}
 
@@ -0,0 +1,577 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+)
+
+// RunMigrations executes all database migrations
+func RunMigrations(db *sql.DB) error {
+	// Create migrations table to track which migrations have been run
+	if err := createMigrationsTable(db); err != nil {
+		return fmt.Errorf("failed to create migrations table: %v", err)
+	}
+
+	// List of all migrations
+	migrations := []Migration{
+		{Version: 1, Name: "create_users_table", Up: createUsersTable},
+		{Version: 2, Name: "create_friendships_table", Up: createFriendshipsTable},
+		{Version: 3, Name: "create_messages_table", Up: createMessagesTable},
+		{Version: 4, Name: "create_sessions_table", Up: createSessionsTable},
+		{Version: 5, Name: "create_user_events_table", Up: createUserEventsTable},
+		{Version: 6, Name: "add_users_last_seen_at", Up: addUsersLastSeenAt},
+		{Version: 7, Name: "add_users_avatar_id", Up: addUsersAvatarID},
+		{Version: 8, Name: "create_attachments_table", Up: createAttachmentsTable},
+		{Version: 9, Name: "create_message_edits_table", Up: createMessageEditsTable},
+		{Version: 10, Name: "create_message_reactions_table", Up: createMessageReactionsTable},
+		{Version: 11, Name: "add_messages_reply_to_id", Up: addMessagesReplyToID},
+		{Version: 12, Name: "add_messages_conversation_time_index", Up: addMessagesConversationTimeIndex},
+		{Version: 13, Name: "create_conversations", Up: createConversations},
+		{Version: 14, Name: "create_message_outbox_table", Up: createMessageOutboxTable},
+		{Version: 15, Name: "add_messages_updated_at", Up: addMessagesUpdatedAt},
+		{Version: 16, Name: "add_reactions_touch", Up: addReactionsTouch},
+		{Version: 17, Name: "create_presence_instances_table", Up: createPresenceInstancesTable},
+		// Add new migrations here in the future
+	}
+
+	// Run each migration
+	for _, migration := range migrations {
+		if err := runMigration(db, migration); err != nil {
+			return fmt.Errorf("migration %d (%s) failed: %v", migration.Version, migration.Name, err)
+		}
+	}
+
+	log.Println("✅ All migrations completed successfully")
+	return nil
+}
+
+// Migration represents a database migration
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(*sql.DB) error
+}
+
+// createMigrationsTable creates a table to track migration versions
+func createMigrationsTable(db *sql.DB) error {
+	query := `
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`
+	_, err := db.Exec(query)
+	return err
+}
+
+// runMigration runs a single migration if it hasn't been run yet
+func runMigration(db *sql.DB, migration Migration) error {
+	// Check if migration has already been applied
+	var count int
+	err := db.QueryRow("SELECT COUNT(*) FROM schema_migrations WHERE version = ?", migration.Version).Scan(&count)
+	if err != nil {
+		return err
+	}
+
+	if count > 0 {
+		log.Printf("⏭️  Migration %d (%s) already applied, skipping", migration.Version, migration.Name)
+		return nil
+	}
+
+	// Run the migration
+	log.Printf("🔄 Running migration %d: %s", migration.Version, migration.Name)
+	if err := migration.Up(db); err != nil {
+		return err
+	}
+
+	// Record that migration was applied
+	_, err = db.Exec("INSERT INTO schema_migrations (version, name) VALUES (?, ?)", migration.Version, migration.Name)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("✅ Migration %d (%s) completed", migration.Version, migration.Name)
+	return nil
+}
+
+// createUsersTable creates the users table
+func createUsersTable(db *sql.DB) error {
+	query := `
+	CREATE TABLE IF NOT EXISTS users (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		username TEXT NOT NULL UNIQUE,
+		password TEXT NOT NULL,
+		email TEXT,
+		bio TEXT,
+		avatar_color TEXT DEFAULT '#8774e1',
+		status TEXT DEFAULT 'offline' CHECK(status IN ('online', 'offline', 'away')),
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`
+	_, err := db.Exec(query)
+	if err != nil {
+		return err
+	}
+
+	// Create trigger for updated_at
+	trigger := `
+	CREATE TRIGGER IF NOT EXISTS update_users_timestamp 
+	AFTER UPDATE ON users
+	FOR EACH ROW
+	BEGIN
+		UPDATE users SET updated_at = CURRENT_TIMESTAMP WHERE id = OLD.id;
+	END`
+	_, err = db.Exec(trigger)
+	return err
+}
+
+// createFriendshipsTable creates the friendships table
+func createFriendshipsTable(db *sql.DB) error {
+	query := `
+	CREATE TABLE IF NOT EXISTS friendships (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL,
+		friend_id INTEGER NOT NULL,
+		status TEXT NOT NULL DEFAULT 'pending' CHECK(status IN ('pending', 'accepted', 'rejected')),
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE(user_id, friend_id),
+		FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+		FOREIGN KEY (friend_id) REFERENCES users(id) ON DELETE CASCADE
+	)`
+	_, err := db.Exec(query)
+	if err != nil {
+		return err
+	}
+
+	// Create indexes
+	indexes := []string{
+		"CREATE INDEX IF NOT EXISTS idx_friendships_user_id ON friendships(user_id)",
+		"CREATE INDEX IF NOT EXISTS idx_friendships_friend_id ON friendships(friend_id)",
+	}
+
+	for _, idx := range indexes {
+		if _, err := db.Exec(idx); err != nil {
+			return err
+		}
+	}
+
+	// Create trigger for updated_at
+	trigger := `
+	CREATE TRIGGER IF NOT EXISTS update_friendships_timestamp 
+	AFTER UPDATE ON friendships
+	FOR EACH ROW
+	BEGIN
+		UPDATE friendships SET updated_at = CURRENT_TIMESTAMP WHERE id = OLD.id;
+	END`
+	_, err = db.Exec(trigger)
+	return err
+}
+
+// createMessagesTable creates the messages table
+func createMessagesTable(db *sql.DB) error {
+	query := `
+	CREATE TABLE IF NOT EXISTS messages (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		sender_id INTEGER NOT NULL,
+		recipient_id INTEGER NOT NULL,
+		message TEXT NOT NULL,
+		is_read INTEGER NOT NULL DEFAULT 0,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (sender_id) REFERENCES users(id) ON DELETE CASCADE,
+		FOREIGN KEY (recipient_id) REFERENCES users(id) ON DELETE CASCADE
+	)`
+	_, err := db.Exec(query)
+	if err != nil {
+		return err
+	}
+
+	// Create indexes
+	indexes := []string{
+		"CREATE INDEX IF NOT EXISTS idx_messages_sender_id ON messages(sender_id)",
+		"CREATE INDEX IF NOT EXISTS idx_messages_recipient_id ON messages(recipient_id)",
+		"CREATE INDEX IF NOT EXISTS idx_messages_conversation ON messages(sender_id, recipient_id)",
+	}
+
+	for _, idx := range indexes {
+		if _, err := db.Exec(idx); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// createSessionsTable creates the sessions table backing login tokens
+func createSessionsTable(db *sql.DB) error {
+	query := `
+	CREATE TABLE IF NOT EXISTS sessions (
+		id TEXT PRIMARY KEY,
+		user_id INTEGER NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		expires_at DATETIME NOT NULL,
+		revoked_at DATETIME,
+		FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+	)`
+	_, err := db.Exec(query)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec("CREATE INDEX IF NOT EXISTS idx_sessions_user_id ON sessions(user_id)")
+	return err
+}
+
+// createUserEventsTable creates the per-user event log used to replay missed
+// websocket events; seq increases monotonically for each user
+func createUserEventsTable(db *sql.DB) error {
+	query := `
+	CREATE TABLE IF NOT EXISTS user_events (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL,
+		seq INTEGER NOT NULL,
+		type TEXT NOT NULL,
+		data TEXT NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE(user_id, seq),
+		FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+	)`
+	_, err := db.Exec(query)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec("CREATE INDEX IF NOT EXISTS idx_user_events_created_at ON user_events(created_at)")
+	return err
+}
+
+// addUsersLastSeenAt records when each user was last connected, for presence
+func addUsersLastSeenAt(db *sql.DB) error {
+	_, err := db.Exec("ALTER TABLE users ADD COLUMN last_seen_at DATETIME")
+	return err
+}
+
+// addUsersAvatarID stores the id of the user's uploaded avatar images, if any
+func addUsersAvatarID(db *sql.DB) error {
+	_, err := db.Exec("ALTER TABLE users ADD COLUMN avatar_id TEXT")
+	return err
+}
+
+// createAttachmentsTable creates the table of uploaded files. An attachment is
+// uploaded first (message_id NULL) and then claimed by the message that sends it.
+func createAttachmentsTable(db *sql.DB) error {
+	query := `
+	CREATE TABLE IF NOT EXISTS attachments (
+		id TEXT PRIMARY KEY,
+		uploader_id INTEGER NOT NULL,
+		message_id INTEGER,
+		filename TEXT NOT NULL,
+		mime_type TEXT NOT NULL,
+		size INTEGER NOT NULL,
+		sha256 TEXT NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (uploader_id) REFERENCES users(id) ON DELETE CASCADE,
+		FOREIGN KEY (message_id) REFERENCES messages(id) ON DELETE CASCADE
+	)`
+	_, err := db.Exec(query)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec("CREATE INDEX IF NOT EXISTS idx_attachments_message_id ON attachments(message_id)")
+	return err
+}
+
+// createMessageEditsTable keeps earlier versions of edited messages and adds the
+// edited/deleted markers to messages
+func createMessageEditsTable(db *sql.DB) error {
+	statements := []string{
+		"ALTER TABLE messages ADD COLUMN edited_at DATETIME",
+		"ALTER TABLE messages ADD COLUMN deleted_at DATETIME",
+		`CREATE TABLE IF NOT EXISTS message_edits (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			message_id INTEGER NOT NULL,
+			message TEXT NOT NULL,
+			edited_at DATETIME NOT NULL,
+			FOREIGN KEY (message_id) REFERENCES messages(id) ON DELETE CASCADE
+		)`,
+		"CREATE INDEX IF NOT EXISTS idx_message_edits_message_id ON message_edits(message_id)",
+	}
+
+	for _, stmt := range statements {
+		if _, err := db.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// createMessageReactionsTable creates the emoji reactions table: one row per user,
+// message and emoji
+func createMessageReactionsTable(db *sql.DB) error {
+	query := `
+	CREATE TABLE IF NOT EXISTS message_reactions (
+		message_id INTEGER NOT NULL,
+		user_id INTEGER NOT NULL,
+		emoji TEXT NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (message_id, user_id, emoji),
+		FOREIGN KEY (message_id) REFERENCES messages(id) ON DELETE CASCADE,
+		FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+	)`
+	_, err := db.Exec(query)
+	return err
+}
+
+// addMessagesReplyToID lets a message quote an earlier message in its conversation
+func addMessagesReplyToID(db *sql.DB) error {
+	if _, err := db.Exec("ALTER TABLE messages ADD COLUMN reply_to_id INTEGER REFERENCES messages(id) ON DELETE SET NULL"); err != nil {
+		return err
+	}
+	_, err := db.Exec("CREATE INDEX IF NOT EXISTS idx_messages_reply_to_id ON messages(reply_to_id)")
+	return err
+}
+
+// addMessagesConversationTimeIndex supports paging through a conversation in
+// (created_at, id) order
+func addMessagesConversationTimeIndex(db *sql.DB) error {
+	_, err := db.Exec("CREATE INDEX IF NOT EXISTS idx_messages_conversation_time ON messages(sender_id, recipient_id, created_at, id)")
+	return err
+}
+
+// createConversations adds conversations (direct or group) and their members, and
+// moves every message into one. Each pair of users who have exchanged messages gets
+// a two-member direct conversation. messages is rebuilt so that recipient_id can be
+// NULL, as group messages have no single recipient.
+func createConversations(db *sql.DB) error {
+	ctx := context.Background()
+
+	// foreign_keys can only be switched outside a transaction, so pin one connection.
+	// It must be off while messages is rebuilt, or dropping the old table would
+	// cascade to attachments, edits and reactions.
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(ctx, "PRAGMA foreign_keys = OFF"); err != nil {
+		return err
+	}
+	defer conn.ExecContext(ctx, "PRAGMA foreign_keys = ON")
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var lastID int64
+	if err := tx.QueryRow("SELECT COALESCE((SELECT seq FROM sqlite_sequence WHERE name = 'messages'), 0)").Scan(&lastID); err != nil {
+		return err
+	}
+
+	statements := []string{
+		`CREATE TABLE conversations (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			type TEXT NOT NULL CHECK (type IN ('direct', 'group')),
+			name TEXT,
+			direct_key TEXT UNIQUE,
+			created_by INTEGER,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (created_by) REFERENCES users(id) ON DELETE SET NULL
+		)`,
+		`CREATE TABLE conversation_members (
+			conversation_id INTEGER NOT NULL,
+			user_id INTEGER NOT NULL,
+			role TEXT NOT NULL DEFAULT 'member' CHECK (role IN ('owner', 'admin', 'member')),
+			last_read_message_id INTEGER NOT NULL DEFAULT 0,
+			joined_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (conversation_id, user_id),
+			FOREIGN KEY (conversation_id) REFERENCES conversations(id) ON DELETE CASCADE,
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+		)`,
+		"CREATE INDEX idx_conversation_members_user_id ON conversation_members(user_id)",
+
+		// direct_key is "<lower user id>:<higher user id>"
+		`INSERT INTO conversations (type, direct_key, created_at)
+		SELECT 'direct', MIN(sender_id, recipient_id) || ':' || MAX(sender_id, recipient_id), MIN(created_at)
+		FROM messages
+		GROUP BY MIN(sender_id, recipient_id), MAX(sender_id, recipient_id)`,
+		`INSERT INTO conversation_members (conversation_id, user_id, joined_at)
+		SELECT id, CAST(substr(direct_key, 1, instr(direct_key, ':') - 1) AS INTEGER), created_at FROM conversations
+		UNION
+		SELECT id, CAST(substr(direct_key, instr(direct_key, ':') + 1) AS INTEGER), created_at FROM conversations`,
+
+		`CREATE TABLE messages_new (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			conversation_id INTEGER NOT NULL,
+			sender_id INTEGER NOT NULL,
+			recipient_id INTEGER,
+			message TEXT NOT NULL,
+			is_read INTEGER NOT NULL DEFAULT 0,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			edited_at DATETIME,
+			deleted_at DATETIME,
+			reply_to_id INTEGER REFERENCES messages(id) ON DELETE SET NULL,
+			FOREIGN KEY (conversation_id) REFERENCES conversations(id) ON DELETE CASCADE,
+			FOREIGN KEY (sender_id) REFERENCES users(id) ON DELETE CASCADE,
+			FOREIGN KEY (recipient_id) REFERENCES users(id) ON DELETE CASCADE
+		)`,
+		`INSERT INTO messages_new (id, conversation_id, sender_id, recipient_id, message, is_read, created_at, edited_at, deleted_at, reply_to_id)
+		SELECT m.id, c.id, m.sender_id, m.recipient_id, m.message, m.is_read, m.created_at, m.edited_at, m.deleted_at, m.reply_to_id
+		FROM messages m
+		JOIN conversations c ON c.direct_key = MIN(m.sender_id, m.recipient_id) || ':' || MAX(m.sender_id, m.recipient_id)`,
+		"DROP TABLE messages",
+		"ALTER TABLE messages_new RENAME TO messages",
+
+		"CREATE INDEX idx_messages_sender_id ON messages(sender_id)",
+		"CREATE INDEX idx_messages_recipient_id ON messages(recipient_id)",
+		"CREATE INDEX idx_messages_conversation ON messages(sender_id, recipient_id)",
+		"CREATE INDEX idx_messages_conversation_time ON messages(sender_id, recipient_id, created_at, id)",
+		"CREATE INDEX idx_messages_reply_to_id ON messages(reply_to_id)",
+		"CREATE INDEX idx_messages_conversation_id_time ON messages(conversation_id, created_at, id)",
+	}
+	for _, stmt := range statements {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+
+	// Never hand out the id of a deleted message again
+	if _, err := tx.Exec("DELETE FROM sqlite_sequence WHERE name = 'messages'"); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("INSERT INTO sqlite_sequence (name, seq) VALUES ('messages', MAX(?, COALESCE((SELECT MAX(id) FROM messages), 0)))", lastID); err != nil {
+		return err
+	}
+
+	rows, err := tx.Query("PRAGMA foreign_key_check")
+	if err != nil {
+		return err
+	}
+	broken := rows.Next()
+	rows.Close()
+	if broken {
+		return fmt.Errorf("foreign key check failed after rebuilding messages")
+	}
+
+	return tx.Commit()
+}
+
+// createMessageOutboxTable queues messages for delivery to MongoDB. Rows are written
+// in the same transaction as their message and marked done once delivered.
+func createMessageOutboxTable(db *sql.DB) error {
+	query := `
+	CREATE TABLE IF NOT EXISTS message_outbox (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		message_id INTEGER NOT NULL,
+		attempts INTEGER NOT NULL DEFAULT 0,
+		next_attempt_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		last_error TEXT,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		done_at DATETIME,
+		FOREIGN KEY (message_id) REFERENCES messages(id) ON DELETE CASCADE
+	)`
+	if _, err := db.Exec(query); err != nil {
+		return err
+	}
+	_, err := db.Exec("CREATE INDEX IF NOT EXISTS idx_message_outbox_pending ON message_outbox(done_at, next_attempt_at)")
+	return err
+}
+
+// addMessagesUpdatedAt records when each message last changed, so that tools copying
+// messages elsewhere can pick up edits, deletes, read state and attachments. Triggers
+// keep it current; it is NULL for messages unchanged since they were sent. Recursive
+// triggers are off, so messages_touch does not fire itself.
+func addMessagesUpdatedAt(db *sql.DB) error {
+	statements := []string{
+		"ALTER TABLE messages ADD COLUMN updated_at DATETIME",
+		// Only real changes count: marking read messages read again is not one
+		`CREATE TRIGGER IF NOT EXISTS messages_touch AFTER UPDATE ON messages
+		WHEN NEW.updated_at IS OLD.updated_at AND (
+			NEW.message IS NOT OLD.message OR NEW.is_read IS NOT OLD.is_read
+			OR NEW.edited_at IS NOT OLD.edited_at OR NEW.deleted_at IS NOT OLD.deleted_at
+			OR NEW.reply_to_id IS NOT OLD.reply_to_id OR NEW.conversation_id IS NOT OLD.conversation_id)
+		BEGIN
+			UPDATE messages SET updated_at = CURRENT_TIMESTAMP WHERE id = NEW.id;
+		END`,
+		`CREATE TRIGGER IF NOT EXISTS attachments_touch_claim AFTER UPDATE OF message_id ON attachments
+		WHEN NEW.message_id IS NOT NULL
+		BEGIN
+			UPDATE messages SET updated_at = CURRENT_TIMESTAMP WHERE id = NEW.message_id;
+		END`,
+		`CREATE TRIGGER IF NOT EXISTS attachments_touch_delete AFTER DELETE ON attachments
+		WHEN OLD.message_id IS NOT NULL
+		BEGIN
+			UPDATE messages SET updated_at = CURRENT_TIMESTAMP WHERE id = OLD.message_id;
+		END`,
+	}
+
+	for _, stmt := range statements {
+		if _, err := db.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addReactionsTouch makes reactions move their message's updated_at, so a migration
+// resuming from a checkpoint copies them again too.
+func addReactionsTouch(db *sql.DB) error {
+	statements := []string{
+		`CREATE TRIGGER IF NOT EXISTS reactions_touch_insert AFTER INSERT ON message_reactions
+		BEGIN
+			UPDATE messages SET updated_at = CURRENT_TIMESTAMP WHERE id = NEW.message_id;
+		END`,
+		`CREATE TRIGGER IF NOT EXISTS reactions_touch_delete AFTER DELETE ON message_reactions
+		BEGIN
+			UPDATE messages SET updated_at = CURRENT_TIMESTAMP WHERE id = OLD.message_id;
+		END`,
+	}
+
+	for _, stmt := range statements {
+		if _, err := db.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// createPresenceInstancesTable creates the table where each server instance records
+// the status of the users connected to it. users.status combines the rows whose
+// heartbeat is recent, so a user stays online while any instance has them.
+func createPresenceInstancesTable(db *sql.DB) error {
+	query := `
+	CREATE TABLE IF NOT EXISTS presence_instances (
+		instance TEXT NOT NULL,
+		user_id INTEGER NOT NULL,
+		status TEXT NOT NULL CHECK(status IN ('online', 'away')),
+		heartbeat_at DATETIME NOT NULL,
+		PRIMARY KEY (instance, user_id),
+		FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+	)`
+	if _, err := db.Exec(query); err != nil {
+		return err
+	}
+	_, err := db.Exec("CREATE INDEX IF NOT EXISTS idx_presence_instances_user_id ON presence_instances(user_id)")
+	return err
+}
+
+// Example of how to add a new migration in the future:
+/*
+func createNewTable(db *sql.DB) error {
+	query := `
+	CREATE TABLE IF NOT EXISTS new_table (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`
+	_, err := db.Exec(query)
+	return err
+}
+
+// Then add to migrations list:
+// {Version: 4, Name: "create_new_table", Up: createNewTable},
+*/
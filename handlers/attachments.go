@@ -0,0 +1,213 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"DB-Presentation/auth"
+	"DB-Presentation/models"
+	"DB-Presentation/utils"
+
+	dbsqlite "DB-Presentation/database/sqlite"
+)
+
+const (
+	// maxAttachmentBytes caps a single uploaded file.
+	maxAttachmentBytes = 20 << 20
+
+	// maxAttachmentsPerMessage caps attachment_ids on a message.
+	maxAttachmentsPerMessage = 10
+
+	// orphanAttachmentAge is how long an uploaded file may stay unsent before it is deleted.
+	orphanAttachmentAge = 24 * time.Hour
+)
+
+func attachmentKey(id string) string {
+	return "attachments/" + id
+}
+
+// uploadAttachmentHandler stores a multipart "file" for the caller and returns its
+// metadata. The id is then passed in attachment_ids when sending a message. The
+// MIME type is detected from the content, not taken from the client.
+func uploadAttachmentHandler(w http.ResponseWriter, r *http.Request) {
+	userID := auth.UserID(r.Context())
+	if blobs == nil {
+		utils.SendJSON(w, models.Response{Success: false, Message: "Uploads are not available"}, http.StatusServiceUnavailable)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxAttachmentBytes+64<<10)
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			utils.SendJSON(w, models.Response{Success: false, Message: "Attachments must be at most 20 MB"}, http.StatusRequestEntityTooLarge)
+			return
+		}
+		utils.SendJSON(w, models.Response{Success: false, Message: "A file is required"}, http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+	if header.Size > maxAttachmentBytes {
+		utils.SendJSON(w, models.Response{Success: false, Message: "Attachments must be at most 20 MB"}, http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	head := make([]byte, 512)
+	n, _ := io.ReadFull(file, head)
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		utils.SendJSON(w, models.Response{Success: false, Message: "Error reading upload"}, http.StatusInternalServerError)
+		return
+	}
+
+	raw := make([]byte, 16)
+	if _, err := rand.Read(raw); err != nil {
+		utils.SendJSON(w, models.Response{Success: false, Message: "Error storing attachment"}, http.StatusInternalServerError)
+		return
+	}
+
+	a := models.Attachment{
+		ID:         hex.EncodeToString(raw),
+		UploaderID: userID,
+		Filename:   cleanFilename(header.Filename),
+		MimeType:   http.DetectContentType(head[:n]),
+		Size:       header.Size,
+		CreatedAt:  time.Now().UTC(),
+	}
+
+	// Hash while storing so the file is only read once
+	hash := sha256.New()
+	if err := blobs.Put(r.Context(), attachmentKey(a.ID), io.TeeReader(file, hash), a.MimeType); err != nil {
+		log.Printf("Error storing attachment for user %d: %v", userID, err)
+		utils.SendJSON(w, models.Response{Success: false, Message: "Error storing attachment"}, http.StatusInternalServerError)
+		return
+	}
+	a.SHA256 = hex.EncodeToString(hash.Sum(nil))
+
+	if err := dbsqlite.InsertAttachment(dbase, a); err != nil {
+		_ = blobs.Delete(r.Context(), attachmentKey(a.ID))
+		utils.SendJSON(w, models.Response{Success: false, Message: "Error storing attachment"}, http.StatusInternalServerError)
+		return
+	}
+
+	utils.SendJSON(w, models.Response{Success: true, Data: a}, http.StatusCreated)
+}
+
+// downloadAttachmentHandler serves an attachment to the members of the conversation
+// it was sent in, or to its uploader before it is sent. Anyone else gets a 404, so
+// ids cannot be probed.
+func downloadAttachmentHandler(w http.ResponseWriter, r *http.Request) {
+	userID := auth.UserID(r.Context())
+	id := mux.Vars(r)["id"]
+
+	a, conversationID, err := dbsqlite.GetAttachment(dbase, id)
+	if err == nil && userID != a.UploaderID {
+		var member bool
+		if member, err = dbsqlite.IsMember(dbase, conversationID, userID); err == nil && !member {
+			err = sql.ErrNoRows
+		}
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		utils.SendJSON(w, models.Response{Success: false, Message: "Attachment not found"}, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		utils.SendJSON(w, models.Response{Success: false, Message: "Error fetching attachment"}, http.StatusInternalServerError)
+		return
+	}
+	if blobs == nil {
+		utils.SendJSON(w, models.Response{Success: false, Message: "Uploads are not available"}, http.StatusServiceUnavailable)
+		return
+	}
+
+	rc, info, err := blobs.Get(r.Context(), attachmentKey(a.ID))
+	if err != nil {
+		log.Printf("Error reading attachment %s: %v", a.ID, err)
+		utils.SendJSON(w, models.Response{Success: false, Message: "Error fetching attachment"}, http.StatusInternalServerError)
+		return
+	}
+	defer rc.Close()
+
+	disposition := "attachment"
+	if strings.HasPrefix(a.MimeType, "image/") {
+		disposition = "inline"
+	}
+	w.Header().Set("Content-Type", a.MimeType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": a.Filename}))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	// Uploaded HTML or SVG opened directly must not run scripts on our origin
+	w.Header().Set("Content-Security-Policy", "sandbox")
+	// Content never changes, but only authorized clients may keep it
+	w.Header().Set("Cache-Control", "private, max-age=86400")
+	w.Header().Set("ETag", `"`+a.SHA256+`"`)
+
+	if rs, ok := rc.(io.ReadSeeker); ok {
+		http.ServeContent(w, r, "", info.ModTime, rs)
+		return
+	}
+	if r.Header.Get("If-None-Match") == w.Header().Get("ETag") {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Length", strconv.FormatInt(info.Size, 10))
+	io.Copy(w, rc)
+}
+
+// cleanFilename keeps the base name of an uploaded file, for display and downloads.
+func cleanFilename(name string) string {
+	name = filepath.Base(strings.ReplaceAll(name, "\\", "/"))
+	if name == "." || name == "/" || name == "" {
+		return "file"
+	}
+	if len(name) > 255 {
+		name = name[:255]
+	}
+	return name
+}
+
+// pruneAttachments periodically deletes uploads that were never sent.
+func pruneAttachments() {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for {
+		if blobs != nil {
+			ids, err := dbsqlite.OrphanAttachments(dbase, time.Now().Add(-orphanAttachmentAge))
+			if err != nil {
+				log.Println("warning: could not list unsent attachments:", err)
+			}
+			pruned := 0
+			for _, id := range ids {
+				// The row goes first: one sent since it was listed stays, with its file
+				deleted, err := dbsqlite.DeleteAttachment(dbase, id)
+				if err != nil {
+					log.Printf("warning: could not delete attachment %s: %v", id, err)
+				}
+				if !deleted {
+					continue
+				}
+				if err := blobs.Delete(context.Background(), attachmentKey(id)); err != nil {
+					log.Printf("warning: could not delete attachment %s: %v", id, err)
+				}
+				pruned++
+			}
+			if pruned > 0 {
+				log.Printf("Pruned %d unsent attachments", pruned)
+			}
+		}
+		<-ticker.C
+	}
+}
@@ -0,0 +1,261 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+
+	// Decoders for the accepted upload formats
+	_ "image/gif"
+	_ "image/png"
+
+	"github.com/gorilla/mux"
+
+	"DB-Presentation/auth"
+	"DB-Presentation/models"
+	"DB-Presentation/storage"
+	"DB-Presentation/utils"
+
+	dbsqlite "DB-Presentation/database/sqlite"
+)
+
+const (
+	// maxAvatarBytes caps the uploaded file.
+	maxAvatarBytes = 5 << 20
+
+	// maxAvatarPixels caps the decoded image, so a small file cannot expand into
+	// a huge bitmap.
+	maxAvatarPixels = 4096 * 4096
+
+	avatarQuality = 85
+)
+
+// avatarSizes are the square thumbnails generated for every upload. Only these are
+// stored: the original, with any EXIF or other metadata, is never kept.
+var avatarSizes = []int{64, 256}
+
+var avatarTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+}
+
+// blobs stores uploaded files. main sets it with SetBlobStore.
+var blobs storage.BlobStore
+
+// SetBlobStore sets where avatars and other uploads are stored.
+func SetBlobStore(b storage.BlobStore) {
+	blobs = b
+}
+
+func avatarKey(avatarID string, size int) string {
+	return fmt.Sprintf("avatars/%s/%d.jpg", avatarID, size)
+}
+
+// withAvatarURLs fills in the avatar URLs of a profile that has an avatar.
+func withAvatarURLs(p *models.Profile) {
+	if p.AvatarID == "" {
+		return
+	}
+	p.AvatarURL = fmt.Sprintf("/api/avatars/%s/%d.jpg", p.AvatarID, avatarSizes[1])
+	p.AvatarThumb = fmt.Sprintf("/api/avatars/%s/%d.jpg", p.AvatarID, avatarSizes[0])
+}
+
+// avatarFields returns the avatar URLs for list responses such as friends and search.
+func avatarFields(avatarID string) (url, thumb interface{}) {
+	p := models.Profile{AvatarID: avatarID}
+	withAvatarURLs(&p)
+	if p.AvatarURL == "" {
+		return nil, nil
+	}
+	return p.AvatarURL, p.AvatarThumb
+}
+
+// uploadAvatarHandler accepts a multipart "avatar" file (JPEG, PNG or GIF, up to
+// 5 MB) for the caller, stores square thumbnails of it and returns the profile.
+func uploadAvatarHandler(w http.ResponseWriter, r *http.Request) {
+	userID := auth.UserID(r.Context())
+	if toInt(mux.Vars(r)["id"]) != userID {
+		utils.SendJSON(w, models.Response{Success: false, Message: "You can only edit your own profile"}, http.StatusForbidden)
+		return
+	}
+	if blobs == nil {
+		utils.SendJSON(w, models.Response{Success: false, Message: "Uploads are not available"}, http.StatusServiceUnavailable)
+		return
+	}
+
+	// Leave room for the multipart framing around the file
+	r.Body = http.MaxBytesReader(w, r.Body, maxAvatarBytes+64<<10)
+	file, header, err := r.FormFile("avatar")
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			utils.SendJSON(w, models.Response{Success: false, Message: "Avatar must be at most 5 MB"}, http.StatusRequestEntityTooLarge)
+			return
+		}
+		utils.SendJSON(w, models.Response{Success: false, Message: "An avatar file is required"}, http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+	if header.Size > maxAvatarBytes {
+		utils.SendJSON(w, models.Response{Success: false, Message: "Avatar must be at most 5 MB"}, http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	img, err := decodeAvatar(file)
+	if err != nil {
+		sendError(w, err)
+		return
+	}
+
+	avatarID, err := storeAvatar(r.Context(), img)
+	if err != nil {
+		log.Printf("Error storing avatar for user %d: %v", userID, err)
+		utils.SendJSON(w, models.Response{Success: false, Message: "Error storing avatar"}, http.StatusInternalServerError)
+		return
+	}
+
+	replaceAvatar(w, r.Context(), userID, avatarID)
+}
+
+// deleteAvatarHandler removes the caller's avatar.
+func deleteAvatarHandler(w http.ResponseWriter, r *http.Request) {
+	userID := auth.UserID(r.Context())
+	if toInt(mux.Vars(r)["id"]) != userID {
+		utils.SendJSON(w, models.Response{Success: false, Message: "You can only edit your own profile"}, http.StatusForbidden)
+		return
+	}
+
+	replaceAvatar(w, r.Context(), userID, "")
+}
+
+// replaceAvatar points the user at avatarID, drops the previous images and tells
+// friends about the change.
+func replaceAvatar(w http.ResponseWriter, ctx context.Context, userID int, avatarID string) {
+	old, err := dbsqlite.SetAvatarID(dbase, userID, avatarID)
+	if err != nil {
+		deleteAvatar(ctx, avatarID)
+		utils.SendJSON(w, models.Response{Success: false, Message: "Error updating avatar"}, http.StatusInternalServerError)
+		return
+	}
+	deleteAvatar(ctx, old)
+
+	profile, err := notifyProfileChanged(userID)
+	if err != nil {
+		utils.SendJSON(w, models.Response{Success: false, Message: "Error fetching profile"}, http.StatusInternalServerError)
+		return
+	}
+
+	utils.SendJSON(w, models.Response{Success: true, Message: "Avatar updated", Data: profile}, http.StatusOK)
+}
+
+// decodeAvatar checks the file's actual content type and dimensions before decoding it.
+func decodeAvatar(file io.ReadSeeker) (image.Image, error) {
+	head := make([]byte, 512)
+	n, _ := io.ReadFull(file, head)
+	if !avatarTypes[http.DetectContentType(head[:n])] {
+		return nil, newAPIError(http.StatusUnsupportedMediaType, "Avatar must be a JPEG, PNG or GIF image")
+	}
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	cfg, _, err := image.DecodeConfig(file)
+	if err != nil {
+		return nil, newAPIError(http.StatusBadRequest, "Could not read image")
+	}
+	if cfg.Width == 0 || cfg.Height == 0 || cfg.Width*cfg.Height > maxAvatarPixels {
+		return nil, newAPIError(http.StatusBadRequest, "Image dimensions are too large")
+	}
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	img, _, err := image.Decode(file)
+	if err != nil {
+		return nil, newAPIError(http.StatusBadRequest, "Could not read image")
+	}
+	return img, nil
+}
+
+// storeAvatar encodes every thumbnail size under a new random avatar id.
+func storeAvatar(ctx context.Context, img image.Image) (string, error) {
+	raw := make([]byte, 16)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	avatarID := hex.EncodeToString(raw)
+
+	for _, size := range avatarSizes {
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, thumbnail(img, size), &jpeg.Options{Quality: avatarQuality}); err != nil {
+			return "", err
+		}
+		if err := blobs.Put(ctx, avatarKey(avatarID, size), &buf, "image/jpeg"); err != nil {
+			deleteAvatar(ctx, avatarID)
+			return "", err
+		}
+	}
+	return avatarID, nil
+}
+
+// deleteAvatar removes every size of an avatar, best effort.
+func deleteAvatar(ctx context.Context, avatarID string) {
+	if avatarID == "" || blobs == nil {
+		return
+	}
+	for _, size := range avatarSizes {
+		if err := blobs.Delete(ctx, avatarKey(avatarID, size)); err != nil && !errors.Is(err, storage.ErrNotFound) {
+			log.Printf("warning: could not delete avatar %s: %v", avatarKey(avatarID, size), err)
+		}
+	}
+}
+
+// serveAvatarHandler serves an avatar thumbnail. Avatar ids are random and change
+// on every upload, so responses can be cached forever. Like avatar_color, avatars
+// are public, which also lets <img> tags load them without a token.
+func serveAvatarHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	key := avatarKey(vars["avatarId"], toInt(vars["size"]))
+	if blobs == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	rc, info, err := blobs.Get(r.Context(), key)
+	if errors.Is(err, storage.ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		log.Printf("Error reading %s: %v", key, err)
+		http.Error(w, "Error reading avatar", http.StatusInternalServerError)
+		return
+	}
+	defer rc.Close()
+
+	w.Header().Set("Content-Type", info.ContentType)
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	w.Header().Set("ETag", `"`+vars["avatarId"]+"-"+vars["size"]+`"`)
+
+	if rs, ok := rc.(io.ReadSeeker); ok {
+		http.ServeContent(w, r, "", info.ModTime, rs)
+		return
+	}
+	if r.Header.Get("If-None-Match") == w.Header().Get("ETag") {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Length", strconv.FormatInt(info.Size, 10))
+	w.Header().Set("Last-Modified", info.ModTime.UTC().Format(http.TimeFormat))
+	io.Copy(w, rc)
+}
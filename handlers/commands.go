@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+
+	"DB-Presentation/models"
+	"DB-Presentation/ws"
+)
+
+// registerCommands exposes message operations over the websocket. Each command
+// runs the same function as its HTTP endpoint, so validation and side effects match.
+//
+//	{"id": "c1", "type": "send_message", "data": {"recipient_id": 2, "message": "hi"}}
+//	{"id": "c2", "type": "mark_read",    "data": {"friend_id": 2}}
+//	{"id": "c3", "type": "typing_start", "data": {"friend_id": 2}}
+//	{"id": "c4", "type": "typing_stop",  "data": {"friend_id": 2}}
+//	{"id": "c5", "type": "edit_message", "data": {"id": 90, "message": "hi!"}}
+//	{"id": "c6", "type": "delete_message", "data": {"id": 90}}
+//	{"id": "c7", "type": "add_reaction", "data": {"id": 90, "emoji": "👍"}}
+//	{"id": "c8", "type": "remove_reaction", "data": {"id": 90, "emoji": "👍"}}
+func registerCommands() {
+	ws.HandleCommand("send_message", func(ctx context.Context, data json.RawMessage) (interface{}, error) {
+		var req models.SendMessageRequest
+		if err := json.Unmarshal(data, &req); err != nil {
+			return nil, errInvalidRequest
+		}
+		return sendMessage(ctx, req)
+	})
+
+	ws.HandleCommand("mark_read", func(ctx context.Context, data json.RawMessage) (interface{}, error) {
+		var req friendTarget
+		if err := json.Unmarshal(data, &req); err != nil {
+			return nil, errInvalidRequest
+		}
+		if err := markConversationRead(ctx, req.FriendID); err != nil {
+			return nil, err
+		}
+		return req, nil
+	})
+
+	ws.HandleCommand("edit_message", func(ctx context.Context, data json.RawMessage) (interface{}, error) {
+		var req struct {
+			ID      int    `json:"id"`
+			Message string `json:"message"`
+		}
+		if err := json.Unmarshal(data, &req); err != nil {
+			return nil, errInvalidRequest
+		}
+		return editMessage(ctx, req.ID, req.Message)
+	})
+
+	ws.HandleCommand("delete_message", func(ctx context.Context, data json.RawMessage) (interface{}, error) {
+		var req struct {
+			ID int `json:"id"`
+		}
+		if err := json.Unmarshal(data, &req); err != nil {
+			return nil, errInvalidRequest
+		}
+		return deleteMessage(ctx, req.ID)
+	})
+
+	reactionCommand := func(add bool) ws.CommandFunc {
+		return func(ctx context.Context, data json.RawMessage) (interface{}, error) {
+			var req struct {
+				ID    int    `json:"id"`
+				Emoji string `json:"emoji"`
+			}
+			if err := json.Unmarshal(data, &req); err != nil {
+				return nil, errInvalidRequest
+			}
+			return setReaction(ctx, req.ID, req.Emoji, add)
+		}
+	}
+	ws.HandleCommand("add_reaction", reactionCommand(true))
+	ws.HandleCommand("remove_reaction", reactionCommand(false))
+
+	typingCommand := func(active bool) ws.CommandFunc {
+		return func(ctx context.Context, data json.RawMessage) (interface{}, error) {
+			var req friendTarget
+			if err := json.Unmarshal(data, &req); err != nil {
+				return nil, errInvalidRequest
+			}
+			if err := setTyping(ctx, req.FriendID, active); err != nil {
+				return nil, err
+			}
+			return req, nil
+		}
+	}
+	ws.HandleCommand("typing_start", typingCommand(true))
+	ws.HandleCommand("typing_stop", typingCommand(false))
+}
+
+// friendTarget is the payload of commands aimed at a single conversation.
+type friendTarget struct {
+	FriendID int `json:"friend_id"`
+}
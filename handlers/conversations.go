@@ -0,0 +1,411 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"DB-Presentation/auth"
+	"DB-Presentation/models"
+	"DB-Presentation/storage"
+	"DB-Presentation/utils"
+	"DB-Presentation/ws"
+
+	dbsqlite "DB-Presentation/database/sqlite"
+)
+
+// maxGroupNameLength bounds a group's name, in characters.
+const maxGroupNameLength = 100
+
+var errConversationNotFound = newAPIError(http.StatusNotFound, "Conversation not found")
+
+// listConversationsHandler returns the caller's direct and group conversations
+func listConversationsHandler(w http.ResponseWriter, r *http.Request) {
+	conversations, err := dbsqlite.ListConversations(dbase, auth.UserID(r.Context()))
+	if err != nil {
+		utils.SendJSON(w, models.Response{Success: false, Message: "Error fetching conversations"}, http.StatusInternalServerError)
+		return
+	}
+	utils.SendJSON(w, models.Response{Success: true, Data: conversations}, http.StatusOK)
+}
+
+// createConversationHandler creates a group owned by the caller with some of their friends
+func createConversationHandler(w http.ResponseWriter, r *http.Request) {
+	userID := auth.UserID(r.Context())
+
+	var req models.CreateConversationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendError(w, errInvalidRequest)
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len([]rune(req.Name)) > maxGroupNameLength {
+		utils.SendJSON(w, models.Response{Success: false, Message: "A group name of up to 100 characters is required"}, http.StatusBadRequest)
+		return
+	}
+	for _, id := range req.MemberIDs {
+		if id == userID {
+			continue
+		}
+		if friends, err := dbsqlite.AreFriends(dbase, userID, id); err != nil || !friends {
+			utils.SendJSON(w, models.Response{Success: false, Message: "You can only add friends to a group"}, http.StatusBadRequest)
+			return
+		}
+	}
+
+	conv, err := dbsqlite.CreateGroup(dbase, userID, req.Name, req.MemberIDs)
+	if err != nil {
+		utils.SendJSON(w, models.Response{Success: false, Message: "Error creating group"}, http.StatusInternalServerError)
+		return
+	}
+
+	for _, m := range conv.Members {
+		if m.UserID != userID {
+			notifyConversationAdded(conv.ID, m.UserID)
+		}
+	}
+	utils.SendJSON(w, models.Response{Success: true, Data: conv}, http.StatusCreated)
+}
+
+// getConversationHandler returns one of the caller's conversations with its members
+func getConversationHandler(w http.ResponseWriter, r *http.Request) {
+	conv, err := dbsqlite.GetConversation(dbase, toInt(mux.Vars(r)["id"]), auth.UserID(r.Context()))
+	if errors.Is(err, sql.ErrNoRows) {
+		sendError(w, errConversationNotFound)
+		return
+	}
+	if err != nil {
+		utils.SendJSON(w, models.Response{Success: false, Message: "Error fetching conversation"}, http.StatusInternalServerError)
+		return
+	}
+	utils.SendJSON(w, models.Response{Success: true, Data: conv}, http.StatusOK)
+}
+
+// getConversationMessagesHandler returns a page of a conversation's messages, like
+// getMessagesHandler, and marks the conversation as read
+func getConversationMessagesHandler(w http.ResponseWriter, r *http.Request) {
+	id := toInt(mux.Vars(r)["id"])
+	if _, err := memberRole(r.Context(), id); err != nil {
+		sendError(w, err)
+		return
+	}
+	page, err := parsePageRequest(r)
+	if err != nil {
+		sendError(w, err)
+		return
+	}
+
+	msgs, err := messages.ListConversation(r.Context(), id, page)
+	if errors.Is(err, storage.ErrInvalidCursor) {
+		utils.SendJSON(w, models.Response{Success: false, Message: "Invalid cursor"}, http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		utils.SendJSON(w, models.Response{Success: false, Message: "Error fetching messages"}, http.StatusInternalServerError)
+		return
+	}
+
+	if err := markRead(r.Context(), id); err != nil {
+		log.Printf("warning: could not mark conversation %d as read: %v", id, err)
+	}
+	utils.SendJSON(w, models.Response{Success: true, Data: msgs}, http.StatusOK)
+}
+
+// markConversationReadHandler marks every message of a conversation as read by the caller
+func markConversationReadHandler(w http.ResponseWriter, r *http.Request) {
+	if err := markRead(r.Context(), toInt(mux.Vars(r)["id"])); err != nil {
+		sendError(w, err)
+		return
+	}
+	utils.SendJSON(w, models.Response{Success: true, Message: "Conversation marked as read"}, http.StatusOK)
+}
+
+// markRead marks a conversation as read by the caller. Direct conversations keep
+// their read state in the message store.
+func markRead(ctx context.Context, id int) error {
+	userID := auth.UserID(ctx)
+	if _, err := memberRole(ctx, id); err != nil {
+		return err
+	}
+	t, err := dbsqlite.ConversationType(dbase, id)
+	if err != nil {
+		return newAPIError(http.StatusInternalServerError, "Error marking messages as read")
+	}
+	if t == models.ConversationDirect {
+		other, err := otherMember(id, userID)
+		if err != nil {
+			return newAPIError(http.StatusInternalServerError, "Error marking messages as read")
+		}
+		return markConversationRead(ctx, other)
+	}
+	if err := messages.MarkConversationRead(ctx, id, userID); err != nil {
+		return newAPIError(http.StatusInternalServerError, "Error marking messages as read")
+	}
+	return nil
+}
+
+// addMemberHandler lets a group's owner or admins add one of their friends
+func addMemberHandler(w http.ResponseWriter, r *http.Request) {
+	userID := auth.UserID(r.Context())
+	id := toInt(mux.Vars(r)["id"])
+
+	var req models.AddMemberRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.UserID == 0 {
+		sendError(w, errInvalidRequest)
+		return
+	}
+	role, err := groupRole(r.Context(), id)
+	if err != nil {
+		sendError(w, err)
+		return
+	}
+	if role == models.RoleMember {
+		utils.SendJSON(w, models.Response{Success: false, Message: "Only owners and admins can add members"}, http.StatusForbidden)
+		return
+	}
+	if friends, err := dbsqlite.AreFriends(dbase, userID, req.UserID); err != nil || !friends {
+		utils.SendJSON(w, models.Response{Success: false, Message: "You can only add friends to a group"}, http.StatusBadRequest)
+		return
+	}
+	if existing, _ := dbsqlite.MemberRole(dbase, id, req.UserID); existing != "" {
+		utils.SendJSON(w, models.Response{Success: false, Message: "User is already a member"}, http.StatusConflict)
+		return
+	}
+
+	if err := dbsqlite.AddMember(dbase, id, req.UserID); err != nil {
+		utils.SendJSON(w, models.Response{Success: false, Message: "Error adding member"}, http.StatusInternalServerError)
+		return
+	}
+
+	conv := notifyConversationAdded(id, req.UserID)
+	for _, m := range conv.Members {
+		if m.UserID == req.UserID {
+			notifyMembers(id, req.UserID, "conversation_member_added", map[string]interface{}{"conversation_id": id, "member": m})
+		}
+	}
+	utils.SendJSON(w, models.Response{Success: true, Message: "Member added"}, http.StatusCreated)
+}
+
+// removeMemberHandler kicks a member out of a group. Owners may remove anyone,
+// admins only plain members. Removing yourself is the same as leaving.
+func removeMemberHandler(w http.ResponseWriter, r *http.Request) {
+	userID := auth.UserID(r.Context())
+	vars := mux.Vars(r)
+	id, target := toInt(vars["id"]), toInt(vars["userId"])
+	if target == userID {
+		leaveConversationHandler(w, r)
+		return
+	}
+
+	role, err := groupRole(r.Context(), id)
+	if err != nil {
+		sendError(w, err)
+		return
+	}
+	targetRole, err := dbsqlite.MemberRole(dbase, id, target)
+	if err != nil {
+		utils.SendJSON(w, models.Response{Success: false, Message: "Error removing member"}, http.StatusInternalServerError)
+		return
+	}
+	if targetRole == "" {
+		utils.SendJSON(w, models.Response{Success: false, Message: "User is not a member"}, http.StatusNotFound)
+		return
+	}
+	if role != models.RoleOwner && (role != models.RoleAdmin || targetRole != models.RoleMember) {
+		utils.SendJSON(w, models.Response{Success: false, Message: "You cannot remove this member"}, http.StatusForbidden)
+		return
+	}
+
+	if err := dbsqlite.RemoveMember(dbase, id, target); err != nil {
+		utils.SendJSON(w, models.Response{Success: false, Message: "Error removing member"}, http.StatusInternalServerError)
+		return
+	}
+
+	ws.NotifyUser(target, models.WSMessage{Type: "conversation_removed", Data: map[string]interface{}{"conversation_id": id}})
+	notifyMembers(id, 0, "conversation_member_removed", map[string]interface{}{"conversation_id": id, "user_id": target})
+	utils.SendJSON(w, models.Response{Success: true, Message: "Member removed"}, http.StatusOK)
+}
+
+// updateMemberHandler lets a group's owner promote or demote a member. Making
+// someone owner transfers ownership; the previous owner becomes an admin.
+func updateMemberHandler(w http.ResponseWriter, r *http.Request) {
+	userID := auth.UserID(r.Context())
+	vars := mux.Vars(r)
+	id, target := toInt(vars["id"]), toInt(vars["userId"])
+
+	var req models.UpdateMemberRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendError(w, errInvalidRequest)
+		return
+	}
+	if req.Role != models.RoleOwner && req.Role != models.RoleAdmin && req.Role != models.RoleMember {
+		utils.SendJSON(w, models.Response{Success: false, Message: "Role must be owner, admin or member"}, http.StatusBadRequest)
+		return
+	}
+	role, err := groupRole(r.Context(), id)
+	if err != nil {
+		sendError(w, err)
+		return
+	}
+	if role != models.RoleOwner {
+		utils.SendJSON(w, models.Response{Success: false, Message: "Only the owner can change roles"}, http.StatusForbidden)
+		return
+	}
+	if target == userID {
+		utils.SendJSON(w, models.Response{Success: false, Message: "Transfer ownership to another member instead"}, http.StatusBadRequest)
+		return
+	}
+	if targetRole, _ := dbsqlite.MemberRole(dbase, id, target); targetRole == "" {
+		utils.SendJSON(w, models.Response{Success: false, Message: "User is not a member"}, http.StatusNotFound)
+		return
+	}
+
+	if req.Role == models.RoleOwner {
+		err = dbsqlite.TransferOwnership(dbase, id, userID, target)
+	} else {
+		err = dbsqlite.SetMemberRole(dbase, id, target, req.Role)
+	}
+	if err != nil {
+		utils.SendJSON(w, models.Response{Success: false, Message: "Error updating member"}, http.StatusInternalServerError)
+		return
+	}
+
+	notifyMembers(id, 0, "conversation_member_updated", map[string]interface{}{"conversation_id": id, "user_id": target, "role": req.Role})
+	if req.Role == models.RoleOwner {
+		notifyMembers(id, 0, "conversation_member_updated", map[string]interface{}{"conversation_id": id, "user_id": userID, "role": models.RoleAdmin})
+	}
+	utils.SendJSON(w, models.Response{Success: true, Message: "Member updated"}, http.StatusOK)
+}
+
+// leaveConversationHandler removes the caller from a group. If the owner leaves,
+// ownership passes to an admin, or else to the longest-standing member.
+func leaveConversationHandler(w http.ResponseWriter, r *http.Request) {
+	userID := auth.UserID(r.Context())
+	id := toInt(mux.Vars(r)["id"])
+
+	if _, err := groupRole(r.Context(), id); err != nil {
+		sendError(w, err)
+		return
+	}
+	newOwner, err := dbsqlite.LeaveConversation(dbase, id, userID)
+	if err != nil {
+		utils.SendJSON(w, models.Response{Success: false, Message: "Error leaving conversation"}, http.StatusInternalServerError)
+		return
+	}
+
+	ws.NotifyUser(userID, models.WSMessage{Type: "conversation_removed", Data: map[string]interface{}{"conversation_id": id}})
+	notifyMembers(id, 0, "conversation_member_removed", map[string]interface{}{"conversation_id": id, "user_id": userID})
+	if newOwner != 0 {
+		notifyMembers(id, 0, "conversation_member_updated", map[string]interface{}{"conversation_id": id, "user_id": newOwner, "role": models.RoleOwner})
+	}
+	utils.SendJSON(w, models.Response{Success: true, Message: "Left conversation"}, http.StatusOK)
+}
+
+// sendConversationMessage handles sendMessage for a conversation_id. Direct
+// conversations are sent like recipient_id messages; group messages are pushed to
+// every other member.
+func sendConversationMessage(ctx context.Context, req models.SendMessageRequest) (models.Message, error) {
+	senderID := auth.UserID(ctx)
+	if _, err := memberRole(ctx, req.ConversationID); err != nil {
+		return models.Message{}, err
+	}
+	t, err := dbsqlite.ConversationType(dbase, req.ConversationID)
+	if err != nil {
+		return models.Message{}, newAPIError(http.StatusInternalServerError, "Error sending message")
+	}
+	if t == models.ConversationDirect {
+		other, err := otherMember(req.ConversationID, senderID)
+		if err != nil {
+			return models.Message{}, newAPIError(http.StatusInternalServerError, "Error sending message")
+		}
+		req.RecipientID, req.ConversationID = other, 0
+		return sendMessage(ctx, req)
+	}
+
+	msg, err := messages.Insert(ctx, senderID, req)
+	if err != nil {
+		return models.Message{}, insertMessageError(err)
+	}
+	msg.CreatedAt = msg.CreatedAt.UTC()
+
+	ws.NotifyUsers(audience(msg, senderID), models.WSMessage{Type: "message", Data: msg})
+	return msg, nil
+}
+
+// memberRole returns the caller's role in a conversation; non-members get a 404.
+func memberRole(ctx context.Context, id int) (string, error) {
+	role, err := dbsqlite.MemberRole(dbase, id, auth.UserID(ctx))
+	if err != nil {
+		return "", newAPIError(http.StatusInternalServerError, "Error fetching conversation")
+	}
+	if role == "" {
+		return "", errConversationNotFound
+	}
+	return role, nil
+}
+
+// groupRole is memberRole for operations that only make sense on groups.
+func groupRole(ctx context.Context, id int) (string, error) {
+	role, err := memberRole(ctx, id)
+	if err != nil {
+		return "", err
+	}
+	if t, err := dbsqlite.ConversationType(dbase, id); err != nil || t != models.ConversationGroup {
+		return "", newAPIError(http.StatusBadRequest, "Direct conversations have fixed members")
+	}
+	return role, nil
+}
+
+// otherMember returns the member of a direct conversation who is not userID.
+// Both are the same user in a conversation with oneself.
+func otherMember(id, userID int) (int, error) {
+	members, err := dbsqlite.MemberIDs(dbase, id)
+	if err != nil {
+		return 0, err
+	}
+	for _, m := range members {
+		if m != userID {
+			return m, nil
+		}
+	}
+	return userID, nil
+}
+
+// notifyConversationAdded tells a user they were added to a conversation, sending
+// it as they will see it. It returns that conversation.
+func notifyConversationAdded(id, userID int) models.Conversation {
+	conv, err := dbsqlite.GetConversation(dbase, id, userID)
+	if err != nil {
+		log.Printf("warning: could not load conversation %d for user %d: %v", id, userID, err)
+		return conv
+	}
+	ws.NotifyUser(userID, models.WSMessage{Type: "conversation_added", Data: conv})
+	return conv
+}
+
+// notifyMembers sends an event to every member of a conversation except except.
+func notifyMembers(id, except int, eventType string, data interface{}) {
+	members, err := dbsqlite.MemberIDs(dbase, id)
+	if err != nil {
+		log.Printf("warning: could not load members of conversation %d: %v", id, err)
+		return
+	}
+	ws.NotifyUsers(without(members, except), models.WSMessage{Type: eventType, Data: data})
+}
+
+// without filters id out of ids, in place.
+func without(ids []int, id int) []int {
+	out := ids[:0]
+	for _, v := range ids {
+		if v != id {
+			out = append(out, v)
+		}
+	}
+	return out
+}
@@ -0,0 +1,188 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"DB-Presentation/auth"
+	"DB-Presentation/models"
+	"DB-Presentation/storage"
+	"DB-Presentation/utils"
+	"DB-Presentation/ws"
+
+	dbsqlite "DB-Presentation/database/sqlite"
+)
+
+// DefaultEditWindow is how long after sending a message its sender may edit or
+// delete it. Override with MESSAGE_EDIT_WINDOW.
+const DefaultEditWindow = 15 * time.Minute
+
+var editWindow = DefaultEditWindow
+
+func initMessageEdits() {
+	if v := os.Getenv("MESSAGE_EDIT_WINDOW"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			editWindow = d
+		} else {
+			log.Printf("warning: invalid MESSAGE_EDIT_WINDOW %q, using %s", v, DefaultEditWindow)
+		}
+	}
+}
+
+// editMessageHandler replaces the text of one of the caller's messages
+func editMessageHandler(w http.ResponseWriter, r *http.Request) {
+	var req models.EditMessageRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendError(w, errInvalidRequest)
+		return
+	}
+
+	msg, err := editMessage(r.Context(), toInt(mux.Vars(r)["id"]), req.Message)
+	if err != nil {
+		sendError(w, err)
+		return
+	}
+
+	utils.SendJSON(w, models.Response{Success: true, Data: msg}, http.StatusOK)
+}
+
+// deleteMessageHandler replaces one of the caller's messages with a tombstone
+func deleteMessageHandler(w http.ResponseWriter, r *http.Request) {
+	msg, err := deleteMessage(r.Context(), toInt(mux.Vars(r)["id"]))
+	if err != nil {
+		sendError(w, err)
+		return
+	}
+
+	utils.SendJSON(w, models.Response{Success: true, Data: msg}, http.StatusOK)
+}
+
+// messageEditsHandler lists the previous versions of a message the caller sent or received
+func messageEditsHandler(w http.ResponseWriter, r *http.Request) {
+	id := toInt(mux.Vars(r)["id"])
+	if _, err := participantMessage(r.Context(), id); err != nil {
+		sendError(w, err)
+		return
+	}
+
+	edits, err := messages.Edits(r.Context(), id)
+	if err != nil {
+		utils.SendJSON(w, models.Response{Success: false, Message: "Error fetching edits"}, http.StatusInternalServerError)
+		return
+	}
+	utils.SendJSON(w, models.Response{Success: true, Data: edits}, http.StatusOK)
+}
+
+// editableMessage loads a message the caller may still change: their own, not
+// deleted, and sent within the edit window.
+func editableMessage(ctx context.Context, id int) (models.Message, error) {
+	msg, err := messages.Get(ctx, id)
+	if errors.Is(err, storage.ErrMessageNotFound) {
+		return msg, newAPIError(http.StatusNotFound, "Message not found")
+	}
+	if err != nil {
+		return msg, newAPIError(http.StatusInternalServerError, "Error fetching message")
+	}
+
+	userID := auth.UserID(ctx)
+	if msg.SenderID != userID {
+		if member, _ := dbsqlite.IsMember(dbase, msg.ConversationID, userID); !member {
+			return msg, newAPIError(http.StatusNotFound, "Message not found")
+		}
+		return msg, newAPIError(http.StatusForbidden, "Only the sender can change a message")
+	}
+	if msg.DeletedAt != nil {
+		return msg, newAPIError(http.StatusGone, "Message was deleted")
+	}
+	if time.Since(msg.CreatedAt) > editWindow {
+		return msg, newAPIError(http.StatusForbidden, fmt.Sprintf("Messages can only be changed within %s of sending", editWindow))
+	}
+	return msg, nil
+}
+
+// editMessage changes the text of the caller's message, keeping the old text in its
+// history, and pushes message_edited to the conversation's members.
+// Shared by PATCH /api/messages/{id} and the websocket "edit_message" command.
+func editMessage(ctx context.Context, id int, text string) (models.Message, error) {
+	msg, err := editableMessage(ctx, id)
+	if err != nil {
+		return models.Message{}, err
+	}
+	if text == "" && len(msg.Attachments) == 0 {
+		return models.Message{}, newAPIError(http.StatusBadRequest, "Message cannot be empty")
+	}
+	if text == msg.Message {
+		return msg, nil
+	}
+
+	now := time.Now().UTC()
+	msg, err = messages.Edit(ctx, id, text, now)
+	if errors.Is(err, storage.ErrMessageDeleted) {
+		// Deleted since editableMessage looked
+		return models.Message{}, newAPIError(http.StatusGone, "Message was deleted")
+	}
+	if err != nil {
+		return models.Message{}, newAPIError(http.StatusInternalServerError, "Error editing message")
+	}
+
+	notifyParticipants(msg, "message_edited")
+	return msg, nil
+}
+
+// deleteMessage replaces the caller's message with a tombstone, removes its
+// attachments and history, and pushes message_deleted to the conversation's members.
+// Shared by DELETE /api/messages/{id} and the websocket "delete_message" command.
+func deleteMessage(ctx context.Context, id int) (models.Message, error) {
+	if _, err := editableMessage(ctx, id); err != nil {
+		return models.Message{}, err
+	}
+
+	now := time.Now().UTC()
+	attachmentIDs, err := messages.Delete(ctx, id, now)
+	if err != nil {
+		return models.Message{}, newAPIError(http.StatusInternalServerError, "Error deleting message")
+	}
+	for _, aid := range attachmentIDs {
+		if blobs == nil {
+			break
+		}
+		if err := blobs.Delete(context.Background(), attachmentKey(aid)); err != nil {
+			log.Printf("warning: could not delete attachment %s: %v", aid, err)
+		}
+	}
+
+	msg, err := messages.Get(ctx, id)
+	if err != nil {
+		return models.Message{}, newAPIError(http.StatusInternalServerError, "Error deleting message")
+	}
+	notifyParticipants(msg, "message_deleted")
+	return msg, nil
+}
+
+// notifyParticipants sends an event about msg to every member of its conversation,
+// including the sender's other connections.
+func notifyParticipants(msg models.Message, eventType string) {
+	ws.NotifyUsers(audience(msg, 0), models.WSMessage{Type: eventType, Data: msg})
+}
+
+// audience returns the members of msg's conversation other than except. If the
+// members cannot be loaded it falls back to the message's sender and recipient.
+func audience(msg models.Message, except int) []int {
+	members, err := dbsqlite.MemberIDs(dbase, msg.ConversationID)
+	if err != nil {
+		log.Printf("warning: could not load members of conversation %d: %v", msg.ConversationID, err)
+		members = []int{msg.SenderID}
+		if msg.RecipientID != 0 && msg.RecipientID != msg.SenderID {
+			members = append(members, msg.RecipientID)
+		}
+	}
+	return without(members, except)
+}
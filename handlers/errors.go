@@ -0,0 +1,34 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"DB-Presentation/models"
+	"DB-Presentation/utils"
+)
+
+// apiError is returned by logic shared between HTTP handlers and websocket commands.
+// The message is what clients see on either transport; status is used over HTTP.
+type apiError struct {
+	status  int
+	message string
+}
+
+func (e *apiError) Error() string { return e.message }
+
+func newAPIError(status int, message string) error {
+	return &apiError{status: status, message: message}
+}
+
+var errInvalidRequest = newAPIError(http.StatusBadRequest, "Invalid request")
+
+// sendError writes err as a failed models.Response, using the apiError status if present.
+func sendError(w http.ResponseWriter, err error) {
+	var ae *apiError
+	if errors.As(err, &ae) {
+		utils.SendJSON(w, models.Response{Success: false, Message: ae.message}, ae.status)
+		return
+	}
+	utils.SendJSON(w, models.Response{Success: false, Message: "Internal server error"}, http.StatusInternalServerError)
+}
@@ -4,49 +4,112 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
 	"net/http"
+	"strconv"
 	"time"
 
 	"golang.org/x/crypto/bcrypt"
 
 	"github.com/gorilla/mux"
 
-	mongodriver "go.mongodb.org/mongo-driver/mongo"
-
+	"DB-Presentation/auth"
 	"DB-Presentation/models"
+	"DB-Presentation/storage"
 	"DB-Presentation/utils"
 	"DB-Presentation/ws"
 
-	dbmongo "DB-Presentation/database/mongo"
 	dbsqlite "DB-Presentation/database/sqlite"
 )
 
 var dbase *sql.DB
-var mClient *mongodriver.Client
+
+// messages stores messages. main sets it with SetMessageStore.
+var messages storage.MessageStore
+
+// SetMessageStore sets the backend messages are kept in.
+func SetMessageStore(s storage.MessageStore) {
+	messages = s
+}
+
+// Message history page sizes for GET /api/messages/{friendId}.
+const (
+	defaultPageSize = 50
+	maxPageSize     = 100
+)
 
 // RegisterRoutes registers all HTTP routes with the provided router and DB handle.
-func RegisterRoutes(router *mux.Router, db *sql.DB, mc *mongodriver.Client) {
+// Everything except register/login requires a session token; the caller's identity is
+// always taken from the session, never from query parameters or request bodies.
+// Messages go through the store set with SetMessageStore, SQLite's by default.
+func RegisterRoutes(router *mux.Router, db *sql.DB) {
 	dbase = db
-	mClient = mc
+	if messages == nil {
+		messages = dbsqlite.NewMessageStore(db, false)
+	}
+
+	initPresence()
+	initMessageEdits()
+	registerCommands()
+	go pruneAttachments()
 
 	router.HandleFunc("/api/register", registerHandler).Methods("POST")
 	router.HandleFunc("/api/login", loginHandler).Methods("POST")
-
-	router.HandleFunc("/api/friends", getFriendsHandler).Methods("GET")
-	router.HandleFunc("/api/friends/search", searchUsersHandler).Methods("GET")
-	router.HandleFunc("/api/friends/request", sendFriendRequestHandler).Methods("POST")
-	router.HandleFunc("/api/friends/requests", getFriendRequestsHandler).Methods("GET")
-	router.HandleFunc("/api/friends/accept/{id}", acceptFriendRequestHandler).Methods("POST")
-	router.HandleFunc("/api/friends/reject/{id}", rejectFriendRequestHandler).Methods("POST")
-	router.HandleFunc("/api/friends/remove/{id}", removeFriendHandler).Methods("DELETE")
-
-	router.HandleFunc("/api/messages/{friendId}", getMessagesHandler).Methods("GET")
-	router.HandleFunc("/api/messages", sendMessageHandler).Methods("POST")
-	router.HandleFunc("/api/messages/unread", getUnreadCountHandler).Methods("GET")
+	// The event stream authenticates itself: EventSource may only pass the token as ?token=
+	router.HandleFunc("/api/events", ws.HandleEvents).Methods("GET")
+	// Avatars are public so <img> tags can load them
+	router.HandleFunc("/api/avatars/{avatarId:[0-9a-f]{32}}/{size:64|256}.jpg", serveAvatarHandler).Methods("GET", "HEAD")
+
+	api := router.PathPrefix("/api").Subrouter()
+	api.Use(auth.Middleware)
+
+	api.HandleFunc("/logout", logoutHandler).Methods("POST")
+
+	api.HandleFunc("/friends", getFriendsHandler).Methods("GET")
+	api.HandleFunc("/friends/search", searchUsersHandler).Methods("GET")
+	api.HandleFunc("/friends/request", sendFriendRequestHandler).Methods("POST")
+	api.HandleFunc("/friends/requests", getFriendRequestsHandler).Methods("GET")
+	api.HandleFunc("/friends/accept/{id}", acceptFriendRequestHandler).Methods("POST")
+	api.HandleFunc("/friends/reject/{id}", rejectFriendRequestHandler).Methods("POST")
+	api.HandleFunc("/friends/remove/{id}", removeFriendHandler).Methods("DELETE")
+
+	api.HandleFunc("/messages/unread", getUnreadCountHandler).Methods("GET")
+	api.HandleFunc("/messages/search", searchMessagesHandler).Methods("GET")
+	api.HandleFunc("/messages/{friendId}", getMessagesHandler).Methods("GET")
+	api.HandleFunc("/messages/{friendId}/read", markReadHandler).Methods("POST")
+	api.HandleFunc("/messages/{friendId}/typing", typingHandler).Methods("POST")
+	api.HandleFunc("/messages", sendMessageHandler).Methods("POST")
+	api.HandleFunc("/messages/{id:[0-9]+}", editMessageHandler).Methods("PATCH")
+	api.HandleFunc("/messages/{id:[0-9]+}", deleteMessageHandler).Methods("DELETE")
+	api.HandleFunc("/messages/{id:[0-9]+}/edits", messageEditsHandler).Methods("GET")
+	api.HandleFunc("/messages/{id:[0-9]+}/replies", repliesHandler).Methods("GET")
+	api.HandleFunc("/messages/{id:[0-9]+}/reactions", listReactionsHandler).Methods("GET")
+	api.HandleFunc("/messages/{id:[0-9]+}/reactions", addReactionHandler).Methods("POST")
+	api.HandleFunc("/messages/{id:[0-9]+}/reactions/{emoji}", removeReactionHandler).Methods("DELETE")
+
+	api.HandleFunc("/conversations", listConversationsHandler).Methods("GET")
+	api.HandleFunc("/conversations", createConversationHandler).Methods("POST")
+	api.HandleFunc("/conversations/{id:[0-9]+}", getConversationHandler).Methods("GET")
+	api.HandleFunc("/conversations/{id:[0-9]+}/messages", getConversationMessagesHandler).Methods("GET")
+	api.HandleFunc("/conversations/{id:[0-9]+}/read", markConversationReadHandler).Methods("POST")
+	api.HandleFunc("/conversations/{id:[0-9]+}/leave", leaveConversationHandler).Methods("POST")
+	api.HandleFunc("/conversations/{id:[0-9]+}/members", addMemberHandler).Methods("POST")
+	api.HandleFunc("/conversations/{id:[0-9]+}/members/{userId:[0-9]+}", updateMemberHandler).Methods("PATCH")
+	api.HandleFunc("/conversations/{id:[0-9]+}/members/{userId:[0-9]+}", removeMemberHandler).Methods("DELETE")
+
+	api.HandleFunc("/attachments", uploadAttachmentHandler).Methods("POST")
+	api.HandleFunc("/attachments/{id}", downloadAttachmentHandler).Methods("GET", "HEAD")
+
+	api.HandleFunc("/presence/heartbeat", heartbeatHandler).Methods("POST")
 
 	// Account settings
-	router.HandleFunc("/api/user/update", updateUserHandler).Methods("POST")
+	api.HandleFunc("/user/update", updateUserHandler).Methods("POST")
+	api.HandleFunc("/users/{id}/profile", getProfileHandler).Methods("GET")
+	api.HandleFunc("/users/{id}/profile", patchProfileHandler).Methods("PATCH")
+	api.HandleFunc("/users/{id}/avatar", uploadAvatarHandler).Methods("POST")
+	api.HandleFunc("/users/{id}/avatar", deleteAvatarHandler).Methods("DELETE")
 }
 
 // registerHandler registers a new user
@@ -103,13 +166,36 @@ func loginHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	utils.SendJSON(w, models.Response{Success: true, Message: "Login successful", Data: map[string]interface{}{"user_id": id, "username": username}}, http.StatusOK)
+	token, session, err := auth.NewSession(id)
+	if err != nil {
+		utils.SendJSON(w, models.Response{Success: false, Message: "Error creating session"}, http.StatusInternalServerError)
+		return
+	}
+
+	utils.SendJSON(w, models.Response{Success: true, Message: "Login successful", Data: map[string]interface{}{
+		"user_id":    id,
+		"username":   username,
+		"token":      token,
+		"expires_at": session.ExpiresAt,
+	}}, http.StatusOK)
+}
+
+// logoutHandler revokes the session used to make the request
+func logoutHandler(w http.ResponseWriter, r *http.Request) {
+	session, _ := auth.SessionFromContext(r.Context())
+	if err := auth.Revoke(session.ID); err != nil {
+		utils.SendJSON(w, models.Response{Success: false, Message: "Error logging out"}, http.StatusInternalServerError)
+		return
+	}
+	ws.CloseSession(session.ID)
+
+	utils.SendJSON(w, models.Response{Success: true, Message: "Logged out"}, http.StatusOK)
 }
 
-// searchUsersHandler searches users by query param 'q' and excludes user_id
+// searchUsersHandler searches users by query param 'q' and excludes the caller
 func searchUsersHandler(w http.ResponseWriter, r *http.Request) {
 	query := r.URL.Query().Get("q")
-	userID := r.URL.Query().Get("user_id")
+	userID := auth.UserID(r.Context())
 
 	if query == "" {
 		utils.SendJSON(w, models.Response{Success: false, Message: "Search query is required"}, http.StatusBadRequest)
@@ -117,7 +203,7 @@ func searchUsersHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	rows, err := dbase.Query(`
-		SELECT id, username 
+		SELECT id, username, COALESCE(avatar_color, ''), COALESCE(avatar_id, '')
 		FROM users 
 		WHERE username LIKE ? AND id != ?
 		LIMIT 10
@@ -131,11 +217,15 @@ func searchUsersHandler(w http.ResponseWriter, r *http.Request) {
 	var users []map[string]interface{}
 	for rows.Next() {
 		var id int
-		var username string
-		if err := rows.Scan(&id, &username); err != nil {
+		var username, avatarColor, avatarID string
+		if err := rows.Scan(&id, &username, &avatarColor, &avatarID); err != nil {
 			continue
 		}
-		users = append(users, map[string]interface{}{"id": id, "username": username})
+		avatarURL, avatarThumb := avatarFields(avatarID)
+		users = append(users, map[string]interface{}{
+			"id": id, "username": username,
+			"avatar_color": avatarColor, "avatar_url": avatarURL, "avatar_thumb_url": avatarThumb,
+		})
 	}
 
 	utils.SendJSON(w, models.Response{Success: true, Data: users}, http.StatusOK)
@@ -143,14 +233,12 @@ func searchUsersHandler(w http.ResponseWriter, r *http.Request) {
 
 // sendFriendRequestHandler creates a pending friendship
 func sendFriendRequestHandler(w http.ResponseWriter, r *http.Request) {
-	var req struct {
-		UserID   int    `json:"user_id"`
-		Username string `json:"username"`
-	}
+	var req models.FriendRequestInput
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		utils.SendJSON(w, models.Response{Success: false, Message: "Invalid request"}, http.StatusBadRequest)
 		return
 	}
+	userID := auth.UserID(r.Context())
 
 	var friendID int
 	err := dbase.QueryRow("SELECT id FROM users WHERE username = ?", req.Username).Scan(&friendID)
@@ -159,7 +247,7 @@ func sendFriendRequestHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if friendID == req.UserID {
+	if friendID == userID {
 		utils.SendJSON(w, models.Response{Success: false, Message: "Cannot add yourself as friend"}, http.StatusBadRequest)
 		return
 	}
@@ -168,27 +256,27 @@ func sendFriendRequestHandler(w http.ResponseWriter, r *http.Request) {
 	dbase.QueryRow(`
 		SELECT COUNT(*) FROM friendships 
 		WHERE (user_id = ? AND friend_id = ?) OR (user_id = ? AND friend_id = ?)
-	`, req.UserID, friendID, friendID, req.UserID).Scan(&exists)
+	`, userID, friendID, friendID, userID).Scan(&exists)
 
 	if exists > 0 {
 		utils.SendJSON(w, models.Response{Success: false, Message: "Friend request already exists"}, http.StatusConflict)
 		return
 	}
 
-	_, err = dbase.Exec("INSERT INTO friendships (user_id, friend_id, status) VALUES (?, ?, 'pending')", req.UserID, friendID)
+	_, err = dbase.Exec("INSERT INTO friendships (user_id, friend_id, status) VALUES (?, ?, 'pending')", userID, friendID)
 	if err != nil {
 		utils.SendJSON(w, models.Response{Success: false, Message: "Error sending friend request"}, http.StatusInternalServerError)
 		return
 	}
 
-	ws.NotifyUser(friendID, models.WSMessage{Type: "friend_request", Data: map[string]interface{}{"user_id": req.UserID, "username": req.Username}})
+	ws.NotifyUser(friendID, models.WSMessage{Type: "friend_request", Data: map[string]interface{}{"user_id": userID, "username": req.Username}})
 
 	utils.SendJSON(w, models.Response{Success: true, Message: "Friend request sent"}, http.StatusOK)
 }
 
-// getFriendRequestsHandler returns pending requests for a user
+// getFriendRequestsHandler returns pending requests addressed to the caller
 func getFriendRequestsHandler(w http.ResponseWriter, r *http.Request) {
-	userID := r.URL.Query().Get("user_id")
+	userID := auth.UserID(r.Context())
 
 	rows, err := dbase.Query(`
 		SELECT f.id, f.user_id, u.username, f.created_at
@@ -217,13 +305,14 @@ func getFriendRequestsHandler(w http.ResponseWriter, r *http.Request) {
 	utils.SendJSON(w, models.Response{Success: true, Data: requests}, http.StatusOK)
 }
 
-// acceptFriendRequestHandler accepts a pending friendship
+// acceptFriendRequestHandler accepts a pending friendship addressed to the caller
 func acceptFriendRequestHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	requestID := vars["id"]
+	callerID := auth.UserID(r.Context())
 
 	var userID, friendID int
-	err := dbase.QueryRow("SELECT user_id, friend_id FROM friendships WHERE id = ?", requestID).Scan(&userID, &friendID)
+	err := dbase.QueryRow("SELECT user_id, friend_id FROM friendships WHERE id = ? AND friend_id = ? AND status = 'pending'", requestID, callerID).Scan(&userID, &friendID)
 	if err != nil {
 		utils.SendJSON(w, models.Response{Success: false, Message: "Friend request not found"}, http.StatusNotFound)
 		return
@@ -239,34 +328,34 @@ func acceptFriendRequestHandler(w http.ResponseWriter, r *http.Request) {
 	utils.SendJSON(w, models.Response{Success: true, Message: "Friend request accepted"}, http.StatusOK)
 }
 
-// rejectFriendRequestHandler rejects a pending friendship
+// rejectFriendRequestHandler rejects a pending friendship addressed to the caller
 func rejectFriendRequestHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	requestID := vars["id"]
+	callerID := auth.UserID(r.Context())
 
-	_, err := dbase.Exec("UPDATE friendships SET status = 'rejected' WHERE id = ?", requestID)
+	res, err := dbase.Exec("UPDATE friendships SET status = 'rejected' WHERE id = ? AND friend_id = ? AND status = 'pending'", requestID, callerID)
 	if err != nil {
 		utils.SendJSON(w, models.Response{Success: false, Message: "Error rejecting friend request"}, http.StatusInternalServerError)
 		return
 	}
+	if affected, _ := res.RowsAffected(); affected == 0 {
+		utils.SendJSON(w, models.Response{Success: false, Message: "Friend request not found"}, http.StatusNotFound)
+		return
+	}
 
 	utils.SendJSON(w, models.Response{Success: true, Message: "Friend request rejected"}, http.StatusOK)
 }
 
 // removeFriendHandler deletes an accepted friendship between the authenticated user and friend id
-// Expects: DELETE /api/friends/remove/{id}?user_id=123
+// Expects: DELETE /api/friends/remove/{id}
 func removeFriendHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	friendID := vars["id"]
-	userID := r.URL.Query().Get("user_id")
-
-	if userID == "" {
-		utils.SendJSON(w, models.Response{Success: false, Message: "user_id required"}, http.StatusBadRequest)
-		return
-	}
+	userID := auth.UserID(r.Context())
 
 	// Delete the friendship row in either direction
-	res, err := dbase.Exec(`DELETE FROM friendships WHERE (user_id = ? AND friend_id = ?) OR (user_id = ? AND friend_id = ?)`, toInt(userID), toInt(friendID), toInt(friendID), toInt(userID))
+	res, err := dbase.Exec(`DELETE FROM friendships WHERE (user_id = ? AND friend_id = ?) OR (user_id = ? AND friend_id = ?)`, userID, toInt(friendID), toInt(friendID), userID)
 	if err != nil {
 		utils.SendJSON(w, models.Response{Success: false, Message: "Error removing friend"}, http.StatusInternalServerError)
 		return
@@ -278,26 +367,31 @@ func removeFriendHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Notify other user to refresh its friend list
-	ws.NotifyUser(toInt(friendID), models.WSMessage{Type: "friend_removed", Data: map[string]interface{}{"user_id": toInt(userID)}})
+	ws.NotifyUser(toInt(friendID), models.WSMessage{Type: "friend_removed", Data: map[string]interface{}{"user_id": userID}})
 
 	utils.SendJSON(w, models.Response{Success: true, Message: "Unfriended successfully"}, http.StatusOK)
 }
 
 // getFriendsHandler returns accepted friends with unread counts
 func getFriendsHandler(w http.ResponseWriter, r *http.Request) {
-	userID := r.URL.Query().Get("user_id")
+	userID := auth.UserID(r.Context())
+
+	unread, err := messages.UnreadCounts(r.Context(), userID)
+	if err != nil {
+		utils.SendJSON(w, models.Response{Success: false, Message: "Error fetching friends"}, http.StatusInternalServerError)
+		return
+	}
 
 	rows, err := dbase.Query(`
-		SELECT DISTINCT u.id, u.username,
-			(SELECT COUNT(*) FROM messages 
-			 WHERE sender_id = u.id AND recipient_id = ? AND is_read = 0) as unread_count
+		SELECT DISTINCT u.id, u.username, COALESCE(u.status, 'offline'), u.last_seen_at,
+			COALESCE(u.avatar_color, ''), COALESCE(u.avatar_id, '')
 		FROM users u
 		INNER JOIN friendships f ON 
 			(f.user_id = ? AND f.friend_id = u.id) OR 
 			(f.friend_id = ? AND f.user_id = u.id)
 		WHERE f.status = 'accepted' AND u.id != ?
 		ORDER BY u.username
-	`, userID, userID, userID, userID)
+	`, userID, userID, userID)
 	if err != nil {
 		utils.SendJSON(w, models.Response{Success: false, Message: "Error fetching friends"}, http.StatusInternalServerError)
 		return
@@ -307,115 +401,183 @@ func getFriendsHandler(w http.ResponseWriter, r *http.Request) {
 	var friends []map[string]interface{}
 	for rows.Next() {
 		var id int
-		var username string
-		var unreadCount int
-		if err := rows.Scan(&id, &username, &unreadCount); err != nil {
+		var username, status, avatarColor, avatarID string
+		var lastSeen sql.NullTime
+		if err := rows.Scan(&id, &username, &status, &lastSeen, &avatarColor, &avatarID); err != nil {
 			continue
 		}
-		friends = append(friends, map[string]interface{}{"id": id, "username": username, "unread_count": unreadCount})
+		avatarURL, avatarThumb := avatarFields(avatarID)
+		friend := map[string]interface{}{
+			"id": id, "username": username, "status": status, "last_seen_at": nil, "unread_count": unread[id],
+			"avatar_color": avatarColor, "avatar_url": avatarURL, "avatar_thumb_url": avatarThumb,
+		}
+		if lastSeen.Valid {
+			friend["last_seen_at"] = lastSeen.Time.UTC()
+		}
+		friends = append(friends, friend)
 	}
 
 	utils.SendJSON(w, models.Response{Success: true, Data: friends}, http.StatusOK)
 }
 
-// getMessagesHandler fetches messages between two users and marks as read
+// getMessagesHandler fetches a page of messages between two users and marks them as read.
+// ?before= and ?after= take a next_cursor from an earlier page; ?limit= sets the page size.
 func getMessagesHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	friendID := vars["friendId"]
-	userID := r.URL.Query().Get("user_id")
-	// Primary: use Mongo adapter if client available
-	if mClient != nil {
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
-
-		msgs, err := dbmongo.GetMessages(ctx, mClient, toInt(userID), toInt(friendID))
-		if err == nil {
-			_ = dbmongo.MarkMessagesRead(ctx, mClient, toInt(friendID), toInt(userID))
-			utils.SendJSON(w, models.Response{Success: true, Data: msgs}, http.StatusOK)
-			return
-		}
+	userID := auth.UserID(r.Context())
+
+	page, err := parsePageRequest(r)
+	if err != nil {
+		sendError(w, err)
+		return
 	}
 
-	// Fallback to SQLite
-	msgs, err := dbsqlite.GetMessagesSQLite(dbase, toInt(userID), toInt(friendID))
+	msgs, err := messages.List(r.Context(), userID, toInt(friendID), page)
+	if errors.Is(err, storage.ErrInvalidCursor) {
+		utils.SendJSON(w, models.Response{Success: false, Message: "Invalid cursor"}, http.StatusBadRequest)
+		return
+	}
 	if err != nil {
 		utils.SendJSON(w, models.Response{Success: false, Message: "Error fetching messages"}, http.StatusInternalServerError)
 		return
 	}
 
-	_ = dbsqlite.MarkMessagesReadSQLite(dbase, toInt(friendID), toInt(userID))
-	utils.SendJSON(w, models.Response{Success: true, Data: msgs}, http.StatusOK)
+	if err := messages.MarkRead(r.Context(), userID, toInt(friendID)); err != nil {
+		log.Printf("warning: could not mark messages from %s to %d as read: %v", friendID, userID, err)
+	}
+
+	// Without a cursor the reply keeps the original shape, a plain array (of the
+	// newest page); clients page further back from its first message with before
+	var data interface{} = msgs
+	if page.Before == 0 && page.After == 0 {
+		data = msgs.Messages
+	}
+	utils.SendJSON(w, models.Response{Success: true, Data: data}, http.StatusOK)
 }
 
-// sendMessageHandler inserts a message and notifies recipient via WS
-func sendMessageHandler(w http.ResponseWriter, r *http.Request) {
-	var req struct {
-		SenderID    int    `json:"sender_id"`
-		RecipientID int    `json:"recipient_id"`
-		Message     string `json:"message"`
+// parsePageRequest reads the before/after/limit query parameters of a message page.
+func parsePageRequest(r *http.Request) (models.MessagePageRequest, error) {
+	q := r.URL.Query()
+	page := models.MessagePageRequest{Limit: defaultPageSize}
+
+	for name, dest := range map[string]*int{"before": &page.Before, "after": &page.After, "limit": &page.Limit} {
+		v := q.Get(name)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return page, newAPIError(http.StatusBadRequest, "Invalid "+name)
+		}
+		*dest = n
 	}
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		utils.SendJSON(w, models.Response{Success: false, Message: "Invalid request"}, http.StatusBadRequest)
-		return
+	if page.Before != 0 && page.After != 0 {
+		return page, newAPIError(http.StatusBadRequest, "Use either before or after, not both")
 	}
+	if page.Limit > maxPageSize {
+		page.Limit = maxPageSize
+	}
+	return page, nil
+}
 
-	if req.SenderID == 0 || req.RecipientID == 0 || req.Message == "" {
-		utils.SendJSON(w, models.Response{Success: false, Message: "All fields are required"}, http.StatusBadRequest)
+// sendMessageHandler inserts a message from the caller and notifies recipient via WS
+func sendMessageHandler(w http.ResponseWriter, r *http.Request) {
+	var req models.SendMessageRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendError(w, errInvalidRequest)
 		return
 	}
 
-	result, err := dbase.Exec("INSERT INTO messages (sender_id, recipient_id, message) VALUES (?, ?, ?)", req.SenderID, req.RecipientID, req.Message)
+	msg, err := sendMessage(r.Context(), req)
 	if err != nil {
-		utils.SendJSON(w, models.Response{Success: false, Message: "Error sending message"}, http.StatusInternalServerError)
+		sendError(w, err)
 		return
 	}
 
-	messageID, _ := result.LastInsertId()
+	utils.SendJSON(w, models.Response{Success: true, Data: msg}, http.StatusCreated)
+}
 
-	var msg models.Message
-	err = dbase.QueryRow(`
-		SELECT m.id, m.sender_id, u.username, m.recipient_id, m.message, m.is_read, m.created_at
-		FROM messages m
-		JOIN users u ON m.sender_id = u.id
-		WHERE m.id = ?
-	`, messageID).Scan(&msg.ID, &msg.SenderID, &msg.SenderName, &msg.RecipientID, &msg.Message, &msg.IsRead, &msg.CreatedAt)
+// sendMessage stores a message from the caller and pushes it to the recipient, or
+// to the other members when conversation_id names a group.
+// Shared by POST /api/messages and the websocket "send_message" command.
+func sendMessage(ctx context.Context, req models.SendMessageRequest) (models.Message, error) {
+	senderID := auth.UserID(ctx)
 
-	if err == nil {
-		// store in Mongo if available (Mongo is primary for messages)
-		if mClient != nil {
-			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-			defer cancel()
-			// Normalize CreatedAt to UTC before inserting and notifying so clients
-			// always receive an ISO timestamp with timezone information.
-			msg.CreatedAt = msg.CreatedAt.UTC()
-			_ = dbmongo.InsertMessage(ctx, mClient, msg)
-		}
+	// A message needs text, attachments or both
+	if (req.RecipientID == 0 && req.ConversationID == 0) || (req.Message == "" && len(req.AttachmentIDs) == 0) {
+		return models.Message{}, newAPIError(http.StatusBadRequest, "All fields are required")
+	}
+	if len(req.AttachmentIDs) > maxAttachmentsPerMessage {
+		return models.Message{}, newAPIError(http.StatusBadRequest, "Too many attachments")
+	}
+	if req.ConversationID != 0 {
+		return sendConversationMessage(ctx, req)
+	}
 
-		ws.NotifyUser(req.RecipientID, models.WSMessage{Type: "message", Data: msg})
+	msg, err := messages.Insert(ctx, senderID, req)
+	if err != nil {
+		return models.Message{}, insertMessageError(err)
 	}
 
-	utils.SendJSON(w, models.Response{Success: true, Data: msg}, http.StatusCreated)
+	// Normalize CreatedAt to UTC before notifying so clients
+	// always receive an ISO timestamp with timezone information.
+	msg.CreatedAt = msg.CreatedAt.UTC()
+
+	// The message itself ends the sender's typing state; clients clear it on receipt
+	clearTyping(typingKey{from: senderID, to: req.RecipientID})
+
+	ws.NotifyUser(req.RecipientID, models.WSMessage{Type: "message", Data: msg})
+	return msg, nil
 }
 
-// getUnreadCountHandler returns total unread messages for a user
-func getUnreadCountHandler(w http.ResponseWriter, r *http.Request) {
-	userID := r.URL.Query().Get("user_id")
+// insertMessageError maps an error from inserting a message to an API error.
+func insertMessageError(err error) error {
+	if errors.Is(err, storage.ErrAttachmentUnavailable) {
+		return newAPIError(http.StatusBadRequest, "Attachment not found or already sent")
+	}
+	if errors.Is(err, storage.ErrInvalidReply) {
+		return newAPIError(http.StatusBadRequest, "Replies must quote a message from the same conversation")
+	}
+	return newAPIError(http.StatusInternalServerError, "Error sending message")
+}
 
-	// Prefer Mongo if available
-	if mClient != nil {
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
-		cnt, err := dbmongo.CountUnread(ctx, mClient, toInt(userID))
-		if err != nil {
-			utils.SendJSON(w, models.Response{Success: false, Message: "Error fetching unread count"}, http.StatusInternalServerError)
-			return
-		}
-		utils.SendJSON(w, models.Response{Success: true, Data: map[string]interface{}{"count": cnt}}, http.StatusOK)
+// markReadHandler marks every message from friendId to the caller as read
+func markReadHandler(w http.ResponseWriter, r *http.Request) {
+	friendID := toInt(mux.Vars(r)["friendId"])
+
+	if err := markConversationRead(r.Context(), friendID); err != nil {
+		sendError(w, err)
 		return
 	}
 
+	utils.SendJSON(w, models.Response{Success: true, Message: "Conversation marked as read"}, http.StatusOK)
+}
+
+// markConversationRead marks messages from friendID to the caller as read.
+// Shared by POST /api/messages/{friendId}/read and the websocket "mark_read" command.
+func markConversationRead(ctx context.Context, friendID int) error {
+	userID := auth.UserID(ctx)
+	if friendID == 0 {
+		return newAPIError(http.StatusBadRequest, "friend_id is required")
+	}
+
+	if err := messages.MarkRead(ctx, userID, friendID); err != nil {
+		return newAPIError(http.StatusInternalServerError, "Error marking messages as read")
+	}
+	return nil
+}
+
+// getUnreadCountHandler returns total unread messages for the caller
+func getUnreadCountHandler(w http.ResponseWriter, r *http.Request) {
+	userID := auth.UserID(r.Context())
+
+	// Not every store can join against memberships, so pass the caller's conversations along
+	ids, err := dbsqlite.ConversationIDs(dbase, userID)
 	var count int
-	err := dbase.QueryRow("SELECT COUNT(*) FROM messages WHERE recipient_id = ? AND is_read = 0", userID).Scan(&count)
+	if err == nil {
+		count, err = messages.CountUnread(r.Context(), userID, ids)
+	}
 	if err != nil {
 		utils.SendJSON(w, models.Response{Success: false, Message: "Error fetching unread count"}, http.StatusInternalServerError)
 		return
@@ -434,18 +596,17 @@ func toInt(s string) int {
 	return i
 }
 
-// updateUserHandler updates a user's username and/or password.
+// updateUserHandler updates the caller's username and/or password.
+// Changing the password revokes the caller's other sessions.
 // Expected JSON body:
 //
 //	{
-//	  "user_id": 1,
 //	  "new_username": "optional string",
 //	  "current_password": "required if new_password provided",
 //	  "new_password": "optional string"
 //	}
 func updateUserHandler(w http.ResponseWriter, r *http.Request) {
 	var req struct {
-		UserID          int    `json:"user_id"`
 		NewUsername     string `json:"new_username"`
 		CurrentPassword string `json:"current_password"`
 		NewPassword     string `json:"new_password"`
@@ -455,11 +616,8 @@ func updateUserHandler(w http.ResponseWriter, r *http.Request) {
 		utils.SendJSON(w, models.Response{Success: false, Message: "Invalid request"}, http.StatusBadRequest)
 		return
 	}
-
-	if req.UserID == 0 {
-		utils.SendJSON(w, models.Response{Success: false, Message: "user_id is required"}, http.StatusBadRequest)
-		return
-	}
+	session, _ := auth.SessionFromContext(r.Context())
+	userID := session.UserID
 
 	if req.NewUsername == "" && req.NewPassword == "" {
 		utils.SendJSON(w, models.Response{Success: false, Message: "No changes provided"}, http.StatusBadRequest)
@@ -468,7 +626,7 @@ func updateUserHandler(w http.ResponseWriter, r *http.Request) {
 
 	// Fetch existing user for validation
 	var currentUsername, currentHashed string
-	err := dbase.QueryRow("SELECT username, password FROM users WHERE id = ?", req.UserID).Scan(&currentUsername, &currentHashed)
+	err := dbase.QueryRow("SELECT username, password FROM users WHERE id = ?", userID).Scan(&currentUsername, &currentHashed)
 	if err != nil {
 		utils.SendJSON(w, models.Response{Success: false, Message: "User not found"}, http.StatusNotFound)
 		return
@@ -494,10 +652,18 @@ func updateUserHandler(w http.ResponseWriter, r *http.Request) {
 			utils.SendJSON(w, models.Response{Success: false, Message: "Error processing new password"}, http.StatusInternalServerError)
 			return
 		}
-		if _, err := dbase.Exec("UPDATE users SET password = ? WHERE id = ?", string(newHash), req.UserID); err != nil {
+		if _, err := dbase.Exec("UPDATE users SET password = ? WHERE id = ?", string(newHash), userID); err != nil {
 			utils.SendJSON(w, models.Response{Success: false, Message: "Error updating password"}, http.StatusInternalServerError)
 			return
 		}
+		revoked, err := auth.RevokeOthers(userID, session.ID)
+		if err != nil {
+			utils.SendJSON(w, models.Response{Success: false, Message: "Error revoking other sessions"}, http.StatusInternalServerError)
+			return
+		}
+		for _, id := range revoked {
+			ws.CloseSession(id)
+		}
 	}
 
 	// Handle username change
@@ -510,14 +676,17 @@ func updateUserHandler(w http.ResponseWriter, r *http.Request) {
 			utils.SendJSON(w, models.Response{Success: false, Message: "Username already taken"}, http.StatusConflict)
 			return
 		}
-		if _, err := dbase.Exec("UPDATE users SET username = ? WHERE id = ?", req.NewUsername, req.UserID); err != nil {
+		if _, err := dbase.Exec("UPDATE users SET username = ? WHERE id = ?", req.NewUsername, userID); err != nil {
 			utils.SendJSON(w, models.Response{Success: false, Message: "Error updating username"}, http.StatusInternalServerError)
 			return
 		}
 		finalUsername = req.NewUsername
+		if _, err := notifyProfileChanged(userID); err != nil {
+			log.Printf("warning: could not notify friends of user %d: %v", userID, err)
+		}
 	}
 
-	utils.SendJSON(w, models.Response{Success: true, Message: "Account updated", Data: map[string]interface{}{"user_id": req.UserID, "username": finalUsername}}, http.StatusOK)
+	utils.SendJSON(w, models.Response{Success: true, Message: "Account updated", Data: map[string]interface{}{"user_id": userID, "username": finalUsername}}, http.StatusOK)
 }
 
 //
@@ -0,0 +1,260 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"DB-Presentation/auth"
+	"DB-Presentation/models"
+	"DB-Presentation/utils"
+	"DB-Presentation/ws"
+
+	dbsqlite "DB-Presentation/database/sqlite"
+)
+
+// DefaultAwayAfter is how long a connected user may go without activity before
+// being shown as away. Override with PRESENCE_AWAY_AFTER.
+const DefaultAwayAfter = 5 * time.Minute
+
+// Presence statuses, as stored in users.status.
+const (
+	statusOnline  = "online"
+	statusAway    = "away"
+	statusOffline = "offline"
+)
+
+var awayAfter = DefaultAwayAfter
+
+const (
+	// presenceHeartbeat is how often this instance renews its presence rows, which
+	// other instances treat as gone once they are presenceStaleAfter old.
+	presenceHeartbeat  = 30 * time.Second
+	presenceStaleAfter = 3 * presenceHeartbeat
+)
+
+// instanceID names this server's rows in presence_instances. INSTANCE_ID keeps it
+// across restarts, so that a restarted instance clears its own rows at once;
+// otherwise a random id is used and the previous run's rows expire.
+var instanceID string
+
+// presence tracks connected users on this instance. Connecting makes a user online,
+// activity (any frame from their client, or a heartbeat) keeps or brings them back
+// online, a quiet spell of awayAfter makes them away, and closing (or being reaped
+// for missing heartbeats) the last connection makes them offline.
+//
+// The lock only guards this state; storing and announcing a change happens after
+// unlocking, in applyPresence.
+var presence = struct {
+	sync.Mutex
+	users map[int]*presenceState
+	seqs  map[int]uint64 // per user, numbers status changes; outlives going offline
+}{users: make(map[int]*presenceState), seqs: make(map[int]uint64)}
+
+type presenceState struct {
+	status     string
+	lastActive time.Time
+}
+
+// presenceChange is a status change recorded under the presence lock.
+type presenceChange struct {
+	userID int
+	status string
+	seq    uint64
+}
+
+// recordPresence numbers a status change for userID. Callers hold the presence lock.
+func recordPresence(userID int, status string) presenceChange {
+	presence.seqs[userID]++
+	return presenceChange{userID: userID, status: status, seq: presence.seqs[userID]}
+}
+
+// presenceWriter serialises storing and announcing one user's changes.
+type presenceWriter struct {
+	sync.Mutex
+	applied uint64
+}
+
+var presenceWriters sync.Map // user id -> *presenceWriter
+
+// initPresence clears this instance's presence left over from a previous run and
+// starts the idle-to-away sweep, which also keeps its presence rows alive.
+func initPresence() {
+	if v := os.Getenv("PRESENCE_AWAY_AFTER"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			awayAfter = d
+		} else {
+			log.Printf("warning: invalid PRESENCE_AWAY_AFTER %q, using %s", v, DefaultAwayAfter)
+		}
+	}
+
+	instanceID = os.Getenv("INSTANCE_ID")
+	if instanceID == "" {
+		id := make([]byte, 8)
+		if _, err := rand.Read(id); err != nil {
+			log.Fatal(err)
+		}
+		instanceID = hex.EncodeToString(id)
+	}
+	expirePresence(instanceID)
+
+	ws.SetPresenceHandler(updatePresence)
+	ws.SetActivityHandler(markActive)
+	ws.HandleCommand("heartbeat", func(ctx context.Context, data json.RawMessage) (interface{}, error) {
+		// The frame itself counted as activity
+		return nil, nil
+	})
+
+	go sweepAway()
+}
+
+// updatePresence follows websocket connectivity: online on the first connection,
+// offline when the last one closes.
+func updatePresence(userID int, online bool) {
+	presence.Lock()
+	var change presenceChange
+	if online {
+		presence.users[userID] = &presenceState{status: statusOnline, lastActive: time.Now()}
+		change = recordPresence(userID, statusOnline)
+	} else {
+		delete(presence.users, userID)
+		change = recordPresence(userID, statusOffline)
+	}
+	presence.Unlock()
+
+	applyPresence(change)
+}
+
+// markActive records activity for a connected user, bringing them back from away.
+func markActive(userID int) {
+	presence.Lock()
+	p, ok := presence.users[userID]
+	if !ok {
+		presence.Unlock()
+		return
+	}
+	p.lastActive = time.Now()
+	if p.status != statusAway {
+		presence.Unlock()
+		return
+	}
+	p.status = statusOnline
+	change := recordPresence(userID, statusOnline)
+	presence.Unlock()
+
+	applyPresence(change)
+}
+
+// sweepAway periodically moves connected users without recent activity to away.
+func sweepAway() {
+	interval := awayAfter / 4
+	if interval > 30*time.Second {
+		interval = 30 * time.Second
+	}
+	if interval < time.Second {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	lastHeartbeat := time.Now()
+	for range ticker.C {
+		if time.Since(lastHeartbeat) >= presenceHeartbeat {
+			lastHeartbeat = time.Now()
+			if err := dbsqlite.TouchPresence(dbase, instanceID, lastHeartbeat); err != nil {
+				log.Println("warning: could not renew presence:", err)
+			}
+			expirePresence("")
+		}
+
+		var changes []presenceChange
+		presence.Lock()
+		for id, p := range presence.users {
+			if p.status == statusOnline && time.Since(p.lastActive) >= awayAfter {
+				p.status = statusAway
+				changes = append(changes, recordPresence(id, statusAway))
+			}
+		}
+		presence.Unlock()
+
+		for _, change := range changes {
+			applyPresence(change)
+		}
+	}
+}
+
+// applyPresence stores and announces a recorded change. One user's changes are
+// applied one at a time, and a change overtaken by a newer one is dropped, so the
+// last recorded status is the one that sticks.
+func applyPresence(change presenceChange) {
+	v, _ := presenceWriters.LoadOrStore(change.userID, &presenceWriter{})
+	w := v.(*presenceWriter)
+	w.Lock()
+	defer w.Unlock()
+
+	if change.seq <= w.applied {
+		return
+	}
+	w.applied = change.seq
+	setPresence(change.userID, change.status)
+}
+
+// setPresence stores the user's status on this instance and tells their friends
+// the status that results across instances. Only applyPresence calls it, so
+// updates for a user are stored and sent in order.
+func setPresence(userID int, status string) {
+	now := time.Now().UTC()
+	combined, err := dbsqlite.SetInstancePresence(dbase, instanceID, userID, status, now, now.Add(-presenceStaleAfter))
+	if err != nil {
+		log.Printf("warning: could not update status for user %d: %v", userID, err)
+		combined = status
+	}
+	announcePresence(userID, combined, now)
+}
+
+// expirePresence drops the presence rows of instances that stopped renewing them,
+// and of instance if given, and announces the statuses that changed.
+func expirePresence(instance string) {
+	now := time.Now().UTC()
+	changed, err := dbsqlite.ExpirePresence(dbase, instance, now.Add(-presenceStaleAfter))
+	if err != nil {
+		log.Println("warning: could not expire user statuses:", err)
+		return
+	}
+	for userID, status := range changed {
+		announcePresence(userID, status, now)
+	}
+}
+
+// announcePresence sends a user's status to their friends.
+func announcePresence(userID int, status string, now time.Time) {
+	friendIDs, err := dbsqlite.FriendIDs(dbase, userID)
+	if err != nil {
+		log.Printf("warning: could not load friends of user %d: %v", userID, err)
+		return
+	}
+
+	// Presence is current state, not history: it is not replayed, and clients
+	// reload it with the friend list when they reconnect.
+	msg := models.WSMessage{Type: "presence", Data: map[string]interface{}{
+		"user_id":      userID,
+		"status":       status,
+		"last_seen_at": now,
+	}}
+	for _, id := range friendIDs {
+		ws.SendTransient(id, msg)
+	}
+}
+
+// heartbeatHandler counts as activity for clients without a websocket, e.g. on
+// the SSE fallback.
+func heartbeatHandler(w http.ResponseWriter, r *http.Request) {
+	markActive(auth.UserID(r.Context()))
+	utils.SendJSON(w, models.Response{Success: true}, http.StatusOK)
+}
@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/mail"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+
+	"DB-Presentation/auth"
+	"DB-Presentation/models"
+	"DB-Presentation/utils"
+	"DB-Presentation/ws"
+
+	dbsqlite "DB-Presentation/database/sqlite"
+)
+
+const (
+	maxEmailLength = 254
+	maxBioLength   = 500
+)
+
+var hexColor = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+// getProfileHandler returns a user's profile. The full profile (email, bio,
+// presence) is only visible to the user and their accepted friends.
+func getProfileHandler(w http.ResponseWriter, r *http.Request) {
+	callerID := auth.UserID(r.Context())
+	userID := toInt(mux.Vars(r)["id"])
+
+	profile, err := dbsqlite.GetProfile(dbase, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		utils.SendJSON(w, models.Response{Success: false, Message: "User not found"}, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		utils.SendJSON(w, models.Response{Success: false, Message: "Error fetching profile"}, http.StatusInternalServerError)
+		return
+	}
+
+	if userID != callerID {
+		friends, err := dbsqlite.AreFriends(dbase, callerID, userID)
+		if err != nil {
+			utils.SendJSON(w, models.Response{Success: false, Message: "Error fetching profile"}, http.StatusInternalServerError)
+			return
+		}
+		if !friends {
+			profile = models.Profile{ID: profile.ID, Username: profile.Username, AvatarColor: profile.AvatarColor, AvatarID: profile.AvatarID}
+		}
+	}
+	withAvatarURLs(&profile)
+
+	utils.SendJSON(w, models.Response{Success: true, Data: profile}, http.StatusOK)
+}
+
+// patchProfileHandler updates the caller's email, bio and avatar_color. Only
+// fields present in the body change.
+func patchProfileHandler(w http.ResponseWriter, r *http.Request) {
+	userID := auth.UserID(r.Context())
+	if toInt(mux.Vars(r)["id"]) != userID {
+		utils.SendJSON(w, models.Response{Success: false, Message: "You can only edit your own profile"}, http.StatusForbidden)
+		return
+	}
+
+	var req models.UpdateProfileRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendError(w, errInvalidRequest)
+		return
+	}
+	if req.Email == nil && req.Bio == nil && req.AvatarColor == nil {
+		utils.SendJSON(w, models.Response{Success: false, Message: "No changes provided"}, http.StatusBadRequest)
+		return
+	}
+	if err := validateProfile(&req); err != nil {
+		sendError(w, err)
+		return
+	}
+
+	if err := dbsqlite.UpdateProfile(dbase, userID, req); err != nil {
+		utils.SendJSON(w, models.Response{Success: false, Message: "Error updating profile"}, http.StatusInternalServerError)
+		return
+	}
+
+	profile, err := notifyProfileChanged(userID)
+	if err != nil {
+		utils.SendJSON(w, models.Response{Success: false, Message: "Error fetching profile"}, http.StatusInternalServerError)
+		return
+	}
+
+	utils.SendJSON(w, models.Response{Success: true, Message: "Profile updated", Data: profile}, http.StatusOK)
+}
+
+// validateProfile checks and normalizes the fields being changed.
+func validateProfile(req *models.UpdateProfileRequest) error {
+	if req.Email != nil {
+		email := strings.TrimSpace(*req.Email)
+		if email != "" {
+			addr, err := mail.ParseAddress(email)
+			if err != nil || addr.Address != email || len(email) > maxEmailLength {
+				return newAPIError(http.StatusBadRequest, "Invalid email address")
+			}
+		}
+		req.Email = &email
+	}
+
+	if req.Bio != nil {
+		bio := strings.TrimSpace(*req.Bio)
+		if utf8.RuneCountInString(bio) > maxBioLength {
+			return newAPIError(http.StatusBadRequest, "Bio must be at most 500 characters")
+		}
+		req.Bio = &bio
+	}
+
+	if req.AvatarColor != nil {
+		if !hexColor.MatchString(*req.AvatarColor) {
+			return newAPIError(http.StatusBadRequest, "avatar_color must be a hex color like #8774e1")
+		}
+		color := strings.ToLower(*req.AvatarColor)
+		req.AvatarColor = &color
+	}
+	return nil
+}
+
+// notifyProfileChanged pushes the user's current profile to their friends as a
+// profile_updated event and returns it.
+func notifyProfileChanged(userID int) (models.Profile, error) {
+	profile, err := dbsqlite.GetProfile(dbase, userID)
+	if err != nil {
+		return profile, err
+	}
+	withAvatarURLs(&profile)
+
+	friendIDs, err := dbsqlite.FriendIDs(dbase, userID)
+	if err != nil {
+		log.Printf("warning: could not load friends of user %d: %v", userID, err)
+		return profile, nil
+	}
+	for _, id := range friendIDs {
+		ws.NotifyUser(id, models.WSMessage{Type: "profile_updated", Data: profile})
+	}
+	return profile, nil
+}
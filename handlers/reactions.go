@@ -0,0 +1,158 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+	"unicode"
+
+	"github.com/gorilla/mux"
+
+	"DB-Presentation/auth"
+	"DB-Presentation/models"
+	"DB-Presentation/storage"
+	"DB-Presentation/utils"
+	"DB-Presentation/ws"
+
+	dbsqlite "DB-Presentation/database/sqlite"
+)
+
+// maxEmojiBytes bounds a reaction; enough for flag and ZWJ family sequences.
+const maxEmojiBytes = 32
+
+// addReactionHandler adds the caller's {"emoji": "..."} reaction to a message
+func addReactionHandler(w http.ResponseWriter, r *http.Request) {
+	var req models.ReactionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendError(w, errInvalidRequest)
+		return
+	}
+
+	reactions, err := setReaction(r.Context(), toInt(mux.Vars(r)["id"]), req.Emoji, true)
+	if err != nil {
+		sendError(w, err)
+		return
+	}
+
+	utils.SendJSON(w, models.Response{Success: true, Data: reactions}, http.StatusOK)
+}
+
+// removeReactionHandler removes the caller's reaction given in the URL
+func removeReactionHandler(w http.ResponseWriter, r *http.Request) {
+	reactions, err := setReaction(r.Context(), toInt(mux.Vars(r)["id"]), mux.Vars(r)["emoji"], false)
+	if err != nil {
+		sendError(w, err)
+		return
+	}
+
+	utils.SendJSON(w, models.Response{Success: true, Data: reactions}, http.StatusOK)
+}
+
+// listReactionsHandler lists who reacted to a message with what
+func listReactionsHandler(w http.ResponseWriter, r *http.Request) {
+	id := toInt(mux.Vars(r)["id"])
+	if _, err := participantMessage(r.Context(), id); err != nil {
+		sendError(w, err)
+		return
+	}
+
+	reactions, err := messages.Reactions(r.Context(), id)
+	if err != nil {
+		utils.SendJSON(w, models.Response{Success: false, Message: "Error fetching reactions"}, http.StatusInternalServerError)
+		return
+	}
+	utils.SendJSON(w, models.Response{Success: true, Data: reactions}, http.StatusOK)
+}
+
+// participantMessage loads a message from one of the caller's conversations.
+// Other messages are reported as not found.
+func participantMessage(ctx context.Context, id int) (models.Message, error) {
+	userID := auth.UserID(ctx)
+	msg, err := messages.Get(ctx, id)
+	if err == nil {
+		var member bool
+		if member, err = dbsqlite.IsMember(dbase, msg.ConversationID, userID); err == nil && !member {
+			err = storage.ErrMessageNotFound
+		}
+	}
+	if errors.Is(err, storage.ErrMessageNotFound) {
+		return msg, newAPIError(http.StatusNotFound, "Message not found")
+	}
+	if err != nil {
+		return msg, newAPIError(http.StatusInternalServerError, "Error fetching message")
+	}
+	return msg, nil
+}
+
+// setReaction adds or removes the caller's emoji reaction on a message and, if that
+// changed anything, pushes a "reaction" event to the conversation's other members.
+// It returns the message's aggregated reactions.
+// Shared by the reaction endpoints and the websocket "add_reaction"/"remove_reaction" commands.
+func setReaction(ctx context.Context, id int, emoji string, add bool) ([]models.Reaction, error) {
+	userID := auth.UserID(ctx)
+	if !validEmoji(emoji) {
+		return nil, newAPIError(http.StatusBadRequest, "A single emoji is required")
+	}
+	msg, err := participantMessage(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if msg.DeletedAt != nil {
+		return nil, newAPIError(http.StatusGone, "Message was deleted")
+	}
+
+	var changed bool
+	if add {
+		var username string
+		_ = dbase.QueryRow("SELECT username FROM users WHERE id = ?", userID).Scan(&username)
+		changed, err = messages.AddReaction(ctx, models.MessageReaction{MessageID: id, UserID: userID, Username: username, Emoji: emoji, CreatedAt: time.Now()})
+	} else {
+		changed, err = messages.RemoveReaction(ctx, id, userID, emoji)
+	}
+	if err != nil {
+		return nil, newAPIError(http.StatusInternalServerError, "Error saving reaction")
+	}
+
+	reactions, err := messages.ReactionCounts(ctx, id)
+	if err != nil {
+		return nil, newAPIError(http.StatusInternalServerError, "Error fetching reactions")
+	}
+	if reactions == nil {
+		reactions = []models.Reaction{}
+	}
+
+	if changed {
+		action := "added"
+		if !add {
+			action = "removed"
+		}
+		ws.NotifyUsers(audience(msg, userID), models.WSMessage{Type: "reaction", Data: map[string]interface{}{
+			"message_id": id,
+			"user_id":    userID,
+			"emoji":      emoji,
+			"action":     action,
+			"reactions":  reactions,
+		}})
+	}
+	return reactions, nil
+}
+
+// validEmoji accepts a short run of symbols (with joiners, variation selectors and
+// modifiers); plain text is rejected.
+func validEmoji(s string) bool {
+	if s == "" || len(s) > maxEmojiBytes {
+		return false
+	}
+	hasSymbol := false
+	for _, r := range s {
+		switch {
+		case unicode.IsLetter(r), unicode.IsSpace(r), unicode.IsControl(r):
+			return false
+		case unicode.Is(unicode.So, r), unicode.Is(unicode.Sk, r):
+			hasSymbol = true
+		}
+	}
+	return hasSymbol
+}
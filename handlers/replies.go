@@ -0,0 +1,29 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"DB-Presentation/models"
+	"DB-Presentation/utils"
+)
+
+// repliesHandler returns every message quoting a message the caller sent or received
+func repliesHandler(w http.ResponseWriter, r *http.Request) {
+	id := toInt(mux.Vars(r)["id"])
+	if _, err := participantMessage(r.Context(), id); err != nil {
+		sendError(w, err)
+		return
+	}
+
+	replies, err := messages.Replies(r.Context(), id)
+	if err != nil {
+		utils.SendJSON(w, models.Response{Success: false, Message: "Error fetching replies"}, http.StatusInternalServerError)
+		return
+	}
+	if replies == nil {
+		replies = []models.Message{}
+	}
+	utils.SendJSON(w, models.Response{Success: true, Data: replies}, http.StatusOK)
+}
@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"DB-Presentation/auth"
+	"DB-Presentation/models"
+	"DB-Presentation/storage"
+	"DB-Presentation/utils"
+
+	dbsqlite "DB-Presentation/database/sqlite"
+)
+
+// searchMessagesHandler searches the caller's conversations.
+// ?q= is required; ?friend_id=, ?conversation_id=, ?from= and ?to= (RFC 3339 or YYYY-MM-DD) narrow it down,
+// and ?before= / ?limit= page through the results like message history.
+func searchMessagesHandler(w http.ResponseWriter, r *http.Request) {
+	userID := auth.UserID(r.Context())
+	q := r.URL.Query()
+
+	req := models.SearchRequest{Terms: utils.SearchTerms(q.Get("q")), Limit: defaultPageSize}
+	if len(req.Terms) == 0 {
+		utils.SendJSON(w, models.Response{Success: false, Message: "A search query is required"}, http.StatusBadRequest)
+		return
+	}
+	for name, dest := range map[string]*int{"friend_id": &req.FriendID, "conversation_id": &req.ConversationID, "before": &req.Before, "limit": &req.Limit} {
+		if v := q.Get(name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				utils.SendJSON(w, models.Response{Success: false, Message: "Invalid " + name}, http.StatusBadRequest)
+				return
+			}
+			*dest = n
+		}
+	}
+	if req.Limit > maxPageSize {
+		req.Limit = maxPageSize
+	}
+	for name, dest := range map[string]**time.Time{"from": &req.From, "to": &req.To} {
+		if v := q.Get(name); v != "" {
+			t, err := parseSearchDate(v)
+			if err != nil {
+				utils.SendJSON(w, models.Response{Success: false, Message: "Invalid " + name}, http.StatusBadRequest)
+				return
+			}
+			*dest = &t
+		}
+	}
+	// A bare date as "to" includes that whole day
+	if v := q.Get("to"); len(v) == len("2006-01-02") && req.To != nil {
+		end := req.To.AddDate(0, 0, 1)
+		req.To = &end
+	}
+
+	// Not every store can join against memberships, so pass the caller's conversations along
+	ids, err := dbsqlite.ConversationIDs(dbase, userID)
+	if err != nil {
+		utils.SendJSON(w, models.Response{Success: false, Message: "Error searching messages"}, http.StatusInternalServerError)
+		return
+	}
+	req.ConversationIDs = ids
+
+	page, err := messages.Search(r.Context(), userID, req)
+	if errors.Is(err, storage.ErrInvalidCursor) {
+		utils.SendJSON(w, models.Response{Success: false, Message: "Invalid cursor"}, http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		utils.SendJSON(w, models.Response{Success: false, Message: "Error searching messages"}, http.StatusInternalServerError)
+		return
+	}
+	utils.SendJSON(w, models.Response{Success: true, Data: page}, http.StatusOK)
+}
+
+func parseSearchDate(v string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", v)
+}
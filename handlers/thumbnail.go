@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+)
+
+// thumbnail center-crops img to a square and scales it to size x size. Each target
+// pixel averages the source pixels it covers (a box filter), which is good enough
+// for downscaling photos without anything beyond the standard library. Transparent
+// areas are flattened onto white, since thumbnails are stored as JPEG.
+func thumbnail(img image.Image, size int) *image.RGBA {
+	b := img.Bounds()
+	side := b.Dx()
+	if b.Dy() < side {
+		side = b.Dy()
+	}
+	crop := image.Rect(0, 0, side, side)
+	offset := image.Pt(b.Min.X+(b.Dx()-side)/2, b.Min.Y+(b.Dy()-side)/2)
+
+	// Flattening also converts any source format to RGBA for direct pixel access
+	src := image.NewRGBA(crop)
+	draw.Draw(src, crop, &image.Uniform{C: color.White}, image.Point{}, draw.Src)
+	draw.Draw(src, crop, img, offset, draw.Over)
+
+	dst := image.NewRGBA(image.Rect(0, 0, size, size))
+	for ty := 0; ty < size; ty++ {
+		y0, y1 := span(ty, size, side)
+		for tx := 0; tx < size; tx++ {
+			x0, x1 := span(tx, size, side)
+
+			var r, g, bl, n int
+			for y := y0; y < y1; y++ {
+				row := src.Pix[y*src.Stride:]
+				for x := x0; x < x1; x++ {
+					r += int(row[x*4])
+					g += int(row[x*4+1])
+					bl += int(row[x*4+2])
+					n++
+				}
+			}
+			i := dst.PixOffset(tx, ty)
+			dst.Pix[i] = uint8(r / n)
+			dst.Pix[i+1] = uint8(g / n)
+			dst.Pix[i+2] = uint8(bl / n)
+			dst.Pix[i+3] = 0xff
+		}
+	}
+	return dst
+}
+
+// span returns the source range [lo, hi) covered by target index i when scaling
+// side source pixels to size target pixels. It is never empty, so small images are
+// scaled up by repeating pixels.
+func span(i, size, side int) (int, int) {
+	lo := i * side / size
+	hi := (i + 1) * side / size
+	if hi <= lo {
+		hi = lo + 1
+	}
+	return lo, hi
+}
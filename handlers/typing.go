@@ -0,0 +1,154 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"DB-Presentation/auth"
+	"DB-Presentation/models"
+	"DB-Presentation/utils"
+	"DB-Presentation/ws"
+
+	dbsqlite "DB-Presentation/database/sqlite"
+)
+
+const (
+	// typingTTL is how long a typing_start stays in effect without a refresh. The
+	// server relays typing_stop when it runs out, and clients should expire the
+	// indicator on their own after the same time.
+	typingTTL = 5 * time.Second
+
+	// typingRelayInterval rate-limits typing_start: refreshes arriving sooner than
+	// this after the last relayed one only extend the TTL.
+	typingRelayInterval = 2 * time.Second
+)
+
+// typingKey identifies one direction of a conversation: from is typing to to.
+type typingKey struct {
+	from, to int
+}
+
+type typingEntry struct {
+	relayedAt time.Time
+	expiry    *time.Timer
+}
+
+// typing holds who is currently typing to whom on this instance.
+var typing = struct {
+	sync.Mutex
+	active map[typingKey]*typingEntry
+}{active: make(map[typingKey]*typingEntry)}
+
+// setTyping relays the caller's typing state to friendID, who must be an accepted
+// friend. Starts are rate-limited and expire after typingTTL; a stop is only
+// relayed if a start is in effect. Nothing is persisted.
+// Shared by POST /api/messages/{friendId}/typing and the websocket typing commands.
+func setTyping(ctx context.Context, friendID int, active bool) error {
+	userID := auth.UserID(ctx)
+	if friendID == 0 {
+		return newAPIError(http.StatusBadRequest, "friend_id is required")
+	}
+	if friendID == userID {
+		return newAPIError(http.StatusBadRequest, "Cannot send typing indicators to yourself")
+	}
+
+	ok, err := dbsqlite.AreFriends(dbase, userID, friendID)
+	if err != nil {
+		return newAPIError(http.StatusInternalServerError, "Error checking friendship")
+	}
+	if !ok {
+		return newAPIError(http.StatusForbidden, "You can only send typing indicators to friends")
+	}
+
+	key := typingKey{from: userID, to: friendID}
+	if active {
+		startTyping(key)
+	} else if clearTyping(key) {
+		relayTyping(key, false)
+	}
+	return nil
+}
+
+// startTyping (re)arms the expiry for key and relays typing_start unless one was
+// relayed within typingRelayInterval.
+func startTyping(key typingKey) {
+	typing.Lock()
+	e, ok := typing.active[key]
+	if !ok {
+		e = &typingEntry{}
+		typing.active[key] = e
+		e.expiry = time.AfterFunc(typingTTL, func() {
+			typing.Lock()
+			current := typing.active[key] == e
+			if current {
+				delete(typing.active, key)
+			}
+			typing.Unlock()
+			if current {
+				relayTyping(key, false)
+			}
+		})
+	} else {
+		e.expiry.Reset(typingTTL)
+	}
+
+	relay := time.Since(e.relayedAt) >= typingRelayInterval
+	if relay {
+		e.relayedAt = time.Now()
+	}
+	typing.Unlock()
+
+	if relay {
+		relayTyping(key, true)
+	}
+}
+
+// clearTyping drops key's typing state and reports whether there was any.
+func clearTyping(key typingKey) bool {
+	typing.Lock()
+	defer typing.Unlock()
+
+	e, ok := typing.active[key]
+	if !ok {
+		return false
+	}
+	e.expiry.Stop()
+	delete(typing.active, key)
+	return true
+}
+
+// relayTyping sends typing_start or typing_stop to the other participant.
+func relayTyping(key typingKey, active bool) {
+	msg := models.WSMessage{Type: "typing_stop", Data: map[string]interface{}{"user_id": key.from}}
+	if active {
+		msg = models.WSMessage{Type: "typing_start", Data: map[string]interface{}{
+			"user_id":    key.from,
+			"expires_in": typingTTL.Milliseconds(),
+		}}
+	}
+	ws.SendTransient(key.to, msg)
+}
+
+// typingHandler sets the caller's typing state for friendId: {"typing": true|false}.
+// It serves clients without a websocket, e.g. on the SSE fallback.
+func typingHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Typing bool `json:"typing"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendError(w, errInvalidRequest)
+		return
+	}
+
+	if err := setTyping(r.Context(), toInt(mux.Vars(r)["friendId"]), req.Typing); err != nil {
+		sendError(w, err)
+		return
+	}
+
+	utils.SendJSON(w, models.Response{Success: true}, http.StatusOK)
+}
@@ -4,18 +4,26 @@ package main
 
 import (
 	"bufio"
+	"context"
+	"database/sql"
+	"expvar"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
 
+	"DB-Presentation/auth"
+	dbmongo "DB-Presentation/database/mongo"
 	"DB-Presentation/database/sqlite"
 	"DB-Presentation/db"
 	"DB-Presentation/handlers"
 	mongopkg "DB-Presentation/mongo"
+	"DB-Presentation/storage"
 	"DB-Presentation/utils"
 	"DB-Presentation/ws"
 
@@ -33,9 +41,15 @@ func main() {
 
 	fmt.Println("✅ Successfully connected to SQLite!")
 
-	if db.NeedsMigration(d) {
-		fmt.Println("⚠️  Database needs migration! Run: go run migrate.go")
-		fmt.Println("   Continuing anyway...")
+	if err := db.RunMigrations(d); err != nil {
+		log.Fatal(err)
+	}
+
+	// Full-text search needs FTS5 (build with -tags sqlite_fts5); otherwise it falls back to LIKE
+	if fts, err := sqlite.EnsureSearchIndex(d); err != nil {
+		log.Printf("⚠️  Warning: Could not set up the search index: %v\n", err)
+	} else if !fts {
+		log.Println("ℹ️  SQLite was built without FTS5; message search uses LIKE")
 	}
 
 	// Seed initial data (admin user)
@@ -46,6 +60,10 @@ func main() {
 	// load .env if present (simple parser)
 	loadEnvFile(".env")
 
+	// sessions need SESSION_SECRET / SESSION_TTL from the environment
+	auth.Init(d)
+	ws.Init(d)
+
 	// connect to Mongo if URI provided
 	var mongoClientPtr *mongodriver.Client
 	if uri := os.Getenv("MONGO_URI"); uri != "" {
@@ -58,17 +76,102 @@ func main() {
 		}
 	}
 
+	// WS_BROKER=mongo fans websocket events out to every instance sharing the Mongo database
+	if os.Getenv("WS_BROKER") == "mongo" {
+		if mongoClientPtr == nil {
+			log.Println("warning: WS_BROKER=mongo needs MONGO_URI, using the in-process broker")
+		} else if err := useMongoBroker(mongoClientPtr); err != nil {
+			log.Println("warning: could not start mongo broker, using the in-process broker:", err)
+		} else {
+			fmt.Println("✅ WebSocket events fan out through MongoDB")
+		}
+	}
+
+	// Uploaded files (avatars) live next to the database
+	blobs, err := storage.NewFSStore(filepath.Join("data", "blobs"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	handlers.SetBlobStore(blobs)
+	handlers.SetMessageStore(messageStore(d, mongoClientPtr))
+
 	router := mux.NewRouter()
 
-	// Register handlers and WebSocket route (pass mongo client if available)
-	handlers.RegisterRoutes(router, d, mongoClientPtr)
-	router.HandleFunc("/ws/{userId}", ws.HandleWebSocket)
+	// Register handlers and WebSocket route
+	handlers.RegisterRoutes(router, d)
+	router.HandleFunc("/ws", ws.HandleWebSocket)
+
+	// METRICS_ADDR (e.g. localhost:6060) serves expvar metrics, such as the outbox backlog, at /debug/vars
+	if addr := os.Getenv("METRICS_ADDR"); addr != "" {
+		metrics := http.NewServeMux()
+		metrics.Handle("/debug/vars", expvar.Handler())
+		go func() {
+			log.Println("warning: metrics server stopped:", http.ListenAndServe(addr, metrics))
+		}()
+	}
 
 	// Serve static files
 	router.PathPrefix("/").Handler(http.FileServer(http.Dir("./static")))
 
-	fmt.Println("🚀 Server starting on http://localhost:8080")
-	log.Fatal(http.ListenAndServe(":8080", utils.EnableCORS(router)))
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = "8080"
+	}
+
+	fmt.Printf("🚀 Server starting on http://localhost:%s\n", port)
+	log.Fatal(http.ListenAndServe(":"+port, utils.EnableCORS(router)))
+}
+
+// useMongoBroker switches websocket fan-out to the capped-collection broker, and the
+// event log to MongoDB so every instance hands out the same seqs.
+func useMongoBroker(mc *mongodriver.Client) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	events, err := dbmongo.NewEventLog(ctx, mc)
+	if err != nil {
+		return err
+	}
+	b, err := dbmongo.NewBroker(ctx, mc)
+	if err != nil {
+		return err
+	}
+	if err := ws.SetBroker(b); err != nil {
+		return err
+	}
+	ws.SetEventLog(events)
+	return nil
+}
+
+// messageStore picks the message backend from MESSAGE_STORE. "sqlite" keeps
+// messages in SQLite only; "mongo", the default when MongoDB is connected, mirrors
+// them to MongoDB through the outbox relay and serves reads from there.
+func messageStore(d *sql.DB, mc *mongodriver.Client) storage.MessageStore {
+	v := os.Getenv("MESSAGE_STORE")
+	if v != "" && v != "sqlite" && v != "mongo" {
+		log.Printf("warning: invalid MESSAGE_STORE %q, using the default", v)
+		v = ""
+	}
+	if v == "sqlite" {
+		return sqlite.NewMessageStore(d, false)
+	}
+	if mc == nil {
+		if v == "mongo" {
+			log.Println("warning: MESSAGE_STORE=mongo needs MONGO_URI, using sqlite")
+		}
+		return sqlite.NewMessageStore(d, false)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := dbmongo.EnsureMessageIndex(ctx, mc); err != nil {
+		log.Println("warning: could not create the unique message id index, run cmd/backfill-ids:", err)
+	}
+
+	relay := dbmongo.NewRelay(mc, d)
+	go relay.Run(context.Background())
+	fmt.Println("✅ Messages are served from MongoDB")
+	return dbmongo.NewMessageStore(mc, sqlite.NewMessageStore(d, true), relay)
 }
 
 // loadEnvFile loads simple KEY=VALUE pairs from a file into environment variables.
@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+// Attachment is a file sent with a message. The content is downloaded from
+// /api/attachments/{id}; SHA256 is the hex digest of the content.
+type Attachment struct {
+	ID         string    `json:"id"`
+	UploaderID int       `json:"uploader_id"`
+	Filename   string    `json:"filename"`
+	MimeType   string    `json:"mime_type"`
+	Size       int64     `json:"size"`
+	SHA256     string    `json:"sha256"`
+	CreatedAt  time.Time `json:"created_at"`
+}
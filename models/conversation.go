@@ -0,0 +1,47 @@
+package models
+
+import "time"
+
+// Conversation types and member roles, as stored in conversations.type and
+// conversation_members.role.
+const (
+	ConversationDirect = "direct"
+	ConversationGroup  = "group"
+
+	RoleOwner  = "owner"
+	RoleAdmin  = "admin"
+	RoleMember = "member"
+)
+
+// Conversation is a direct (two-member) or group chat. Role and UnreadCount are
+// from the point of view of the user who fetched it.
+type Conversation struct {
+	ID          int                  `json:"id"`
+	Type        string               `json:"type"`
+	Name        string               `json:"name,omitempty"`
+	CreatedBy   int                  `json:"created_by,omitempty"`
+	CreatedAt   time.Time            `json:"created_at"`
+	Role        string               `json:"role,omitempty"`
+	UnreadCount int                  `json:"unread_count"`
+	Members     []ConversationMember `json:"members"`
+}
+
+type ConversationMember struct {
+	UserID   int       `json:"user_id"`
+	Username string    `json:"username"`
+	Role     string    `json:"role"`
+	JoinedAt time.Time `json:"joined_at"`
+}
+
+type CreateConversationRequest struct {
+	Name      string `json:"name"`
+	MemberIDs []int  `json:"member_ids"`
+}
+
+type AddMemberRequest struct {
+	UserID int `json:"user_id"`
+}
+
+type UpdateMemberRequest struct {
+	Role string `json:"role"`
+}
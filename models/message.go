@@ -1,18 +1,133 @@
 package models
 
-import "time"
+import (
+	"strconv"
+	"time"
+)
 
 type Message struct {
-	ID          int       `json:"id"`
-	SenderID    int       `json:"sender_id"`
-	SenderName  string    `json:"sender_name"`
-	RecipientID int       `json:"recipient_id"`
-	Message     string    `json:"message"`
-	IsRead      bool      `json:"is_read"`
-	CreatedAt   time.Time `json:"created_at"`
+	ID             int       `json:"id"`
+	ConversationID int       `json:"conversation_id"`
+	SenderID       int       `json:"sender_id"`
+	SenderName     string    `json:"sender_name"`
+	RecipientID    int       `json:"recipient_id,omitempty"` // 0 for group messages
+	Message        string    `json:"message"`
+	IsRead         bool      `json:"is_read"`
+	CreatedAt      time.Time `json:"created_at"`
+
+	// EditedAt is set once the text has been changed. A deleted message stays as a
+	// tombstone: DeletedAt is set and the text and attachments are gone.
+	EditedAt  *time.Time `json:"edited_at,omitempty"`
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+
+	// ReplyToID is the earlier message this one quotes; ReplyTo previews it.
+	ReplyToID *int            `json:"reply_to_id,omitempty"`
+	ReplyTo   *MessagePreview `json:"reply_to,omitempty"`
+
+	Attachments []Attachment `json:"attachments,omitempty"`
+	Reactions   []Reaction   `json:"reactions,omitempty"`
+}
+
+// MessagePageRequest selects a page of a conversation. Before and After are message
+// ids taken from a previous page's cursor; with neither set the newest page is returned.
+type MessagePageRequest struct {
+	Before int
+	After  int
+	Limit  int
+}
+
+// MessagePage is one page of a conversation, oldest message first. NextCursor
+// continues in the same direction: older messages for the newest page and for
+// "before" pages, newer ones for "after" pages. It is empty at the end.
+type MessagePage struct {
+	Messages   []Message `json:"messages"`
+	NextCursor string    `json:"next_cursor,omitempty"`
+	HasMore    bool      `json:"has_more"`
+}
+
+// NewMessagePage builds a page from up to Limit+1 messages fetched in paging order:
+// newest first, or oldest first for After. The extra message only signals that
+// there are more.
+func NewMessagePage(fetched []Message, req MessagePageRequest) MessagePage {
+	page := MessagePage{Messages: fetched}
+	if len(fetched) > req.Limit {
+		page.Messages = fetched[:req.Limit]
+		page.HasMore = true
+	}
+	if page.HasMore {
+		page.NextCursor = strconv.Itoa(page.Messages[len(page.Messages)-1].ID)
+	}
+	if req.After == 0 {
+		for i, j := 0, len(page.Messages)-1; i < j; i, j = i+1, j-1 {
+			page.Messages[i], page.Messages[j] = page.Messages[j], page.Messages[i]
+		}
+	}
+	if page.Messages == nil {
+		page.Messages = []Message{}
+	}
+	return page
+}
+
+// PreviewLength is how many characters of a quoted message a preview keeps.
+const PreviewLength = 100
+
+// MessagePreview is the compact form of a quoted message shown above a reply.
+type MessagePreview struct {
+	ID         int    `json:"id"`
+	SenderID   int    `json:"sender_id"`
+	SenderName string `json:"sender_name"`
+	Message    string `json:"message"`
+	Truncated  bool   `json:"truncated,omitempty"`
+	Deleted    bool   `json:"deleted,omitempty"`
+}
+
+// Preview returns m's preview, with the text cut to PreviewLength characters.
+func (m Message) Preview() MessagePreview {
+	p := MessagePreview{ID: m.ID, SenderID: m.SenderID, SenderName: m.SenderName, Message: m.Message, Deleted: m.DeletedAt != nil}
+	if runes := []rune(m.Message); len(runes) > PreviewLength {
+		p.Message = string(runes[:PreviewLength]) + "…"
+		p.Truncated = true
+	}
+	return p
+}
+
+// Reaction aggregates one emoji's reactions on a message.
+type Reaction struct {
+	Emoji   string `json:"emoji"`
+	Count   int    `json:"count"`
+	UserIDs []int  `json:"user_ids"`
+}
+
+// MessageReaction is a single user's reaction to a message.
+type MessageReaction struct {
+	MessageID int       `json:"message_id"`
+	UserID    int       `json:"user_id"`
+	Username  string    `json:"username"`
+	Emoji     string    `json:"emoji"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type ReactionRequest struct {
+	Emoji string `json:"emoji"`
+}
+
+// MessageEdit is a previous version of an edited message's text.
+type MessageEdit struct {
+	MessageID int       `json:"message_id"`
+	Message   string    `json:"message"`
+	EditedAt  time.Time `json:"edited_at"`
+}
+
+type EditMessageRequest struct {
+	Message string `json:"message"`
 }
 
+// SendMessageRequest addresses a message to either a user (RecipientID) or a
+// conversation the sender belongs to (ConversationID).
 type SendMessageRequest struct {
-	RecipientID int    `json:"recipient_id"`
-	Message     string `json:"message"`
+	RecipientID    int      `json:"recipient_id,omitempty"`
+	ConversationID int      `json:"conversation_id,omitempty"`
+	Message        string   `json:"message"`
+	AttachmentIDs  []string `json:"attachment_ids,omitempty"`
+	ReplyToID      int      `json:"reply_to_id,omitempty"`
 }
@@ -0,0 +1,15 @@
+package models
+
+// OutboxEntry is a message queued for delivery to MongoDB.
+type OutboxEntry struct {
+	ID        int
+	MessageID int
+	Attempts  int
+}
+
+// OutboxBacklog summarizes the undelivered outbox entries.
+type OutboxBacklog struct {
+	Pending       int     `json:"pending"`
+	Retrying      int     `json:"retrying"` // pending entries that failed at least once
+	OldestSeconds float64 `json:"oldest_seconds"`
+}
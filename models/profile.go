@@ -0,0 +1,27 @@
+package models
+
+import "time"
+
+// Profile is a user's public face. Strangers only see id, username and the
+// avatar; the remaining fields are filled in for the user and their friends.
+type Profile struct {
+	ID          int        `json:"id"`
+	Username    string     `json:"username"`
+	AvatarColor string     `json:"avatar_color"`
+	AvatarID    string     `json:"-"`
+	AvatarURL   string     `json:"avatar_url,omitempty"`
+	AvatarThumb string     `json:"avatar_thumb_url,omitempty"`
+	Email       string     `json:"email,omitempty"`
+	Bio         string     `json:"bio,omitempty"`
+	Status      string     `json:"status,omitempty"`
+	LastSeenAt  *time.Time `json:"last_seen_at,omitempty"`
+	CreatedAt   *time.Time `json:"created_at,omitempty"`
+}
+
+// UpdateProfileRequest is a partial update: fields left out are unchanged, and an
+// empty string clears email or bio.
+type UpdateProfileRequest struct {
+	Email       *string `json:"email"`
+	Bio         *string `json:"bio"`
+	AvatarColor *string `json:"avatar_color"`
+}
@@ -0,0 +1,49 @@
+package models
+
+import (
+	"strconv"
+	"time"
+)
+
+// SearchRequest is a message search over the caller's conversations. FriendID,
+// ConversationID, From and To narrow it down; Before is a cursor from a previous
+// page. ConversationIDs lists the caller's conversations for stores that cannot
+// join against memberships.
+type SearchRequest struct {
+	Terms           []string
+	FriendID        int
+	ConversationID  int
+	ConversationIDs []int
+	From            *time.Time
+	To              *time.Time
+	Before          int
+	Limit           int
+}
+
+// SearchResult is a matching message with an HTML snippet of its text in which
+// the matched terms are wrapped in <mark>; everything else is escaped.
+type SearchResult struct {
+	Message
+	Snippet string `json:"snippet"`
+}
+
+// SearchPage is one page of search results, newest first.
+type SearchPage struct {
+	Results    []SearchResult `json:"results"`
+	NextCursor string         `json:"next_cursor,omitempty"`
+	HasMore    bool           `json:"has_more"`
+}
+
+// NewSearchPage builds a page from up to Limit+1 results, newest first.
+func NewSearchPage(fetched []SearchResult, limit int) SearchPage {
+	page := SearchPage{Results: fetched}
+	if len(fetched) > limit {
+		page.Results = fetched[:limit]
+		page.HasMore = true
+		page.NextCursor = strconv.Itoa(page.Results[limit-1].ID)
+	}
+	if page.Results == nil {
+		page.Results = []SearchResult{}
+	}
+	return page
+}
@@ -3,8 +3,11 @@ package models
 import "time"
 
 type User struct {
-	ID        int       `json:"id"`
-	Username  string    `json:"username"`
-	Password  string    `json:"password,omitempty"`
-	CreatedAt time.Time `json:"created_at"`
+	ID          int       `json:"id"`
+	Username    string    `json:"username"`
+	Password    string    `json:"password,omitempty"`
+	Email       string    `json:"email,omitempty"`
+	Bio         string    `json:"bio,omitempty"`
+	AvatarColor string    `json:"avatar_color,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
 }
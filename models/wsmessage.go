@@ -1,7 +1,23 @@
 package models
 
+// WSMessage is the envelope for every websocket frame in both directions.
+// Clients set ID on commands they send; the server echoes it on the matching
+// "ack" (result in Data) or "error" (reason in Error) frame. Persisted events
+// carry the recipient's Seq so a reconnecting client can ask for what it missed.
 type WSMessage struct {
+	ID          string      `json:"id,omitempty"`
+	Seq         int64       `json:"seq,omitempty"`
 	Type        string      `json:"type"`
 	Data        interface{} `json:"data"`
+	Error       string      `json:"error,omitempty"`
 	RecipientID int         `json:"recipient_id,omitempty"`
 }
+
+// UserEvent is a WSMessage addressed to one user, as passed between server instances.
+// Origin identifies the publishing instance, so a broker can skip its own events
+// when they come back from the shared channel.
+type UserEvent struct {
+	UserID  int       `json:"user_id"`
+	Message WSMessage `json:"message"`
+	Origin  string    `json:"origin,omitempty"`
+}
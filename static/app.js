@@ -1,9 +1,50 @@
 // Global variables
 let currentUser = null;
 let currentFriend = null;
+// The open group conversation; at most one of currentFriend and currentGroup is set
+let currentGroup = null;
+let groups = [];
 let ws = null;
 let friends = [];
 
+// Server-Sent Events fallback, used when WebSocket upgrades keep failing (e.g. behind proxies)
+let eventSource = null;
+let wsFailures = 0;
+const WS_MAX_FAILURES = 3;
+
+// Highest event seq received; sent on reconnect so the server replays what we missed
+let lastSeq = null;
+
+// Typing indicators: friends typing to us (user id -> expiry timer) and our own typing state
+const typingFriends = new Map();
+let typingTo = null;
+let typingSentAt = 0;
+let typingIdleTimer = null;
+const TYPING_REFRESH_MS = 2000;
+const TYPING_IDLE_MS = 3000;
+
+// Activity is reported at most this often so the server can tell online from away
+const HEARTBEAT_MS = 60000;
+let heartbeatSentAt = 0;
+
+// Files uploaded for the next message: [{ id, filename }]
+let pendingAttachments = [];
+
+// Message history is fetched a page at a time; olderCursor pages back from the oldest shown
+const MESSAGE_PAGE_SIZE = 50;
+let olderCursor = null;
+let loadingOlder = false;
+
+// The message the next message replies to, if any
+let replyingTo = null;
+
+// Emoji offered on each message; any emoji the server accepts can be sent
+const QUICK_REACTIONS = ['👍', '❤️', '😂'];
+
+// Commands sent over the socket wait here for their ack/error, keyed by request id
+const pendingRequests = new Map();
+let nextRequestId = 1;
+
 // Theme Management
 function initTheme() {
     const savedTheme = localStorage.getItem('chatTheme') || 'dark';
@@ -37,14 +78,34 @@ function updateThemeIcon(theme) {
     }
 }
 
+// apiFetch wraps fetch with the session token and logs out when the session is no longer valid
+async function apiFetch(url, options = {}) {
+    const headers = Object.assign({}, options.headers || {});
+    if (currentUser && currentUser.token) {
+        headers['Authorization'] = `Bearer ${currentUser.token}`;
+    }
+    const response = await fetch(url, Object.assign({}, options, { headers }));
+    if (response.status === 401 && currentUser) {
+        logout();
+    }
+    return response;
+}
+
 // Check if user is already logged in
 window.onload = function () {
     initTheme();
     const savedUser = localStorage.getItem('chatUser');
     if (savedUser) {
         currentUser = JSON.parse(savedUser);
+        if (!currentUser.token) {
+            // Saved before sessions existed; force a fresh login
+            currentUser = null;
+            localStorage.removeItem('chatUser');
+            return;
+        }
         showChat();
         loadFriends();
+        loadGroups();
         loadFriendRequests();
         connectWebSocket();
     }
@@ -146,6 +207,7 @@ async function login() {
             localStorage.setItem('chatUser', JSON.stringify(currentUser));
             showChat();
             loadFriends();
+            loadGroups();
             loadFriendRequests();
             connectWebSocket();
         } else {
@@ -159,13 +221,28 @@ async function login() {
 
 // Logout function
 function logout() {
+    if (currentUser && currentUser.token) {
+        // Best effort: revoke the session server-side
+        fetch('/api/logout', { method: 'POST', headers: { 'Authorization': `Bearer ${currentUser.token}` } }).catch(() => {});
+    }
     currentUser = null;
     currentFriend = null;
+    currentGroup = null;
+    groups = [];
+    lastSeq = null;
     localStorage.removeItem('chatUser');
     if (ws) {
         ws.close();
         ws = null;
     }
+    if (eventSource) {
+        eventSource.close();
+        eventSource = null;
+    }
+    wsFailures = 0;
+    resetTyping();
+    typingFriends.forEach(timer => clearTimeout(timer));
+    typingFriends.clear();
     document.getElementById('chat-container').style.display = 'none';
     document.getElementById('auth-container').style.display = 'flex';
     document.getElementById('messages').innerHTML = '';
@@ -174,6 +251,7 @@ function logout() {
     friends = [];
     const fl = document.getElementById('friends-list');
     if (fl) fl.innerHTML = '<div class="empty-state">Logged out. Please login.</div>';
+    document.getElementById('groups-list').innerHTML = '';
     const noChat = document.getElementById('no-chat-selected');
     if (noChat) noChat.style.display = 'block';
     const chatArea = document.getElementById('chat-area');
@@ -191,7 +269,7 @@ function showChat() {
 async function loadFriends() {
     try {
         console.log('Loading friends for user:', currentUser.user_id);
-        const response = await fetch(`/api/friends?user_id=${currentUser.user_id}`);
+        const response = await apiFetch('/api/friends');
         const data = await response.json();
 
         console.log('Friends API response:', data);
@@ -233,13 +311,10 @@ function displayFriends() {
         }
 
         friendItem.innerHTML = `
-            <div class="friend-avatar">
-                <svg viewBox="0 0 24 24" width="28" height="28">
-                    <path fill="currentColor" d="M12 2C6.48 2 2 6.48 2 12s4.48 10 10 10 10-4.48 10-10S17.52 2 12 2zm0 3c1.66 0 3 1.34 3 3s-1.34 3-3 3-3-1.34-3-3 1.34-3 3-3zm0 14.2c-2.5 0-4.71-1.28-6-3.22.03-1.99 4-3.08 6-3.08 1.99 0 5.97 1.09 6 3.08-1.29 1.94-3.5 3.22-6 3.22z"/>
-                </svg>
-            </div>
+            ${avatarHtml(friend, 28)}
             <div class="friend-info">
                 <span class="friend-name">${escapeHtml(friend.username)}</span>
+                <span class="friend-status ${friend.status}">${presenceLabel(friend)}</span>
             </div>
             ${friend.unread_count > 0 ? `<span class="unread-badge">${friend.unread_count}</span>` : ''}
         `;
@@ -249,73 +324,168 @@ function displayFriends() {
     });
 }
 
+// avatarHtml renders a user's avatar: their uploaded picture, or the default icon on their color
+function avatarHtml(user, iconSize) {
+    const style = user.avatar_color ? ` style="background:${escapeHtml(user.avatar_color)}"` : '';
+    if (user.avatar_thumb_url) {
+        return `<div class="friend-avatar"${style}><img src="${escapeHtml(user.avatar_thumb_url)}" alt=""></div>`;
+    }
+    return `
+            <div class="friend-avatar"${style}>
+                <svg viewBox="0 0 24 24" width="${iconSize}" height="${iconSize}">
+                    <path fill="currentColor" d="M12 2C6.48 2 2 6.48 2 12s4.48 10 10 10 10-4.48 10-10S17.52 2 12 2zm0 3c1.66 0 3 1.34 3 3s-1.34 3-3 3-3-1.34-3-3 1.34-3 3-3zm0 14.2c-2.5 0-4.71-1.28-6-3.22.03-1.99 4-3.08 6-3.08 1.99 0 5.97 1.09 6 3.08-1.29 1.94-3.5 3.22-6 3.22z"/>
+                </svg>
+            </div>`;
+}
+
+// presenceLabel describes a friend's status, e.g. "Online" or "Last seen 5m ago"
+function presenceLabel(friend) {
+    if (friend.status === 'online') return 'Online';
+    if (friend.status === 'away') return 'Away';
+    if (!friend.last_seen_at) return 'Offline';
+    const minutes = Math.floor((Date.now() - new Date(friend.last_seen_at)) / 60000);
+    if (minutes < 1) return 'Last seen just now';
+    if (minutes < 60) return `Last seen ${minutes}m ago`;
+    if (minutes < 24 * 60) return `Last seen ${Math.floor(minutes / 60)}h ago`;
+    return `Last seen ${new Date(friend.last_seen_at).toLocaleDateString()}`;
+}
+
 // Select a friend to chat with
 function selectFriend(friend) {
+    stopTyping();
+    setReplyTo(null);
     currentFriend = friend;
+    currentGroup = null;
     displayFriends();
+    displayGroups();
 
     document.getElementById('no-chat-selected').style.display = 'none';
     document.getElementById('chat-area').style.display = 'flex';
     document.getElementById('chat-friend-name').textContent = friend.username;
     const unfriendBtn = document.getElementById('unfriend-btn');
     if (unfriendBtn) unfriendBtn.style.display = 'inline-block';
+    document.getElementById('members-btn').style.display = 'none';
 
+    renderTypingIndicator();
     loadMessages();
 }
 
-// Load messages with a friend
+// messagesUrl is the history endpoint of the open conversation
+function messagesUrl() {
+    return currentGroup
+        ? `/api/conversations/${currentGroup.id}/messages`
+        : `/api/messages/${currentFriend.id}`;
+}
+
+// historyPage reads a history response as a page. The direct route answers the
+// first page with a plain array, which continues from its oldest message.
+function historyPage(data) {
+    if (!Array.isArray(data)) return data;
+    const more = data.length >= MESSAGE_PAGE_SIZE;
+    return { messages: data, next_cursor: more ? String(data[0].id) : '', has_more: more };
+}
+
+// Load messages of the open conversation
 async function loadMessages() {
-    if (!currentFriend) return;
+    if (!currentFriend && !currentGroup) return;
 
     try {
-        const response = await fetch(`/api/messages/${currentFriend.id}?user_id=${currentUser.user_id}`);
+        const response = await apiFetch(`${messagesUrl()}?limit=${MESSAGE_PAGE_SIZE}`);
         const data = await response.json();
 
         if (data.success && data.data) {
             const messagesDiv = document.getElementById('messages');
             messagesDiv.innerHTML = '';
-            data.data.forEach(message => {
+            const page = historyPage(data.data);
+            page.messages.forEach(message => {
                 displayMessage(message);
             });
+            olderCursor = page.next_cursor || null;
             scrollToBottom();
 
-            // Refresh friends list to update unread count
-            loadFriends();
+            // Refresh the sidebar to update unread counts
+            if (currentGroup) {
+                loadGroups();
+            } else {
+                loadFriends();
+            }
         }
     } catch (error) {
         console.error('Error loading messages:', error);
     }
 }
 
+// loadOlderMessages prepends the previous page when the history is scrolled to the top
+async function loadOlderMessages() {
+    if ((!currentFriend && !currentGroup) || !olderCursor || loadingOlder) return;
+    loadingOlder = true;
+    const url = messagesUrl();
+
+    try {
+        const response = await apiFetch(`${url}?limit=${MESSAGE_PAGE_SIZE}&before=${olderCursor}`);
+        const data = await response.json();
+        if (!data.success || (!currentFriend && !currentGroup) || messagesUrl() !== url) return;
+
+        // Keep the messages on screen where they are
+        const messagesDiv = document.getElementById('messages');
+        const previousHeight = messagesDiv.scrollHeight;
+        data.data.messages.slice().reverse().forEach(message => {
+            displayMessage(message, true);
+        });
+        messagesDiv.scrollTop += messagesDiv.scrollHeight - previousHeight;
+        olderCursor = data.data.next_cursor || null;
+    } catch (error) {
+        console.error('Error loading older messages:', error);
+    } finally {
+        loadingOlder = false;
+    }
+}
+
 // Send message
 async function sendMessage() {
-    if (!currentFriend) return;
+    if (!currentFriend && !currentGroup) return;
 
     const messageInput = document.getElementById('message-input');
     const message = messageInput.value.trim();
 
-    if (!message) return;
+    if (!message && pendingAttachments.length === 0) return;
 
-    try {
-        const response = await fetch('/api/messages', {
-            method: 'POST',
-            headers: { 'Content-Type': 'application/json' },
-            body: JSON.stringify({
-                sender_id: currentUser.user_id,
-                recipient_id: currentFriend.id,
-                message: message,
-            }),
-        });
-
-        const data = await response.json();
+    const payload = currentGroup
+        ? { conversation_id: currentGroup.id, message: message }
+        : { recipient_id: currentFriend.id, message: message };
+    if (pendingAttachments.length > 0) {
+        payload.attachment_ids = pendingAttachments.map(a => a.id);
+    }
+    if (replyingTo) {
+        payload.reply_to_id = replyingTo.id;
+    }
 
-        if (data.success) {
-            messageInput.value = '';
-            displayMessage(data.data);
-            scrollToBottom();
+    try {
+        let sent;
+        if (ws && ws.readyState === WebSocket.OPEN) {
+            sent = await wsRequest('send_message', payload);
         } else {
-            alert('Error sending message: ' + data.message);
+            const response = await apiFetch('/api/messages', {
+                method: 'POST',
+                headers: { 'Content-Type': 'application/json' },
+                body: JSON.stringify(payload),
+            });
+            const data = await response.json();
+            if (!data.success) {
+                alert('Error sending message: ' + data.message);
+                return;
+            }
+            sent = data.data;
         }
+
+        // The server ends our typing state when the message arrives
+        resetTyping();
+        messageInput.value = '';
+        pendingAttachments = [];
+        renderPendingAttachments();
+        setReplyTo(null);
+        displayMessage(sent);
+        scrollToBottom();
     } catch (error) {
         console.error('Error sending message:', error);
         alert('Network error. Please try again.');
@@ -329,16 +499,40 @@ function handleKeyPress(event) {
     }
 }
 
-// Display message
-function displayMessage(message) {
+// Display message, at the end of the conversation or (prepend) at its start
+function displayMessage(message, prepend) {
     const messagesDiv = document.getElementById('messages');
     const messageDiv = document.createElement('div');
     messageDiv.className = 'message';
+    messageDiv.dataset.messageId = message.id;
 
     if (currentUser && message.sender_id === currentUser.user_id) {
         messageDiv.classList.add('sent');
     }
 
+    renderMessage(messageDiv, message);
+    if (prepend) {
+        messagesDiv.prepend(messageDiv);
+    } else {
+        messagesDiv.appendChild(messageDiv);
+    }
+}
+
+// updateMessage re-renders a message already on screen after an edit or delete
+function updateMessage(message) {
+    const messageDiv = document.querySelector(`#messages [data-message-id="${message.id}"]`);
+    if (messageDiv) {
+        renderMessage(messageDiv, message);
+    }
+}
+
+// renderMessage fills a message element. Deleted messages keep their place as a
+// tombstone; the sender gets edit and delete buttons on their own messages.
+function renderMessage(messageDiv, message) {
+    const own = currentUser && message.sender_id === currentUser.user_id;
+    messageDiv.message = message;
+    messageDiv.classList.toggle('deleted', !!message.deleted_at);
+
     // Parse created_at robustly and format to local HH:MM.
     // Handle different formats sent by the server (ISO RFC3339 or SQL "YYYY-MM-DD HH:MM:SS").
     let time = '';
@@ -375,15 +569,283 @@ function displayMessage(message) {
         }
     }
 
+    let content = message.message ? `<div class="message-content">${escapeHtml(message.message)}</div>` : '';
+    if (message.deleted_at) {
+        content = '<div class="message-content message-tombstone">This message was deleted</div>';
+    }
+
+    const quote = message.reply_to ? `
+        <div class="message-quote" onclick="scrollToMessage(${message.reply_to.id})">
+            <span class="message-quote-sender">${escapeHtml(message.reply_to.sender_name)}</span>
+            ${message.reply_to.deleted ? '<em>Deleted message</em>' : escapeHtml(message.reply_to.message)}
+        </div>` : '';
+
     messageDiv.innerHTML = `
+        ${quote}
         <div class="message-header">
             <span class="message-sender">${escapeHtml(message.sender_name)}</span>
-            <span class="message-time">${time}</span>
+            <span class="message-time">${time}${message.edited_at && !message.deleted_at ? ' <span class="message-edited">(edited)</span>' : ''}</span>
         </div>
-        <div class="message-content">${escapeHtml(message.message)}</div>
+        ${content}
     `;
 
-    messagesDiv.appendChild(messageDiv);
+    if (message.deleted_at) return;
+
+    if (message.attachments && message.attachments.length > 0) {
+        messageDiv.appendChild(renderAttachments(message.attachments));
+    }
+
+    if (message.reactions && message.reactions.length > 0) {
+        messageDiv.appendChild(renderReactions(message));
+    }
+
+    const actions = document.createElement('div');
+    actions.className = 'message-actions';
+    const replyBtn = document.createElement('button');
+    replyBtn.textContent = '↩️';
+    replyBtn.title = 'Reply';
+    replyBtn.onclick = () => setReplyTo(message);
+    actions.appendChild(replyBtn);
+    QUICK_REACTIONS.forEach(emoji => {
+        const btn = document.createElement('button');
+        btn.textContent = emoji;
+        btn.title = `React with ${emoji}`;
+        btn.onclick = () => toggleReaction(message, emoji);
+        actions.appendChild(btn);
+    });
+    if (own) {
+        const editBtn = document.createElement('button');
+        editBtn.textContent = '✏️';
+        editBtn.title = 'Edit';
+        editBtn.onclick = () => editMessage(message);
+        const deleteBtn = document.createElement('button');
+        deleteBtn.textContent = '🗑️';
+        deleteBtn.title = 'Delete';
+        deleteBtn.onclick = () => deleteMessage(message);
+        actions.append(editBtn, deleteBtn);
+    }
+    messageDiv.appendChild(actions);
+}
+
+// setReplyTo picks the message the next message quotes (null to cancel)
+function setReplyTo(message) {
+    replyingTo = message;
+    const bar = document.getElementById('reply-bar');
+    if (!message) {
+        bar.innerHTML = '';
+        return;
+    }
+    bar.innerHTML = `
+        <span>Replying to <strong>${escapeHtml(message.sender_name)}</strong>: ${escapeHtml(message.message.slice(0, 100))}</span>
+        <button onclick="setReplyTo(null)" title="Cancel reply">&times;</button>
+    `;
+    document.getElementById('message-input').focus();
+}
+
+function scrollToMessage(id) {
+    const messageDiv = document.querySelector(`#messages [data-message-id="${id}"]`);
+    if (messageDiv) {
+        messageDiv.scrollIntoView({ behavior: 'smooth', block: 'center' });
+    }
+}
+
+// updateQuotes refreshes the previews of replies quoting a message that was just
+// edited or deleted
+function updateQuotes(message) {
+    document.querySelectorAll('#messages .message').forEach(messageDiv => {
+        const m = messageDiv.message;
+        if (!m || !m.reply_to || m.reply_to.id !== message.id) return;
+        const text = message.message || '';
+        renderMessage(messageDiv, {
+            ...m,
+            reply_to: {
+                ...m.reply_to,
+                message: text.length > 100 ? text.slice(0, 100) + '…' : text,
+                deleted: !!message.deleted_at,
+            },
+        });
+    });
+}
+
+// renderReactions shows one chip per emoji; our own reactions are highlighted and
+// clicking a chip toggles ours.
+function renderReactions(message) {
+    const container = document.createElement('div');
+    container.className = 'message-reactions';
+    message.reactions.forEach(r => {
+        const chip = document.createElement('button');
+        chip.className = 'reaction-chip';
+        if (r.user_ids.includes(currentUser.user_id)) chip.classList.add('mine');
+        chip.textContent = `${r.emoji} ${r.count}`;
+        chip.onclick = () => toggleReaction(message, r.emoji);
+        container.appendChild(chip);
+    });
+    return container;
+}
+
+async function toggleReaction(message, emoji) {
+    const existing = (message.reactions || []).find(r => r.emoji === emoji);
+    const add = !(existing && existing.user_ids.includes(currentUser.user_id));
+    try {
+        let reactions;
+        if (ws && ws.readyState === WebSocket.OPEN) {
+            reactions = await wsRequest(add ? 'add_reaction' : 'remove_reaction', { id: message.id, emoji });
+        } else {
+            const url = add
+                ? `/api/messages/${message.id}/reactions`
+                : `/api/messages/${message.id}/reactions/${encodeURIComponent(emoji)}`;
+            const response = await apiFetch(url, {
+                method: add ? 'POST' : 'DELETE',
+                headers: { 'Content-Type': 'application/json' },
+                body: add ? JSON.stringify({ emoji }) : undefined,
+            });
+            const data = await response.json();
+            if (!data.success) {
+                alert(data.message);
+                return;
+            }
+            reactions = data.data;
+        }
+        updateReactions(message.id, reactions);
+    } catch (error) {
+        alert(error.message || 'Network error. Please try again.');
+    }
+}
+
+// updateReactions re-renders a message on screen with new aggregated reactions
+function updateReactions(messageId, reactions) {
+    const messageDiv = document.querySelector(`#messages [data-message-id="${messageId}"]`);
+    if (messageDiv && messageDiv.message) {
+        renderMessage(messageDiv, { ...messageDiv.message, reactions });
+    }
+}
+
+async function editMessage(message) {
+    const text = prompt('Edit message', message.message);
+    if (text === null || text.trim() === message.message) return;
+    const updated = await changeMessage('edit_message', 'PATCH', { id: message.id, message: text.trim() });
+    if (updated) {
+        updateMessage(updated);
+        updateQuotes(updated);
+    }
+}
+
+async function deleteMessage(message) {
+    if (!confirm('Delete this message for everyone?')) return;
+    const updated = await changeMessage('delete_message', 'DELETE', { id: message.id });
+    if (updated) {
+        updateMessage(updated);
+        updateQuotes(updated);
+    }
+}
+
+// changeMessage runs an edit or delete over the socket when connected, otherwise
+// over HTTP, and returns the updated message.
+async function changeMessage(command, method, payload) {
+    try {
+        if (ws && ws.readyState === WebSocket.OPEN) {
+            return await wsRequest(command, payload);
+        }
+        const response = await apiFetch(`/api/messages/${payload.id}`, {
+            method,
+            headers: { 'Content-Type': 'application/json' },
+            body: method === 'PATCH' ? JSON.stringify({ message: payload.message }) : undefined,
+        });
+        const data = await response.json();
+        if (!data.success) {
+            alert(data.message);
+            return null;
+        }
+        return data.data;
+    } catch (error) {
+        alert(error.message || 'Network error. Please try again.');
+        return null;
+    }
+}
+
+// renderAttachments shows images inline and other files as download links. Downloads
+// need the session token, so content is fetched with apiFetch rather than linked directly.
+function renderAttachments(attachments) {
+    const container = document.createElement('div');
+    container.className = 'message-attachments';
+    attachments.forEach(a => {
+        if (a.mime_type.startsWith('image/')) {
+            const img = document.createElement('img');
+            img.alt = a.filename;
+            container.appendChild(img);
+            attachmentObjectURL(a.id).then(url => { img.src = url; }).catch(() => { img.alt = `${a.filename} (unavailable)`; });
+        } else {
+            const link = document.createElement('a');
+            link.href = '#';
+            link.textContent = `📎 ${a.filename} (${formatSize(a.size)})`;
+            link.onclick = (e) => {
+                e.preventDefault();
+                downloadAttachment(a);
+            };
+            container.appendChild(link);
+        }
+    });
+    return container;
+}
+
+async function attachmentObjectURL(id) {
+    const response = await apiFetch(`/api/attachments/${id}`);
+    if (!response.ok) throw new Error('download failed');
+    return URL.createObjectURL(await response.blob());
+}
+
+async function downloadAttachment(a) {
+    try {
+        const url = await attachmentObjectURL(a.id);
+        const link = document.createElement('a');
+        link.href = url;
+        link.download = a.filename;
+        link.click();
+        setTimeout(() => URL.revokeObjectURL(url), 10000);
+    } catch (e) {
+        alert('Could not download attachment');
+    }
+}
+
+function formatSize(bytes) {
+    if (bytes < 1024) return `${bytes} B`;
+    if (bytes < 1024 * 1024) return `${(bytes / 1024).toFixed(1)} KB`;
+    return `${(bytes / (1024 * 1024)).toFixed(1)} MB`;
+}
+
+// attachFiles uploads picked files; they are sent with the next message
+async function attachFiles(input) {
+    const files = Array.from(input.files);
+    input.value = '';
+    for (const file of files) {
+        const form = new FormData();
+        form.append('file', file);
+        try {
+            const response = await apiFetch('/api/attachments', { method: 'POST', body: form });
+            const data = await response.json();
+            if (data.success) {
+                pendingAttachments.push({ id: data.data.id, filename: data.data.filename });
+                renderPendingAttachments();
+            } else {
+                alert(`Could not attach ${file.name}: ${data.message}`);
+            }
+        } catch (e) {
+            console.error('Attachment upload error:', e);
+            alert('Network error. Please try again.');
+        }
+    }
+}
+
+function removePendingAttachment(id) {
+    pendingAttachments = pendingAttachments.filter(a => a.id !== id);
+    renderPendingAttachments();
+}
+
+function renderPendingAttachments() {
+    const container = document.getElementById('pending-attachments');
+    container.innerHTML = pendingAttachments.map(a => `
+        <span class="attachment-chip">📎 ${escapeHtml(a.filename)}<button onclick="removePendingAttachment('${a.id}')" title="Remove">&times;</button></span>
+    `).join('');
 }
 
 // Show add friend modal
@@ -413,7 +875,7 @@ async function searchUsers() {
 
     searchTimeout = setTimeout(async () => {
         try {
-            const response = await fetch(`/api/friends/search?q=${encodeURIComponent(query)}&user_id=${currentUser.user_id}`);
+            const response = await apiFetch(`/api/friends/search?q=${encodeURIComponent(query)}`);
             const data = await response.json();
 
             if (data.success && data.data) {
@@ -426,7 +888,8 @@ async function searchUsers() {
                         const resultItem = document.createElement('div');
                         resultItem.className = 'search-result-item';
                         resultItem.innerHTML = `
-                            <span>${escapeHtml(user.username)}</span>
+                            ${avatarHtml(user, 20)}
+                            <span class="search-result-name">${escapeHtml(user.username)}</span>
                             <button onclick="sendFriendRequest('${escapeHtml(user.username)}', this)">Add Friend</button>
                         `;
                         resultsDiv.appendChild(resultItem);
@@ -439,16 +902,86 @@ async function searchUsers() {
     }, 300);
 }
 
+// Message search
+let messageSearchCursor = null;
+
+function showMessageSearch() {
+    document.getElementById('message-search-modal').style.display = 'block';
+    document.getElementById('message-search-query').value = '';
+    document.getElementById('message-search-results').innerHTML = '';
+    document.getElementById('message-search-more').style.display = 'none';
+    document.getElementById('message-search-query').focus();
+}
+
+function closeMessageSearch() {
+    document.getElementById('message-search-modal').style.display = 'none';
+}
+
+// searchMessages runs the query typed so far, or (more) fetches its next page.
+// Snippets come from the server already escaped, with matches in <mark>.
+async function searchMessages(more) {
+    clearTimeout(searchTimeout);
+    const query = document.getElementById('message-search-query').value.trim();
+    const resultsDiv = document.getElementById('message-search-results');
+    const moreBtn = document.getElementById('message-search-more');
+
+    if (!query) {
+        resultsDiv.innerHTML = '';
+        moreBtn.style.display = 'none';
+        return;
+    }
+
+    searchTimeout = setTimeout(async () => {
+        const params = new URLSearchParams({ q: query });
+        if (more && messageSearchCursor) params.set('before', messageSearchCursor);
+        try {
+            const response = await apiFetch(`/api/messages/search?${params}`);
+            const data = await response.json();
+            if (!data.success) return;
+
+            if (!more) resultsDiv.innerHTML = '';
+            if (!more && data.data.results.length === 0) {
+                resultsDiv.innerHTML = '<p style="text-align:center; color: #999;">No messages found</p>';
+            }
+            data.data.results.forEach(result => {
+                const otherId = result.sender_id === currentUser.user_id ? result.recipient_id : result.sender_id;
+                const friend = result.recipient_id ? friends.find(f => f.id === otherId) : null;
+                const group = result.recipient_id ? null : groups.find(g => g.id === result.conversation_id);
+                const item = document.createElement('div');
+                item.className = 'search-result-item';
+                item.innerHTML = `
+                    <span class="search-result-name">${escapeHtml(result.sender_name)}</span>
+                    <span class="search-result-snippet">${result.snippet}</span>
+                `;
+                if (friend || group) {
+                    item.onclick = () => {
+                        closeMessageSearch();
+                        if (group) {
+                            selectGroup(group);
+                        } else {
+                            selectFriend(friend);
+                        }
+                    };
+                }
+                resultsDiv.appendChild(item);
+            });
+            messageSearchCursor = data.data.next_cursor || null;
+            moreBtn.style.display = messageSearchCursor ? 'block' : 'none';
+        } catch (error) {
+            console.error('Error searching messages:', error);
+        }
+    }, more ? 0 : 300);
+}
+
 // Send friend request
 async function sendFriendRequest(username, button) {
     const messageDiv = document.getElementById('add-friend-message');
 
     try {
-        const response = await fetch('/api/friends/request', {
+        const response = await apiFetch('/api/friends/request', {
             method: 'POST',
             headers: { 'Content-Type': 'application/json' },
             body: JSON.stringify({
-                user_id: currentUser.user_id,
                 username: username,
             }),
         });
@@ -477,7 +1010,7 @@ async function sendFriendRequest(username, button) {
 // Load friend requests
 async function loadFriendRequests() {
     try {
-        const response = await fetch(`/api/friends/requests?user_id=${currentUser.user_id}`);
+        const response = await apiFetch('/api/friends/requests');
         const data = await response.json();
 
         if (data.success && data.data) {
@@ -499,7 +1032,7 @@ async function showFriendRequests() {
     document.getElementById('requests-modal').style.display = 'block';
 
     try {
-        const response = await fetch(`/api/friends/requests?user_id=${currentUser.user_id}`);
+        const response = await apiFetch('/api/friends/requests');
         const data = await response.json();
 
         const requestsList = document.getElementById('requests-list');
@@ -538,7 +1071,7 @@ function closeRequests() {
 // Accept friend request
 async function acceptRequest(requestId) {
     try {
-        const response = await fetch(`/api/friends/accept/${requestId}`, {
+        const response = await apiFetch(`/api/friends/accept/${requestId}`, {
             method: 'POST',
         });
 
@@ -564,7 +1097,7 @@ async function acceptRequest(requestId) {
 // Reject friend request
 async function rejectRequest(requestId) {
     try {
-        const response = await fetch(`/api/friends/reject/${requestId}`, {
+        const response = await apiFetch(`/api/friends/reject/${requestId}`, {
             method: 'POST',
         });
 
@@ -586,46 +1119,41 @@ async function rejectRequest(requestId) {
     }
 }
 
+// wsRequest sends a command over the socket and resolves with the server's ack data
+function wsRequest(type, data) {
+    return new Promise((resolve, reject) => {
+        if (!ws || ws.readyState !== WebSocket.OPEN) {
+            reject(new Error('WebSocket not connected'));
+            return;
+        }
+        const id = `r${nextRequestId++}`;
+        pendingRequests.set(id, { resolve, reject });
+        ws.send(JSON.stringify({ id, type, data }));
+    });
+}
+
 // Connect WebSocket
 function connectWebSocket() {
     const protocol = window.location.protocol === 'https:' ? 'wss:' : 'ws:';
-    const wsUrl = `${protocol}//${window.location.host}/ws/${currentUser.user_id}`;
+    if (lastSeq === null) {
+        const saved = localStorage.getItem(`chatLastSeq:${currentUser.user_id}`);
+        lastSeq = saved !== null ? parseInt(saved, 10) : null;
+    }
+    const query = lastSeq !== null ? `?last_seq=${lastSeq}` : '';
+    const wsUrl = `${protocol}//${window.location.host}/ws${query}`;
 
-    ws = new WebSocket(wsUrl);
+    // The session token travels in the subprotocol header rather than the URL
+    ws = new WebSocket(wsUrl, ['bearer', currentUser.token]);
+    let opened = false;
 
     ws.onopen = function () {
         console.log('WebSocket connected');
+        opened = true;
+        wsFailures = 0;
     };
 
     ws.onmessage = function (event) {
-        const wsMessage = JSON.parse(event.data);
-
-        if (wsMessage.type === 'message') {
-            const message = wsMessage.data;
-
-            // If message is from current chat friend, display it
-            if (currentFriend && message.sender_id === currentFriend.id) {
-                displayMessage(message);
-                scrollToBottom();
-            }
-
-            // Refresh friends list to update unread count
-            loadFriends();
-        } else if (wsMessage.type === 'friend_request') {
-            loadFriendRequests();
-        } else if (wsMessage.type === 'friend_accepted') {
-            loadFriends();
-        } else if (wsMessage.type === 'friend_removed') {
-            // If the removed friend is currently open, clear chat
-            if (currentFriend && currentFriend.id === wsMessage.data.user_id) {
-                currentFriend = null;
-                document.getElementById('chat-area').style.display = 'none';
-                document.getElementById('no-chat-selected').style.display = 'block';
-                const unfriendBtn = document.getElementById('unfriend-btn');
-                if (unfriendBtn) unfriendBtn.style.display = 'none';
-            }
-            loadFriends();
-        }
+        handleServerEvent(JSON.parse(event.data));
     };
 
     ws.onerror = function (error) {
@@ -634,13 +1162,268 @@ function connectWebSocket() {
 
     ws.onclose = function () {
         console.log('WebSocket disconnected');
+        // Commands in flight will never be answered on this socket
+        pendingRequests.forEach(p => p.reject(new Error('WebSocket disconnected')));
+        pendingRequests.clear();
+        if (!currentUser) return;
+        if (!opened && ++wsFailures >= WS_MAX_FAILURES) {
+            // The upgrade itself keeps failing; switch to the event stream
+            console.log('WebSocket unavailable, falling back to Server-Sent Events');
+            ws = null;
+            connectEventStream();
+            return;
+        }
         // Attempt to reconnect after 3 seconds
-        if (currentUser) {
-            setTimeout(connectWebSocket, 3000);
+        setTimeout(connectWebSocket, 3000);
+    };
+}
+
+// connectEventStream receives the same events over SSE. EventSource reconnects by
+// itself and resumes through Last-Event-ID; commands then go over HTTP.
+function connectEventStream() {
+    const params = new URLSearchParams({ token: currentUser.token });
+    if (lastSeq !== null) params.set('last_seq', lastSeq);
+    eventSource = new EventSource(`/api/events?${params}`);
+
+    eventSource.onopen = function () {
+        console.log('Event stream connected');
+    };
+
+    eventSource.onmessage = function (event) {
+        handleServerEvent(JSON.parse(event.data));
+    };
+
+    eventSource.onerror = function () {
+        // CLOSED means the browser gave up (e.g. the session was refused); retry ourselves
+        if (eventSource && eventSource.readyState === EventSource.CLOSED) {
+            eventSource = null;
+            if (currentUser) {
+                setTimeout(connectEventStream, 3000);
+            }
         }
     };
 }
 
+// handleServerEvent applies one event pushed over the WebSocket or the event stream
+function handleServerEvent(wsMessage) {
+    if (wsMessage.seq) {
+        rememberSeq(wsMessage.seq);
+    }
+
+    if (wsMessage.type === 'ack' || wsMessage.type === 'error') {
+        const pending = pendingRequests.get(wsMessage.id);
+        if (pending) {
+            pendingRequests.delete(wsMessage.id);
+            if (wsMessage.type === 'ack') {
+                pending.resolve(wsMessage.data);
+            } else {
+                pending.reject(new Error(wsMessage.error));
+            }
+        }
+        return;
+    }
+
+    if (wsMessage.type === 'ready') {
+        rememberSeq(wsMessage.data.seq);
+        if (wsMessage.data.resync) {
+            // Missed events are gone; reload everything
+            loadFriends();
+            loadGroups();
+            loadFriendRequests();
+            loadMessages();
+        }
+        return;
+    }
+
+    if (wsMessage.type === 'message') {
+        const message = wsMessage.data;
+
+        // Group messages have no recipient
+        if (!message.recipient_id) {
+            if (currentGroup && message.conversation_id === currentGroup.id) {
+                displayMessage(message);
+                scrollToBottom();
+                markCurrentRead();
+            }
+            loadGroups();
+            return;
+        }
+
+        setFriendTyping(message.sender_id, false);
+
+        // If message is from current chat friend, display it and mark it read
+        if (currentFriend && message.sender_id === currentFriend.id) {
+            displayMessage(message);
+            scrollToBottom();
+            markCurrentRead();
+        }
+
+        // Refresh friends list to update unread count
+        loadFriends();
+    } else if (wsMessage.type === 'conversation_added') {
+        loadGroups();
+    } else if (wsMessage.type === 'conversation_removed') {
+        if (currentGroup && currentGroup.id === wsMessage.data.conversation_id) {
+            closeGroup();
+        }
+        loadGroups();
+    } else if (wsMessage.type === 'conversation_member_added' ||
+        wsMessage.type === 'conversation_member_removed' ||
+        wsMessage.type === 'conversation_member_updated') {
+        loadGroups();
+        if (currentGroup && currentGroup.id === wsMessage.data.conversation_id &&
+            document.getElementById('members-modal').style.display === 'block') {
+            showMembers();
+        }
+    } else if (wsMessage.type === 'message_edited' || wsMessage.type === 'message_deleted') {
+        updateMessage(wsMessage.data);
+        updateQuotes(wsMessage.data);
+    } else if (wsMessage.type === 'reaction') {
+        updateReactions(wsMessage.data.message_id, wsMessage.data.reactions);
+    } else if (wsMessage.type === 'presence') {
+        const friend = friends.find(f => f.id === wsMessage.data.user_id);
+        if (friend) {
+            friend.status = wsMessage.data.status;
+            friend.last_seen_at = wsMessage.data.last_seen_at;
+            displayFriends();
+        }
+    } else if (wsMessage.type === 'profile_updated') {
+        const profile = wsMessage.data;
+        const friend = friends.find(f => f.id === profile.id);
+        if (friend) {
+            Object.assign(friend, {
+                username: profile.username,
+                avatar_color: profile.avatar_color,
+                avatar_url: profile.avatar_url || null,
+                avatar_thumb_url: profile.avatar_thumb_url || null,
+            });
+            displayFriends();
+        }
+        if (currentFriend && currentFriend.id === profile.id) {
+            currentFriend.username = profile.username;
+            document.getElementById('chat-friend-name').textContent = profile.username;
+        }
+    } else if (wsMessage.type === 'typing_start') {
+        setFriendTyping(wsMessage.data.user_id, true, wsMessage.data.expires_in);
+    } else if (wsMessage.type === 'typing_stop') {
+        setFriendTyping(wsMessage.data.user_id, false);
+    } else if (wsMessage.type === 'friend_request') {
+        loadFriendRequests();
+    } else if (wsMessage.type === 'friend_accepted') {
+        loadFriends();
+    } else if (wsMessage.type === 'friend_removed') {
+        // If the removed friend is currently open, clear chat
+        if (currentFriend && currentFriend.id === wsMessage.data.user_id) {
+            currentFriend = null;
+            document.getElementById('chat-area').style.display = 'none';
+            document.getElementById('no-chat-selected').style.display = 'block';
+            const unfriendBtn = document.getElementById('unfriend-btn');
+            if (unfriendBtn) unfriendBtn.style.display = 'none';
+        }
+        loadFriends();
+    }
+}
+
+// setFriendTyping records whether a friend is typing to us. Starts expire by themselves
+// in case the matching stop never arrives.
+function setFriendTyping(userId, active, expiresIn) {
+    clearTimeout(typingFriends.get(userId));
+    typingFriends.delete(userId);
+    if (active) {
+        typingFriends.set(userId, setTimeout(() => setFriendTyping(userId, false), expiresIn || 5000));
+    }
+    renderTypingIndicator();
+}
+
+function renderTypingIndicator() {
+    const indicator = document.getElementById('typing-indicator');
+    indicator.textContent = currentFriend && typingFriends.has(currentFriend.id)
+        ? `${currentFriend.username} is typing…`
+        : '';
+}
+
+// handleTyping tells the open conversation that we are typing, refreshing at most
+// every TYPING_REFRESH_MS and stopping after TYPING_IDLE_MS without input
+function handleTyping() {
+    if (!currentFriend) return;
+    const input = document.getElementById('message-input');
+    if (!input.value.trim()) {
+        stopTyping();
+        return;
+    }
+    const now = Date.now();
+    if (typingTo !== currentFriend.id || now - typingSentAt >= TYPING_REFRESH_MS) {
+        typingTo = currentFriend.id;
+        typingSentAt = now;
+        sendTypingState(typingTo, true);
+    }
+    clearTimeout(typingIdleTimer);
+    typingIdleTimer = setTimeout(stopTyping, TYPING_IDLE_MS);
+}
+
+// stopTyping sends typing_stop if we told someone we were typing
+function stopTyping() {
+    if (typingTo !== null) {
+        sendTypingState(typingTo, false);
+    }
+    resetTyping();
+}
+
+function resetTyping() {
+    clearTimeout(typingIdleTimer);
+    typingIdleTimer = null;
+    typingTo = null;
+    typingSentAt = 0;
+}
+
+function sendTypingState(friendId, active) {
+    if (ws && ws.readyState === WebSocket.OPEN) {
+        wsRequest(active ? 'typing_start' : 'typing_stop', { friend_id: friendId }).catch(() => {});
+    } else {
+        apiFetch(`/api/messages/${friendId}/typing`, {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({ typing: active }),
+        }).catch(() => {});
+    }
+}
+
+// reportActivity sends a heartbeat when the user interacts with the page, throttled
+function reportActivity() {
+    if (!currentUser || Date.now() - heartbeatSentAt < HEARTBEAT_MS) return;
+    heartbeatSentAt = Date.now();
+    if (ws && ws.readyState === WebSocket.OPEN) {
+        wsRequest('heartbeat', {}).catch(() => {});
+    } else if (eventSource) {
+        apiFetch('/api/presence/heartbeat', { method: 'POST' }).catch(() => {});
+    }
+}
+
+['mousemove', 'keydown', 'click', 'focus'].forEach(type => {
+    window.addEventListener(type, reportActivity, { passive: true });
+});
+
+// markCurrentRead marks the open conversation read, over the socket when there is one
+function markCurrentRead() {
+    if (currentGroup) {
+        apiFetch(`/api/conversations/${currentGroup.id}/read`, { method: 'POST' }).catch(() => {});
+        return;
+    }
+    const friendId = currentFriend.id;
+    if (ws && ws.readyState === WebSocket.OPEN) {
+        wsRequest('mark_read', { friend_id: friendId }).catch(() => {});
+    } else {
+        apiFetch(`/api/messages/${friendId}/read`, { method: 'POST' }).catch(() => {});
+    }
+}
+
+// rememberSeq keeps the highest event seq seen, persisted per user
+function rememberSeq(seq) {
+    if (!currentUser || (lastSeq !== null && seq <= lastSeq)) return;
+    lastSeq = seq;
+    localStorage.setItem(`chatLastSeq:${currentUser.user_id}`, String(seq));
+}
+
 // Unfriend current friend
 async function unfriendCurrent() {
     if (!currentFriend || !currentUser) return;
@@ -648,7 +1431,7 @@ async function unfriendCurrent() {
     console.log('Unfriend - currentFriend.id:', currentFriend.id, 'type:', typeof currentFriend.id);
     if (!confirm(`Unfriend ${currentFriend.username}?`)) return;
     try {
-        const response = await fetch(`/api/friends/remove/${currentFriend.id}?user_id=${currentUser.user_id}`, { method: 'DELETE' });
+        const response = await apiFetch(`/api/friends/remove/${currentFriend.id}`, { method: 'DELETE' });
         const data = await response.json();
         if (data.success) {
             alert('Unfriended successfully');
@@ -667,6 +1450,236 @@ async function unfriendCurrent() {
     }
 }
 
+// GROUPS FEATURE
+
+// Load the groups the user belongs to
+async function loadGroups() {
+    try {
+        const response = await apiFetch('/api/conversations');
+        const data = await response.json();
+        if (data.success && data.data) {
+            groups = data.data.filter(c => c.type === 'group');
+            if (currentGroup) {
+                const open = groups.find(g => g.id === currentGroup.id);
+                if (open) currentGroup = open;
+            }
+            displayGroups();
+        }
+    } catch (error) {
+        console.error('Error loading groups:', error);
+    }
+}
+
+// Display groups in the sidebar, above friends
+function displayGroups() {
+    const groupsList = document.getElementById('groups-list');
+    groupsList.innerHTML = '';
+    groups.forEach(group => {
+        const groupItem = document.createElement('div');
+        groupItem.className = 'friend-item';
+        if (currentGroup && currentGroup.id === group.id) {
+            groupItem.classList.add('active');
+        }
+
+        groupItem.innerHTML = `
+            <div class="friend-avatar group-avatar">#</div>
+            <div class="friend-info">
+                <span class="friend-name">${escapeHtml(group.name)}</span>
+                <span class="friend-status">${group.members.length} members</span>
+            </div>
+            ${group.unread_count > 0 ? `<span class="unread-badge">${group.unread_count}</span>` : ''}
+        `;
+
+        groupItem.onclick = () => selectGroup(group);
+        groupsList.appendChild(groupItem);
+    });
+}
+
+// Open a group conversation
+function selectGroup(group) {
+    stopTyping();
+    setReplyTo(null);
+    currentFriend = null;
+    currentGroup = group;
+    displayFriends();
+    displayGroups();
+
+    document.getElementById('no-chat-selected').style.display = 'none';
+    document.getElementById('chat-area').style.display = 'flex';
+    document.getElementById('chat-friend-name').textContent = group.name;
+    document.getElementById('unfriend-btn').style.display = 'none';
+    document.getElementById('members-btn').style.display = 'inline-block';
+
+    renderTypingIndicator();
+    loadMessages();
+}
+
+// closeGroup leaves the open group's chat, e.g. after being removed from it
+function closeGroup() {
+    currentGroup = null;
+    document.getElementById('chat-area').style.display = 'none';
+    document.getElementById('no-chat-selected').style.display = 'block';
+    document.getElementById('members-btn').style.display = 'none';
+    document.getElementById('members-modal').style.display = 'none';
+}
+
+function showNewGroup() {
+    document.getElementById('new-group-modal').style.display = 'block';
+    document.getElementById('new-group-name').value = '';
+    document.getElementById('new-group-message').textContent = '';
+    const list = document.getElementById('new-group-friends');
+    list.innerHTML = friends.length === 0 ? '<div class="empty-state">Add friends to invite them</div>' : '';
+    friends.forEach(friend => {
+        const label = document.createElement('label');
+        label.className = 'group-friend-option';
+        label.innerHTML = `<input type="checkbox" value="${friend.id}"> ${escapeHtml(friend.username)}`;
+        list.appendChild(label);
+    });
+}
+
+function closeNewGroup() {
+    document.getElementById('new-group-modal').style.display = 'none';
+}
+
+// Create a group with the checked friends
+async function createGroup() {
+    const name = document.getElementById('new-group-name').value.trim();
+    const messageDiv = document.getElementById('new-group-message');
+    if (!name) {
+        messageDiv.textContent = 'Please enter a group name';
+        return;
+    }
+    const memberIds = Array.from(document.querySelectorAll('#new-group-friends input:checked'))
+        .map(input => parseInt(input.value, 10));
+
+    try {
+        const response = await apiFetch('/api/conversations', {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({ name: name, member_ids: memberIds }),
+        });
+        const data = await response.json();
+        if (!data.success) {
+            messageDiv.textContent = data.message;
+            return;
+        }
+        closeNewGroup();
+        await loadGroups();
+        selectGroup(groups.find(g => g.id === data.data.id) || data.data);
+    } catch (error) {
+        console.error('Error creating group:', error);
+        messageDiv.textContent = 'Network error. Please try again.';
+    }
+}
+
+// showMembers lists the open group's members with the actions our role allows
+async function showMembers() {
+    if (!currentGroup) return;
+    document.getElementById('members-modal').style.display = 'block';
+    document.getElementById('members-message').textContent = '';
+
+    const response = await apiFetch(`/api/conversations/${currentGroup.id}`);
+    const data = await response.json();
+    if (!data.success) {
+        document.getElementById('members-message').textContent = data.message;
+        return;
+    }
+    const group = data.data;
+    const list = document.getElementById('members-list');
+    list.innerHTML = '';
+    group.members.forEach(member => {
+        const item = document.createElement('div');
+        item.className = 'search-result-item';
+        item.innerHTML = `<span>${escapeHtml(member.username)} <small>${member.role}</small></span>`;
+
+        if (member.user_id !== currentUser.user_id) {
+            if (group.role === 'owner') {
+                const promote = member.role === 'admin' ? 'member' : 'admin';
+                item.appendChild(memberButton(promote === 'admin' ? 'Make admin' : 'Remove admin',
+                    () => updateMember(member.user_id, promote)));
+                item.appendChild(memberButton('Make owner', () => {
+                    if (confirm(`Make ${member.username} the owner? You will become an admin.`)) {
+                        updateMember(member.user_id, 'owner');
+                    }
+                }));
+            }
+            if (group.role === 'owner' || (group.role === 'admin' && member.role === 'member')) {
+                item.appendChild(memberButton('Remove', () => removeMember(member.user_id)));
+            }
+        }
+        list.appendChild(item);
+    });
+
+    // Owners and admins can invite friends who are not members yet
+    const invite = document.getElementById('members-invite');
+    invite.innerHTML = '';
+    if (group.role !== 'member') {
+        friends.filter(f => !group.members.some(m => m.user_id === f.id)).forEach(friend => {
+            const item = document.createElement('div');
+            item.className = 'search-result-item';
+            item.innerHTML = `<span>${escapeHtml(friend.username)}</span>`;
+            item.appendChild(memberButton('Add', () => addMember(friend.id)));
+            invite.appendChild(item);
+        });
+    }
+}
+
+function memberButton(label, onclick) {
+    const button = document.createElement('button');
+    button.textContent = label;
+    button.onclick = onclick;
+    return button;
+}
+
+function closeMembers() {
+    document.getElementById('members-modal').style.display = 'none';
+}
+
+// memberRequest runs a member change and refreshes the list, showing any error
+async function memberRequest(url, options) {
+    try {
+        const response = await apiFetch(url, options);
+        const data = await response.json();
+        if (!data.success) {
+            document.getElementById('members-message').textContent = data.message;
+            return false;
+        }
+        loadGroups();
+        return true;
+    } catch (error) {
+        console.error('Error updating members:', error);
+        document.getElementById('members-message').textContent = 'Network error. Please try again.';
+        return false;
+    }
+}
+
+async function addMember(userId) {
+    if (await memberRequest(`/api/conversations/${currentGroup.id}/members`, {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({ user_id: userId }),
+    })) showMembers();
+}
+
+async function removeMember(userId) {
+    if (await memberRequest(`/api/conversations/${currentGroup.id}/members/${userId}`, { method: 'DELETE' })) showMembers();
+}
+
+async function updateMember(userId, role) {
+    if (await memberRequest(`/api/conversations/${currentGroup.id}/members/${userId}`, {
+        method: 'PATCH',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({ role: role }),
+    })) showMembers();
+}
+
+async function leaveGroup() {
+    if (!currentGroup || !confirm(`Leave ${currentGroup.name}?`)) return;
+    if (await memberRequest(`/api/conversations/${currentGroup.id}/leave`, { method: 'POST' })) {
+        closeGroup();
+    }
+}
+
 // Scroll to bottom
 function scrollToBottom() {
     const messagesDiv = document.getElementById('messages');
@@ -690,6 +1703,9 @@ window.onclick = function (event) {
     const addFriendModal = document.getElementById('add-friend-modal');
     const requestsModal = document.getElementById('requests-modal');
     const settingsModal = document.getElementById('settings-modal');
+    const messageSearchModal = document.getElementById('message-search-modal');
+    const newGroupModal = document.getElementById('new-group-modal');
+    const membersModal = document.getElementById('members-modal');
 
     if (event.target === addFriendModal) {
         addFriendModal.style.display = 'none';
@@ -700,6 +1716,15 @@ window.onclick = function (event) {
     if (event.target === settingsModal) {
         settingsModal.style.display = 'none';
     }
+    if (event.target === messageSearchModal) {
+        messageSearchModal.style.display = 'none';
+    }
+    if (event.target === newGroupModal) {
+        newGroupModal.style.display = 'none';
+    }
+    if (event.target === membersModal) {
+        membersModal.style.display = 'none';
+    }
 };
 
 // SETTINGS FEATURE
@@ -712,6 +1737,99 @@ function showSettings() {
     const msg = document.getElementById('settings-message');
     msg.textContent = '';
     msg.className = 'modal-message';
+    const profileMsg = document.getElementById('profile-message');
+    profileMsg.textContent = '';
+    profileMsg.className = 'modal-message';
+    loadProfile();
+}
+
+// loadProfile fills the profile fields of the settings modal
+async function loadProfile() {
+    try {
+        const response = await apiFetch(`/api/users/${currentUser.user_id}/profile`);
+        const data = await response.json();
+        if (data.success) {
+            document.getElementById('settings-email').value = data.data.email || '';
+            document.getElementById('settings-bio').value = data.data.bio || '';
+            document.getElementById('settings-avatar-color').value = data.data.avatar_color || '#8774e1';
+            renderSettingsAvatar(data.data);
+        }
+    } catch (e) {
+        console.error('Error loading profile:', e);
+    }
+}
+
+async function updateProfile() {
+    if (!currentUser) return;
+    const msg = document.getElementById('profile-message');
+    msg.textContent = '';
+    msg.className = 'modal-message';
+
+    const payload = {
+        email: document.getElementById('settings-email').value.trim(),
+        bio: document.getElementById('settings-bio').value.trim(),
+        avatar_color: document.getElementById('settings-avatar-color').value,
+    };
+
+    try {
+        const response = await apiFetch(`/api/users/${currentUser.user_id}/profile`, {
+            method: 'PATCH',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify(payload)
+        });
+        const data = await response.json();
+        if (data.success) {
+            msg.classList.add('success');
+            msg.textContent = 'Profile updated';
+        } else {
+            msg.classList.add('error');
+            msg.textContent = data.message || 'Update failed';
+        }
+    } catch (e) {
+        console.error('Profile update error:', e);
+        msg.classList.add('error');
+        msg.textContent = 'Network error. Please try again.';
+    }
+}
+
+// uploadAvatar sends the picked file; the server validates and thumbnails it
+async function uploadAvatar(input) {
+    const file = input.files[0];
+    if (!file) return;
+    const form = new FormData();
+    form.append('avatar', file);
+    await saveAvatar({ method: 'POST', body: form });
+    input.value = '';
+}
+
+async function removeAvatar() {
+    await saveAvatar({ method: 'DELETE' });
+}
+
+async function saveAvatar(options) {
+    const msg = document.getElementById('profile-message');
+    msg.textContent = '';
+    msg.className = 'modal-message';
+    try {
+        const response = await apiFetch(`/api/users/${currentUser.user_id}/avatar`, options);
+        const data = await response.json();
+        if (data.success) {
+            msg.classList.add('success');
+            msg.textContent = 'Avatar updated';
+            renderSettingsAvatar(data.data);
+        } else {
+            msg.classList.add('error');
+            msg.textContent = data.message || 'Upload failed';
+        }
+    } catch (e) {
+        console.error('Avatar upload error:', e);
+        msg.classList.add('error');
+        msg.textContent = 'Network error. Please try again.';
+    }
+}
+
+function renderSettingsAvatar(profile) {
+    document.getElementById('settings-avatar-preview').innerHTML = avatarHtml(profile, 28);
 }
 
 function closeSettings() {
@@ -733,9 +1851,7 @@ async function updateSettings() {
         return;
     }
 
-    const payload = {
-        user_id: currentUser.user_id,
-    };
+    const payload = {};
     if (newUsername && newUsername !== currentUser.username) {
         payload.new_username = newUsername;
     }
@@ -755,7 +1871,7 @@ async function updateSettings() {
     }
 
     try {
-        const response = await fetch('/api/user/update', {
+        const response = await apiFetch('/api/user/update', {
             method: 'POST',
             headers: { 'Content-Type': 'application/json' },
             body: JSON.stringify(payload)
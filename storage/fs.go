@@ -0,0 +1,120 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// FSStore keeps blobs as files below a root directory. The content type is derived
+// from the key's extension, so keys should end in one (".jpg", ".png", ...).
+// Directories are created by Put and removed by Delete once empty.
+type FSStore struct {
+	root string
+	// dirs is held shared by Put and exclusively by Delete, so that Delete never
+	// removes a directory a Put is about to write into.
+	dirs sync.RWMutex
+}
+
+// NewFSStore returns a store rooted at dir, creating it if needed.
+func NewFSStore(dir string) (*FSStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &FSStore{root: filepath.Clean(dir)}, nil
+}
+
+// Put writes the blob to a temporary file and renames it into place, so readers
+// never see a partial file.
+func (s *FSStore) Put(ctx context.Context, key string, r io.Reader, contentType string) error {
+	p, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	s.dirs.RLock()
+	defer s.dirs.RUnlock()
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(p), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), p)
+}
+
+// Get opens the blob. The returned reader is an *os.File, so it also implements io.Seeker.
+func (s *FSStore) Get(ctx context.Context, key string) (io.ReadCloser, BlobInfo, error) {
+	p, err := s.path(key)
+	if err != nil {
+		return nil, BlobInfo{}, err
+	}
+
+	f, err := os.Open(p)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, BlobInfo{}, ErrNotFound
+	}
+	if err != nil {
+		return nil, BlobInfo{}, err
+	}
+	st, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, BlobInfo{}, err
+	}
+
+	contentType := mime.TypeByExtension(path.Ext(key))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	return f, BlobInfo{Size: st.Size(), ContentType: contentType, ModTime: st.ModTime()}, nil
+}
+
+// Delete removes the blob, then its directory and their parents below the root
+// as long as they are empty.
+func (s *FSStore) Delete(ctx context.Context, key string) error {
+	p, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	s.dirs.Lock()
+	defer s.dirs.Unlock()
+	err = os.Remove(p)
+	if errors.Is(err, fs.ErrNotExist) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	// Removing a directory that is not empty fails, which ends the walk
+	for dir := filepath.Dir(p); dir != s.root && os.Remove(dir) == nil; dir = filepath.Dir(dir) {
+	}
+	return nil
+}
+
+// path maps key into the root, refusing keys that would escape it.
+func (s *FSStore) path(key string) (string, error) {
+	clean := path.Clean("/" + key)[1:]
+	if clean == "" || clean != key || strings.Contains(key, "\\") {
+		return "", fmt.Errorf("invalid blob key %q", key)
+	}
+	return filepath.Join(s.root, filepath.FromSlash(clean)), nil
+}
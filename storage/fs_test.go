@@ -0,0 +1,53 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFSStoreDeleteRemovesEmptyDirectories(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "blobs")
+	s, err := NewFSStore(root + string(filepath.Separator))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	for _, key := range []string{"avatars/a/64.jpg", "avatars/a/256.jpg", "avatars/b/64.jpg"} {
+		if err := s.Put(ctx, key, strings.NewReader("x"), "image/jpeg"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	steps := []struct {
+		key  string
+		gone []string // directories that must no longer exist
+		kept []string // directories that must remain
+	}{
+		{"avatars/a/64.jpg", nil, []string{"avatars/a"}},
+		{"avatars/a/256.jpg", []string{"avatars/a"}, []string{"avatars/b"}},
+		{"avatars/b/64.jpg", []string{"avatars/b", "avatars"}, []string{"."}},
+	}
+	for _, step := range steps {
+		if err := s.Delete(ctx, step.key); err != nil {
+			t.Fatalf("delete %s: %v", step.key, err)
+		}
+		for _, dir := range step.gone {
+			if _, err := os.Stat(filepath.Join(root, dir)); !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("after deleting %s, %s: got %v, want it gone", step.key, dir, err)
+			}
+		}
+		for _, dir := range step.kept {
+			if _, err := os.Stat(filepath.Join(root, dir)); err != nil {
+				t.Errorf("after deleting %s, %s: %v", step.key, dir, err)
+			}
+		}
+	}
+
+	if err := s.Delete(ctx, "avatars/b/64.jpg"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("deleting again: got %v, want %v", err, ErrNotFound)
+	}
+}
@@ -0,0 +1,423 @@
+package storage
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"DB-Presentation/models"
+	"DB-Presentation/utils"
+)
+
+// MemoryMessageStore is a MessageStore held in memory, for tests. It knows nothing
+// about users or memberships: sender names come from SetUsername, group
+// conversation ids are taken as given, and direct conversations are numbered after
+// the highest conversation id seen so far. Attachments are not supported.
+// Messages are ordered by id.
+type MemoryMessageStore struct {
+	mu        sync.Mutex
+	messages  []models.Message // messages[i] has id i+1
+	direct    map[[2]int]int
+	lastConv  int
+	edits     map[int][]models.MessageEdit
+	reactions []models.MessageReaction
+	usernames map[int]string
+	lastRead  map[[2]int]int // {conversation, user} -> last read message id in a group
+}
+
+var _ MessageStore = (*MemoryMessageStore)(nil)
+
+// NewMemoryMessageStore returns an empty MemoryMessageStore.
+func NewMemoryMessageStore() *MemoryMessageStore {
+	return &MemoryMessageStore{
+		direct:    make(map[[2]int]int),
+		edits:     make(map[int][]models.MessageEdit),
+		usernames: make(map[int]string),
+		lastRead:  make(map[[2]int]int),
+	}
+}
+
+// SetUsername sets the sender_name of the user's messages and the username of their reactions.
+func (s *MemoryMessageStore) SetUsername(userID int, username string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.usernames[userID] = username
+}
+
+func (s *MemoryMessageStore) Insert(ctx context.Context, senderID int, req models.SendMessageRequest) (models.Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(req.AttachmentIDs) > 0 {
+		return models.Message{}, ErrAttachmentUnavailable
+	}
+	conversationID, recipientID := req.ConversationID, 0
+	if conversationID == 0 {
+		key := [2]int{senderID, req.RecipientID}
+		if key[0] > key[1] {
+			key[0], key[1] = key[1], key[0]
+		}
+		if s.direct[key] == 0 {
+			s.lastConv++
+			s.direct[key] = s.lastConv
+		}
+		conversationID, recipientID = s.direct[key], req.RecipientID
+	} else if conversationID > s.lastConv {
+		s.lastConv = conversationID
+	}
+
+	msg := models.Message{
+		ID:             len(s.messages) + 1,
+		ConversationID: conversationID,
+		SenderID:       senderID,
+		SenderName:     s.usernames[senderID],
+		RecipientID:    recipientID,
+		Message:        req.Message,
+		CreatedAt:      time.Now().UTC().Truncate(time.Second),
+	}
+	if req.ReplyToID != 0 {
+		quoted, ok := s.message(req.ReplyToID)
+		if !ok || quoted.ConversationID != conversationID {
+			return models.Message{}, ErrInvalidReply
+		}
+		replyTo := req.ReplyToID
+		msg.ReplyToID = &replyTo
+	}
+	s.messages = append(s.messages, msg)
+	return s.fill(msg), nil
+}
+
+func (s *MemoryMessageStore) Get(ctx context.Context, id int) (models.Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	msg, ok := s.message(id)
+	if !ok {
+		return models.Message{}, ErrMessageNotFound
+	}
+	return s.fill(msg), nil
+}
+
+func (s *MemoryMessageStore) List(ctx context.Context, userID, friendID int, req models.MessagePageRequest) (models.MessagePage, error) {
+	return s.page(func(m models.Message) bool {
+		return (m.SenderID == userID && m.RecipientID == friendID) || (m.SenderID == friendID && m.RecipientID == userID)
+	}, req)
+}
+
+func (s *MemoryMessageStore) ListConversation(ctx context.Context, conversationID int, req models.MessagePageRequest) (models.MessagePage, error) {
+	return s.page(func(m models.Message) bool { return m.ConversationID == conversationID }, req)
+}
+
+// page returns one page of the messages matching match, like the SQL stores do.
+func (s *MemoryMessageStore) page(match func(models.Message) bool, req models.MessagePageRequest) (models.MessagePage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cursor := req.Before
+	if req.After != 0 {
+		cursor = req.After
+	}
+	if cursor != 0 {
+		if m, ok := s.message(cursor); !ok || !match(m) {
+			return models.MessagePage{}, ErrInvalidCursor
+		}
+	}
+
+	var fetched []models.Message
+	if req.After != 0 {
+		for i := req.After; i < len(s.messages) && len(fetched) <= req.Limit; i++ {
+			if match(s.messages[i]) {
+				fetched = append(fetched, s.fill(s.messages[i]))
+			}
+		}
+	} else {
+		end := len(s.messages)
+		if req.Before != 0 {
+			end = req.Before - 1
+		}
+		for i := end - 1; i >= 0 && len(fetched) <= req.Limit; i-- {
+			if match(s.messages[i]) {
+				fetched = append(fetched, s.fill(s.messages[i]))
+			}
+		}
+	}
+	return models.NewMessagePage(fetched, req), nil
+}
+
+func (s *MemoryMessageStore) Replies(ctx context.Context, id int) ([]models.Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var replies []models.Message
+	for _, m := range s.messages {
+		if m.ReplyToID != nil && *m.ReplyToID == id {
+			replies = append(replies, s.fill(m))
+		}
+	}
+	return replies, nil
+}
+
+func (s *MemoryMessageStore) MarkRead(ctx context.Context, userID, friendID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, m := range s.messages {
+		if m.SenderID == friendID && m.RecipientID == userID {
+			s.messages[i].IsRead = true
+		}
+	}
+	return nil
+}
+
+func (s *MemoryMessageStore) MarkConversationRead(ctx context.Context, conversationID, userID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	last := 0
+	for _, m := range s.messages {
+		if m.ConversationID == conversationID {
+			last = m.ID
+		}
+	}
+	s.lastRead[[2]int{conversationID, userID}] = last
+	return nil
+}
+
+// CountUnread counts group messages in conversationIDs, which is taken as the
+// user's memberships.
+func (s *MemoryMessageStore) CountUnread(ctx context.Context, userID int, conversationIDs []int) (int, error) {
+	counts, err := s.UnreadCounts(ctx, userID)
+	total := 0
+	for _, n := range counts {
+		total += n
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, id := range conversationIDs {
+		last := s.lastRead[[2]int{id, userID}]
+		for _, m := range s.messages {
+			if m.ConversationID == id && m.RecipientID == 0 && m.ID > last && m.SenderID != userID && m.DeletedAt == nil {
+				total++
+			}
+		}
+	}
+	return total, err
+}
+
+func (s *MemoryMessageStore) UnreadCounts(ctx context.Context, userID int) (map[int]int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	counts := make(map[int]int)
+	for _, m := range s.messages {
+		if m.RecipientID == userID && !m.IsRead {
+			counts[m.SenderID]++
+		}
+	}
+	return counts, nil
+}
+
+func (s *MemoryMessageStore) Edit(ctx context.Context, id int, message string, editedAt time.Time) (models.Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	msg, ok := s.message(id)
+	if !ok {
+		return models.Message{}, ErrMessageNotFound
+	}
+	if msg.DeletedAt != nil {
+		return models.Message{}, ErrMessageDeleted
+	}
+	editedAt = editedAt.UTC()
+	s.edits[id] = append(s.edits[id], models.MessageEdit{MessageID: id, Message: msg.Message, EditedAt: editedAt})
+	msg.Message = message
+	msg.EditedAt = &editedAt
+	s.messages[id-1] = msg
+	return s.fill(msg), nil
+}
+
+// Delete always returns no attachment ids, since none are stored.
+func (s *MemoryMessageStore) Delete(ctx context.Context, id int, deletedAt time.Time) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	msg, ok := s.message(id)
+	if !ok {
+		return nil, ErrMessageNotFound
+	}
+	deletedAt = deletedAt.UTC()
+	msg.Message = ""
+	msg.DeletedAt = &deletedAt
+	s.messages[id-1] = msg
+	delete(s.edits, id)
+	s.reactions = s.filterReactions(func(r models.MessageReaction) bool { return r.MessageID != id })
+	return nil, nil
+}
+
+func (s *MemoryMessageStore) Edits(ctx context.Context, id int) ([]models.MessageEdit, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]models.MessageEdit{}, s.edits[id]...), nil
+}
+
+func (s *MemoryMessageStore) AddReaction(ctx context.Context, r models.MessageReaction) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, existing := range s.reactions {
+		if existing.MessageID == r.MessageID && existing.UserID == r.UserID && existing.Emoji == r.Emoji {
+			return false, nil
+		}
+	}
+	if r.Username == "" {
+		r.Username = s.usernames[r.UserID]
+	}
+	if r.CreatedAt.IsZero() {
+		r.CreatedAt = time.Now()
+	}
+	r.CreatedAt = r.CreatedAt.UTC()
+	s.reactions = append(s.reactions, r)
+	return true, nil
+}
+
+func (s *MemoryMessageStore) RemoveReaction(ctx context.Context, messageID, userID int, emoji string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := len(s.reactions)
+	s.reactions = s.filterReactions(func(r models.MessageReaction) bool {
+		return r.MessageID != messageID || r.UserID != userID || r.Emoji != emoji
+	})
+	return len(s.reactions) < n, nil
+}
+
+func (s *MemoryMessageStore) Reactions(ctx context.Context, messageID int) ([]models.MessageReaction, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	out := []models.MessageReaction{}
+	for _, r := range s.reactions {
+		if r.MessageID == messageID {
+			out = append(out, r)
+		}
+	}
+	return out, nil
+}
+
+func (s *MemoryMessageStore) ReactionCounts(ctx context.Context, messageID int) ([]models.Reaction, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.reactionCounts(messageID), nil
+}
+
+// Search matches every term as a case-insensitive substring. The caller's groups
+// must be listed in req.ConversationIDs; direct messages are found by sender and recipient.
+func (s *MemoryMessageStore) Search(ctx context.Context, userID int, req models.SearchRequest) (models.SearchPage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	member := func(m models.Message) bool {
+		if m.RecipientID != 0 && (m.SenderID == userID || m.RecipientID == userID) {
+			return true
+		}
+		for _, id := range req.ConversationIDs {
+			if m.ConversationID == id {
+				return true
+			}
+		}
+		return false
+	}
+	end := len(s.messages)
+	if req.Before != 0 {
+		if m, ok := s.message(req.Before); !ok || !member(m) {
+			return models.SearchPage{}, ErrInvalidCursor
+		}
+		end = req.Before - 1
+	}
+
+	var results []models.SearchResult
+	for i := end - 1; i >= 0 && len(results) <= req.Limit; i-- {
+		m := s.messages[i]
+		if !member(m) || m.DeletedAt != nil || !containsAll(m.Message, req.Terms) {
+			continue
+		}
+		if req.FriendID != 0 && (m.RecipientID == 0 || (m.SenderID != req.FriendID && m.RecipientID != req.FriendID)) {
+			continue
+		}
+		if req.ConversationID != 0 && m.ConversationID != req.ConversationID {
+			continue
+		}
+		if (req.From != nil && m.CreatedAt.Before(*req.From)) || (req.To != nil && !m.CreatedAt.Before(*req.To)) {
+			continue
+		}
+		msg := s.fill(m)
+		results = append(results, models.SearchResult{Message: msg, Snippet: utils.HighlightSnippet(msg.Message, req.Terms)})
+	}
+	return models.NewSearchPage(results, req.Limit), nil
+}
+
+// message returns the stored message with the given id. The caller holds s.mu.
+func (s *MemoryMessageStore) message(id int) (models.Message, bool) {
+	if id < 1 || id > len(s.messages) {
+		return models.Message{}, false
+	}
+	return s.messages[id-1], true
+}
+
+// fill adds reactions and the reply preview to a copy of msg. The caller holds s.mu.
+func (s *MemoryMessageStore) fill(msg models.Message) models.Message {
+	msg.Reactions = s.reactionCounts(msg.ID)
+	if msg.ReplyToID != nil {
+		if quoted, ok := s.message(*msg.ReplyToID); ok {
+			p := quoted.Preview()
+			msg.ReplyTo = &p
+		}
+	}
+	return msg
+}
+
+// reactionCounts aggregates a message's reactions per emoji. The caller holds s.mu.
+func (s *MemoryMessageStore) reactionCounts(messageID int) []models.Reaction {
+	var counts []models.Reaction
+	for _, r := range s.reactions {
+		if r.MessageID != messageID {
+			continue
+		}
+		found := false
+		for i := range counts {
+			if counts[i].Emoji == r.Emoji {
+				counts[i].Count++
+				counts[i].UserIDs = append(counts[i].UserIDs, r.UserID)
+				found = true
+				break
+			}
+		}
+		if !found {
+			counts = append(counts, models.Reaction{Emoji: r.Emoji, Count: 1, UserIDs: []int{r.UserID}})
+		}
+	}
+	return counts
+}
+
+// filterReactions returns the reactions for which keep is true. The caller holds s.mu.
+func (s *MemoryMessageStore) filterReactions(keep func(models.MessageReaction) bool) []models.MessageReaction {
+	var out []models.MessageReaction
+	for _, r := range s.reactions {
+		if keep(r) {
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+func containsAll(text string, terms []string) bool {
+	text = strings.ToLower(text)
+	for _, t := range terms {
+		if !strings.Contains(text, t) {
+			return false
+		}
+	}
+	return true
+}
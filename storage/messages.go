@@ -0,0 +1,60 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"DB-Presentation/models"
+)
+
+var (
+	// ErrMessageNotFound is returned for message ids that do not exist.
+	ErrMessageNotFound = errors.New("message not found")
+	// ErrMessageDeleted is returned when editing a message that was deleted.
+	ErrMessageDeleted = errors.New("message was deleted")
+	// ErrInvalidCursor is returned for page cursors outside the requested conversation.
+	ErrInvalidCursor = errors.New("invalid cursor")
+	// ErrInvalidReply is returned when reply_to_id is not a message in the same conversation.
+	ErrInvalidReply = errors.New("reply must quote a message in the same conversation")
+	// ErrAttachmentUnavailable is returned when a message references attachments that
+	// do not exist, belong to someone else or were already sent.
+	ErrAttachmentUnavailable = errors.New("attachment not found or already sent")
+)
+
+// MessageStore persists messages with their read state, edits and reactions.
+// Memberships, users and friendships are not part of it; callers check access
+// before using a store.
+//
+// Insert sends to req.ConversationID when it is set (a group) and otherwise to the
+// direct conversation with req.RecipientID. Direct read state is per message:
+// MarkRead marks what friendID sent to userID as read, and UnreadCounts (keyed by
+// sender) counts what is still unread. Group read state is each member's last read
+// message, moved forward by MarkConversationRead. CountUnread totals both;
+// conversationIDs lists the user's conversations for stores that cannot join
+// against memberships.
+type MessageStore interface {
+	Insert(ctx context.Context, senderID int, req models.SendMessageRequest) (models.Message, error)
+	Get(ctx context.Context, id int) (models.Message, error)
+	List(ctx context.Context, userID, friendID int, req models.MessagePageRequest) (models.MessagePage, error)
+	ListConversation(ctx context.Context, conversationID int, req models.MessagePageRequest) (models.MessagePage, error)
+	Replies(ctx context.Context, id int) ([]models.Message, error)
+
+	MarkRead(ctx context.Context, userID, friendID int) error
+	MarkConversationRead(ctx context.Context, conversationID, userID int) error
+	CountUnread(ctx context.Context, userID int, conversationIDs []int) (int, error)
+	UnreadCounts(ctx context.Context, userID int) (map[int]int, error)
+
+	// Delete returns the ids of the attachments the message had, for removal from blob storage.
+	Edit(ctx context.Context, id int, message string, editedAt time.Time) (models.Message, error)
+	Delete(ctx context.Context, id int, deletedAt time.Time) ([]string, error)
+	Edits(ctx context.Context, id int) ([]models.MessageEdit, error)
+
+	// AddReaction and RemoveReaction report whether anything changed.
+	AddReaction(ctx context.Context, r models.MessageReaction) (bool, error)
+	RemoveReaction(ctx context.Context, messageID, userID int, emoji string) (bool, error)
+	Reactions(ctx context.Context, messageID int) ([]models.MessageReaction, error)
+	ReactionCounts(ctx context.Context, messageID int) ([]models.Reaction, error)
+
+	Search(ctx context.Context, userID int, req models.SearchRequest) (models.SearchPage, error)
+}
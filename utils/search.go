@@ -0,0 +1,97 @@
+package utils
+
+import (
+	"html"
+	"strings"
+	"unicode"
+)
+
+const (
+	// maxSearchTerms caps how many words of a query are used.
+	maxSearchTerms = 10
+
+	// snippetContext is how many characters are kept on each side of the first match.
+	snippetContext = 40
+)
+
+// SearchTerms splits a search query into lowercase words, dropping punctuation
+// and duplicates, so it can be passed safely to any search backend.
+func SearchTerms(q string) []string {
+	words := strings.FieldsFunc(strings.ToLower(q), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	seen := make(map[string]bool)
+	var terms []string
+	for _, w := range words {
+		if seen[w] {
+			continue
+		}
+		seen[w] = true
+		terms = append(terms, w)
+		if len(terms) == maxSearchTerms {
+			break
+		}
+	}
+	return terms
+}
+
+// HighlightSnippet returns an HTML-escaped excerpt of text around the first match
+// of any term, with every match wrapped in <mark>. Matching ignores case.
+func HighlightSnippet(text string, terms []string) string {
+	runes := []rune(text)
+	lower := []rune(strings.ToLower(text))
+	if len(lower) != len(runes) {
+		// Lowercasing changed the length; fall back to matching the text as is
+		lower = runes
+	}
+
+	// marked[i] is true for runes inside a match
+	marked := make([]bool, len(runes))
+	first := -1
+	for _, term := range terms {
+		t := []rune(term)
+		for i := 0; i+len(t) <= len(lower); i++ {
+			if string(lower[i:i+len(t)]) != term {
+				continue
+			}
+			for j := i; j < i+len(t); j++ {
+				marked[j] = true
+			}
+			if first == -1 || i < first {
+				first = i
+			}
+		}
+	}
+
+	start, end := 0, len(runes)
+	if first > snippetContext {
+		start = first - snippetContext
+	}
+	if first >= 0 && first+snippetContext*2 < end {
+		end = first + snippetContext*2
+	} else if first < 0 && snippetContext*2 < end {
+		end = snippetContext * 2
+	}
+
+	var b strings.Builder
+	if start > 0 {
+		b.WriteString("…")
+	}
+	for i := start; i < end; {
+		j := i
+		for j < end && marked[j] == marked[i] {
+			j++
+		}
+		chunk := html.EscapeString(string(runes[i:j]))
+		if marked[i] {
+			b.WriteString("<mark>" + chunk + "</mark>")
+		} else {
+			b.WriteString(chunk)
+		}
+		i = j
+	}
+	if end < len(runes) {
+		b.WriteString("…")
+	}
+	return b.String()
+}
@@ -0,0 +1,109 @@
+package ws
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"DB-Presentation/models"
+)
+
+// typeSessionRevoked is an internal broker event telling every instance to close
+// sockets of a revoked session. It is never sent to clients.
+const typeSessionRevoked = "session_revoked"
+
+// Broker carries user-targeted events between server instances. Every event
+// published by any instance, including this one, must reach the handlers passed to
+// Subscribe exactly once; the hub only delivers to local sockets from there. The
+// publishing instance's handlers must get the event even when Publish returns an
+// error, which only means other instances may have missed it.
+type Broker interface {
+	Publish(ctx context.Context, ev models.UserEvent) error
+	Subscribe(handler func(models.UserEvent)) error
+	Close() error
+}
+
+var brokerMu sync.RWMutex
+var broker Broker = NewLocalBroker()
+
+// SetBroker replaces the broker (the default is a LocalBroker) and subscribes the
+// local hub to it. Call it during startup, before serving connections.
+func SetBroker(b Broker) error {
+	if err := b.Subscribe(deliverEvent); err != nil {
+		return err
+	}
+
+	brokerMu.Lock()
+	old := broker
+	broker = b
+	brokerMu.Unlock()
+
+	return old.Close()
+}
+
+// publish hands ev to the broker, which also delivers it to local sockets.
+func publish(ev models.UserEvent) {
+	brokerMu.RLock()
+	b := broker
+	brokerMu.RUnlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := b.Publish(ctx, ev); err != nil {
+		log.Printf("warning: broker publish failed, other instances may miss the event: %v", err)
+	}
+}
+
+// deliverEvent is the hub's broker subscription.
+func deliverEvent(ev models.UserEvent) {
+	if ev.Message.Type == typeSessionRevoked {
+		if sessionID, ok := ev.Message.Data.(string); ok {
+			for _, c := range clients.clientsWithSession(sessionID) {
+				c.close(websocket.ClosePolicyViolation, "session revoked")
+			}
+		}
+		return
+	}
+	clients.broadcast(ev.UserID, ev.Message)
+}
+
+// LocalBroker delivers events to subscribers in the same process. It is the
+// default and is enough for a single server instance.
+type LocalBroker struct {
+	mu       sync.RWMutex
+	handlers []func(models.UserEvent)
+}
+
+// NewLocalBroker returns an in-process broker with no subscribers.
+func NewLocalBroker() *LocalBroker {
+	return &LocalBroker{}
+}
+
+// Publish calls every subscriber synchronously, preserving publish order.
+func (b *LocalBroker) Publish(ctx context.Context, ev models.UserEvent) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for _, h := range b.handlers {
+		h(ev)
+	}
+	return nil
+}
+
+// Subscribe adds a handler for every subsequently published event.
+func (b *LocalBroker) Subscribe(handler func(models.UserEvent)) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.handlers = append(b.handlers, handler)
+	return nil
+}
+
+// Close drops all subscribers.
+func (b *LocalBroker) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.handlers = nil
+	return nil
+}
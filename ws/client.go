@@ -0,0 +1,294 @@
+package ws
+
+import (
+	"context"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"DB-Presentation/models"
+)
+
+const (
+	// sendBufferSize is how many outbound messages may queue per connection before
+	// the connection is treated as a slow consumer and dropped.
+	sendBufferSize = 64
+
+	// writeWait bounds a single write to the peer.
+	writeWait = 10 * time.Second
+
+	// maxMessageSize caps inbound frames; clients only send small control messages.
+	maxMessageSize = 64 * 1024
+)
+
+// gapWait is how long a connection holds back events that arrived ahead of a
+// missing seq before reading the missing ones from the event log.
+var gapWait = 2 * time.Second
+
+// client is a single authenticated connection: a websocket, or an SSE stream when
+// conn is nil. A user may hold several (one per tab or device); each gets its own
+// id so it can unregister itself cleanly. All writes happen on one goroutine per
+// client (writePump, or the SSE handler).
+type client struct {
+	id        uint64
+	userID    int
+	sessionID string
+	conn      *websocket.Conn
+
+	send      chan models.WSMessage
+	done      chan struct{}
+	closeOnce sync.Once
+
+	// While replaying missed events, live events are held back so that they are
+	// delivered after the replay and in sequence order.
+	replayMu  sync.Mutex
+	replaying bool
+	held      []models.WSMessage
+
+	// Afterwards, logged events are delivered in seq order: instances publish them
+	// in whatever order their appends finish, so an event may arrive ahead of the
+	// one before it. It waits in pending until that one arrives or gapTimer fills
+	// the gap from the log. lastSeq is the last seq delivered, -1 if unknown.
+	lastSeq  int64
+	pending  []models.WSMessage
+	gapTimer *time.Timer
+}
+
+func newClient(userID int, sessionID string, conn *websocket.Conn) *client {
+	return &client{
+		userID:    userID,
+		sessionID: sessionID,
+		conn:      conn,
+		send:      make(chan models.WSMessage, sendBufferSize),
+		done:      make(chan struct{}),
+		replaying: true,
+	}
+}
+
+// deliver queues a live event, or holds it back while a replay is in progress.
+func (c *client) deliver(msg models.WSMessage) bool {
+	c.replayMu.Lock()
+	defer c.replayMu.Unlock()
+	if c.replaying {
+		c.held = append(c.held, msg)
+		return true
+	}
+	return c.deliverInOrder(msg)
+}
+
+// deliverInOrder queues msg once every logged event before it has been queued,
+// dropping events already delivered. Events without a seq are queued at once.
+// The caller holds replayMu.
+func (c *client) deliverInOrder(msg models.WSMessage) bool {
+	switch {
+	case msg.Seq == 0:
+		return c.enqueue(msg)
+	case c.lastSeq < 0:
+		c.lastSeq = msg.Seq
+		return c.enqueue(msg)
+	case msg.Seq <= c.lastSeq:
+		return true
+	case msg.Seq > c.lastSeq+1:
+		i := sort.Search(len(c.pending), func(i int) bool { return c.pending[i].Seq >= msg.Seq })
+		if i < len(c.pending) && c.pending[i].Seq == msg.Seq {
+			return true
+		}
+		c.pending = append(c.pending, models.WSMessage{})
+		copy(c.pending[i+1:], c.pending[i:])
+		c.pending[i] = msg
+		if c.gapTimer == nil {
+			c.gapTimer = time.AfterFunc(gapWait, c.fillGap)
+		}
+		return true
+	}
+
+	if !c.enqueue(msg) {
+		return false
+	}
+	c.lastSeq = msg.Seq
+	for len(c.pending) > 0 && c.pending[0].Seq <= c.lastSeq+1 {
+		next := c.pending[0]
+		c.pending = c.pending[1:]
+		if next.Seq <= c.lastSeq {
+			continue
+		}
+		if !c.enqueue(next) {
+			return false
+		}
+		c.lastSeq = next.Seq
+	}
+	if len(c.pending) == 0 && c.gapTimer != nil {
+		c.gapTimer.Stop()
+		c.gapTimer = nil
+	}
+	return true
+}
+
+// fillGap runs once events have waited gapWait for a missing seq. It queues the
+// missing events from the event log, then the pending ones; seqs the log does not
+// have (a failed or pruned append) are skipped.
+func (c *client) fillGap() {
+	c.replayMu.Lock()
+	from := c.lastSeq
+	var upTo int64
+	if len(c.pending) > 0 {
+		upTo = c.pending[len(c.pending)-1].Seq
+	}
+	c.replayMu.Unlock()
+
+	var missing []models.WSMessage
+	if upTo > from {
+		ctx, cancel := context.WithTimeout(context.Background(), eventTimeout)
+		events, err := eventLog().Since(ctx, c.userID, from, int(upTo-from))
+		cancel()
+		if err != nil {
+			log.Printf("warning: could not read missing events for user %d: %v", c.userID, err)
+		}
+		missing = events
+	}
+
+	c.replayMu.Lock()
+	defer c.replayMu.Unlock()
+	c.gapTimer = nil
+	queue := append(missing, c.pending...)
+	sort.SliceStable(queue, func(i, j int) bool { return queue[i].Seq < queue[j].Seq })
+	c.pending = nil
+	for _, msg := range queue {
+		if msg.Seq <= c.lastSeq {
+			continue
+		}
+		if !c.enqueue(msg) {
+			log.Printf("User %d (conn %d) is not keeping up, disconnecting", c.userID, c.id)
+			c.close(websocket.CloseTryAgainLater, "slow consumer")
+			return
+		}
+		c.lastSeq = msg.Seq
+	}
+}
+
+// sendWait queues msg, waiting for buffer space instead of failing. Only used
+// during replay, before the client's commands are being read.
+func (c *client) sendWait(msg models.WSMessage) bool {
+	select {
+	case c.send <- msg:
+		return true
+	case <-c.done:
+		return false
+	}
+}
+
+// finishReplay flushes held live events newer than lastSeq (-1 if the log could
+// not be read) and switches the client to direct delivery. Events without a seq
+// (transient ones) are always flushed.
+func (c *client) finishReplay(lastSeq int64) bool {
+	c.replayMu.Lock()
+	defer c.replayMu.Unlock()
+
+	c.lastSeq = lastSeq
+	for _, msg := range c.held {
+		if !c.deliverInOrder(msg) {
+			return false
+		}
+	}
+	c.held = nil
+	c.replaying = false
+	return true
+}
+
+// enqueue queues msg for delivery without blocking. It returns false when the
+// buffer is full; messages for an already closed client are silently dropped.
+func (c *client) enqueue(msg models.WSMessage) bool {
+	select {
+	case <-c.done:
+		return true
+	default:
+	}
+
+	select {
+	case c.send <- msg:
+		return true
+	default:
+		return false
+	}
+}
+
+// close sends a close frame (best effort) and tears down the connection. The send
+// channel is never closed so concurrent enqueue calls cannot panic; done signals
+// the writer instead. Safe to call more than once and from any goroutine.
+// SSE clients have no frame to send; closing done ends their stream.
+func (c *client) close(code int, reason string) {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		if c.conn == nil {
+			return
+		}
+		msg := websocket.FormatCloseMessage(code, reason)
+		_ = c.conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+		c.conn.Close()
+	})
+}
+
+// readPump consumes inbound frames until the connection fails or goes quiet for
+// longer than idleTimeout. Every frame and every pong pushes the deadline out, so a
+// peer that stops answering pings is reaped once the deadline passes. Frames are
+// dispatched as commands in arrival order and answered on the same connection.
+func (c *client) readPump(ctx context.Context) {
+	c.conn.SetReadLimit(maxMessageSize)
+	c.conn.SetReadDeadline(time.Now().Add(idleTimeout))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(idleTimeout))
+	})
+
+	for {
+		_, frame, err := c.conn.ReadMessage()
+		if err != nil {
+			if ne, ok := err.(interface{ Timeout() bool }); ok && ne.Timeout() {
+				log.Printf("User %d (conn %d) idle for %s, reaping", c.userID, c.id, idleTimeout)
+			}
+			return
+		}
+		c.conn.SetReadDeadline(time.Now().Add(idleTimeout))
+		if activityHandler != nil {
+			activityHandler(c.userID)
+		}
+
+		if !c.enqueue(dispatch(ctx, frame)) {
+			c.close(websocket.CloseTryAgainLater, "slow consumer")
+			return
+		}
+	}
+}
+
+// writePump delivers queued messages and pings the peer every pingPeriod until the
+// client is closed or a write fails.
+func (c *client) writePump() {
+	ticker := time.NewTicker(pingPeriod())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+			if err := c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeWait)); err != nil {
+				c.close(websocket.CloseGoingAway, "ping failed")
+				return
+			}
+		case msg := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := c.conn.WriteJSON(msg); err != nil {
+				select {
+				case <-c.done:
+					// closed underneath us; nothing worth logging
+				default:
+					log.Printf("Error sending to user %d (conn %d): %v", c.userID, c.id, err)
+				}
+				c.close(websocket.CloseGoingAway, "write failed")
+				return
+			}
+		}
+	}
+}
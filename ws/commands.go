@@ -0,0 +1,55 @@
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	"DB-Presentation/models"
+)
+
+// Reply frame types sent in response to client commands.
+const (
+	TypeAck   = "ack"
+	TypeError = "error"
+)
+
+// ErrUnknownCommand is returned to clients sending a type with no registered handler.
+var ErrUnknownCommand = errors.New("unknown command")
+
+// CommandFunc handles one inbound command. ctx carries the caller's session
+// (see auth.UserID); the returned value is sent back as the ack's data.
+type CommandFunc func(ctx context.Context, data json.RawMessage) (interface{}, error)
+
+// commands is filled during startup, before any connection is served.
+var commands = make(map[string]CommandFunc)
+
+// HandleCommand registers fn for inbound frames of the given type.
+func HandleCommand(msgType string, fn CommandFunc) {
+	commands[msgType] = fn
+}
+
+// inboundMessage is a client frame: a WSMessage whose data is decoded by the command.
+type inboundMessage struct {
+	models.WSMessage
+	Data json.RawMessage `json:"data"`
+}
+
+// dispatch runs one client frame and builds the ack or error reply.
+func dispatch(ctx context.Context, frame []byte) models.WSMessage {
+	var in inboundMessage
+	if err := json.Unmarshal(frame, &in); err != nil {
+		return models.WSMessage{Type: TypeError, Error: "invalid message"}
+	}
+
+	fn, ok := commands[in.Type]
+	if !ok {
+		return models.WSMessage{ID: in.ID, Type: TypeError, Error: ErrUnknownCommand.Error()}
+	}
+
+	result, err := fn(ctx, in.Data)
+	if err != nil {
+		return models.WSMessage{ID: in.ID, Type: TypeError, Error: err.Error()}
+	}
+	return models.WSMessage{ID: in.ID, Type: TypeAck, Data: result}
+}
@@ -0,0 +1,200 @@
+package ws
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"DB-Presentation/models"
+
+	dbsqlite "DB-Presentation/database/sqlite"
+)
+
+const (
+	// TypeReady is sent once a connection has caught up. Its data carries the user's
+	// latest seq, and resync=true when the requested events are no longer retained
+	// (the client should then reload its state over HTTP).
+	TypeReady = "ready"
+
+	// replayBatch bounds each read of the event log during replay.
+	replayBatch = 200
+
+	// DefaultEventRetention is how long events are kept for replay. Override with WS_EVENT_RETENTION.
+	DefaultEventRetention = 7 * 24 * time.Hour
+)
+
+var eventRetention = DefaultEventRetention
+
+// EventLog stores each user's events under increasing sequence numbers for replay.
+// Instances that share users through a broker must share the log as well, or their
+// sequence numbers diverge and replay after reconnecting elsewhere goes wrong.
+type EventLog interface {
+	// Append stores msg and returns its seq.
+	Append(ctx context.Context, userID int, msg models.WSMessage) (int64, error)
+	// Since returns up to limit events with seq greater than afterSeq, oldest first.
+	Since(ctx context.Context, userID int, afterSeq int64, limit int) ([]models.WSMessage, error)
+	// SeqRange returns the lowest retained and the highest assigned seq (0, 0 if
+	// none). Once events are pruned, lo is above every pruned seq.
+	SeqRange(ctx context.Context, userID int) (lo, hi int64, err error)
+	// Prune deletes events older than the cutoff.
+	Prune(ctx context.Context, olderThan time.Time) (int64, error)
+}
+
+// sqliteEventLog is the default EventLog, kept in this instance's SQLite database.
+// It is only shared by instances that share that database file.
+type sqliteEventLog struct {
+	db *sql.DB
+}
+
+func (l sqliteEventLog) Append(ctx context.Context, userID int, msg models.WSMessage) (int64, error) {
+	return dbsqlite.AppendEvent(l.db, userID, msg)
+}
+
+func (l sqliteEventLog) Since(ctx context.Context, userID int, afterSeq int64, limit int) ([]models.WSMessage, error) {
+	return dbsqlite.EventsSince(l.db, userID, afterSeq, limit)
+}
+
+func (l sqliteEventLog) SeqRange(ctx context.Context, userID int) (int64, int64, error) {
+	return dbsqlite.EventSeqRange(l.db, userID)
+}
+
+func (l sqliteEventLog) Prune(ctx context.Context, olderThan time.Time) (int64, error) {
+	return dbsqlite.PruneEvents(l.db, olderThan)
+}
+
+var eventsMu sync.RWMutex
+var events EventLog
+
+// SetEventLog replaces the event log (the default is the SQLite database passed to
+// Init). Call it during startup, before serving connections.
+func SetEventLog(l EventLog) {
+	eventsMu.Lock()
+	defer eventsMu.Unlock()
+	events = l
+}
+
+func eventLog() EventLog {
+	eventsMu.RLock()
+	defer eventsMu.RUnlock()
+	return events
+}
+
+// eventTimeout bounds each event log call.
+const eventTimeout = 5 * time.Second
+
+// NotifyUser records msg in the user's event log and publishes it, with its seq, to
+// every connection the user has open on any instance. Users that are offline
+// receive it on their next connect. It never blocks on a socket: each connection
+// is written by its own goroutine. Concurrent notifications for a user may be
+// published out of seq order, here or on other instances; connections put them
+// back in order (see client.deliverInOrder).
+func NotifyUser(userID int, msg models.WSMessage) {
+	ctx, cancel := context.WithTimeout(context.Background(), eventTimeout)
+	defer cancel()
+	seq, err := eventLog().Append(ctx, userID, msg)
+	if err != nil {
+		log.Printf("warning: could not persist %s event for user %d: %v", msg.Type, userID, err)
+	} else {
+		msg.Seq = seq
+	}
+
+	publish(models.UserEvent{UserID: userID, Message: msg})
+}
+
+// NotifyUsers sends msg to each of the users, as NotifyUser does.
+func NotifyUsers(userIDs []int, msg models.WSMessage) {
+	for _, id := range userIDs {
+		NotifyUser(id, msg)
+	}
+}
+
+// SendTransient publishes msg to the user's open connections without persisting it.
+// Use it for ephemeral state such as typing indicators.
+func SendTransient(userID int, msg models.WSMessage) {
+	publish(models.UserEvent{UserID: userID, Message: msg})
+}
+
+// replay sends the events after afterSeq (or nothing when afterSeq < 0), then the
+// ready frame, then any live events that arrived meanwhile. It returns false if
+// the client went away.
+func replay(c *client, afterSeq int64) bool {
+	el := eventLog()
+	ctx, cancel := context.WithTimeout(context.Background(), eventTimeout)
+	lo, hi, err := el.SeqRange(ctx, c.userID)
+	cancel()
+	if err != nil {
+		log.Printf("warning: could not read event log for user %d: %v", c.userID, err)
+	}
+
+	resync := false
+	last := hi
+	if afterSeq >= 0 && err == nil {
+		// Pruned past the client's position, or the client is ahead of us (e.g. a reset DB)
+		resync = (lo > afterSeq+1) || afterSeq > hi
+		last = afterSeq
+		for !resync {
+			// A fresh timeout per batch: sending the previous one may have waited on the client
+			ctx, cancel := context.WithTimeout(context.Background(), eventTimeout)
+			events, err := el.Since(ctx, c.userID, last, replayBatch)
+			cancel()
+			if err != nil {
+				log.Printf("warning: replay failed for user %d: %v", c.userID, err)
+				resync = true
+				break
+			}
+			for _, ev := range events {
+				if !c.sendWait(ev) {
+					return false
+				}
+				last = ev.Seq
+			}
+			if len(events) < replayBatch {
+				break
+			}
+		}
+		if resync {
+			last = hi
+		}
+	}
+
+	if !c.sendWait(models.WSMessage{Type: TypeReady, Data: map[string]interface{}{"seq": last, "resync": resync}}) {
+		return false
+	}
+	if err != nil {
+		// Without the log's position, live events are delivered from the first one on
+		last = -1
+	}
+	return c.finishReplay(last)
+}
+
+// parseLastSeq reads ?last_seq=; -1 means the client did not ask for a replay.
+func parseLastSeq(v string) int64 {
+	if v == "" {
+		return -1
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n < 0 {
+		return -1
+	}
+	return n
+}
+
+// pruneEvents periodically drops events older than the retention window.
+func pruneEvents() {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		n, err := eventLog().Prune(ctx, time.Now().Add(-eventRetention))
+		cancel()
+		if err != nil {
+			log.Println("warning: could not prune user events:", err)
+		} else if n > 0 {
+			log.Printf("Pruned %d old user events", n)
+		}
+		<-ticker.C
+	}
+}
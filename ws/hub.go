@@ -0,0 +1,112 @@
+package ws
+
+import (
+	"log"
+	"sync"
+
+	"github.com/gorilla/websocket"
+
+	"DB-Presentation/models"
+)
+
+// hub tracks every open connection, grouped by user. It only guards membership:
+// delivery goes through each client's own send buffer, so the lock is never held
+// while writing to a socket.
+type hub struct {
+	mu     sync.RWMutex
+	users  map[int]map[uint64]*client
+	nextID uint64
+	total  int
+}
+
+func newHub() *hub {
+	return &hub{users: make(map[int]map[uint64]*client)}
+}
+
+// register assigns the client an id and adds it to its user's connection set.
+// It reports whether this is the user's first open connection.
+func (h *hub) register(c *client) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.nextID++
+	c.id = h.nextID
+
+	set, ok := h.users[c.userID]
+	if !ok {
+		set = make(map[uint64]*client)
+		h.users[c.userID] = set
+	}
+	set[c.id] = c
+	h.total++
+	return len(set) == 1
+}
+
+// unregister removes exactly this connection; the user's other connections are untouched.
+// It reports whether that was the user's last open connection.
+func (h *hub) unregister(c *client) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	set, ok := h.users[c.userID]
+	if !ok {
+		return false
+	}
+	if _, ok := set[c.id]; !ok {
+		return false
+	}
+	delete(set, c.id)
+	h.total--
+	if len(set) == 0 {
+		delete(h.users, c.userID)
+		return true
+	}
+	return false
+}
+
+// clientsOf returns a snapshot of a user's connections.
+func (h *hub) clientsOf(userID int) []*client {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	set := h.users[userID]
+	out := make([]*client, 0, len(set))
+	for _, c := range set {
+		out = append(out, c)
+	}
+	return out
+}
+
+// clientsWithSession returns every connection opened with the given session.
+func (h *hub) clientsWithSession(sessionID string) []*client {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	var out []*client
+	for _, set := range h.users {
+		for _, c := range set {
+			if c.sessionID == sessionID {
+				out = append(out, c)
+			}
+		}
+	}
+	return out
+}
+
+// count returns the number of open connections across all users.
+func (h *hub) count() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.total
+}
+
+// broadcast queues msg on every connection of userID without blocking. Connections
+// whose buffer is full are disconnected as slow consumers.
+func (h *hub) broadcast(userID int, msg models.WSMessage) {
+	for _, c := range h.clientsOf(userID) {
+		if !c.deliver(msg) {
+			log.Printf("User %d (conn %d) is not keeping up, disconnecting", userID, c.id)
+			c.close(websocket.CloseTryAgainLater, "slow consumer")
+		}
+	}
+}
@@ -0,0 +1,321 @@
+package ws
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"DB-Presentation/models"
+)
+
+// testServer upgrades every request into a client of its own hub for the user in
+// ?user=. Clients skip replay and unregister once their peer goes away.
+type testServer struct {
+	*httptest.Server
+	hub *hub
+	// pump starts each client's writePump; without it nothing drains the send buffer
+	pump    bool
+	clients chan *client
+}
+
+func newTestServer(t *testing.T, pump bool) *testServer {
+	t.Helper()
+	ts := &testServer{hub: newHub(), pump: pump, clients: make(chan *client, 64)}
+	ts.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		userID, _ := strconv.Atoi(r.URL.Query().Get("user"))
+		c := newClient(userID, "session", conn)
+		c.finishReplay(0)
+		ts.hub.register(c)
+		if ts.pump {
+			go c.writePump()
+		}
+		ts.clients <- c
+
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				break
+			}
+		}
+		ts.hub.unregister(c)
+		c.close(websocket.CloseNormalClosure, "")
+	}))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+// dial opens a connection for userID and waits until the hub has registered it.
+func (ts *testServer) dial(t *testing.T, userID int) (*websocket.Conn, *client) {
+	t.Helper()
+	url := "ws" + strings.TrimPrefix(ts.URL, "http") + "/?user=" + strconv.Itoa(userID)
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	select {
+	case c := <-ts.clients:
+		return conn, c
+	case <-time.After(5 * time.Second):
+		t.Fatal("connection was never registered")
+		return nil, nil
+	}
+}
+
+// readMessage reads one pushed message, failing the test after a second.
+func readMessage(t *testing.T, conn *websocket.Conn) models.WSMessage {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	var msg models.WSMessage
+	if err := conn.ReadJSON(&msg); err != nil {
+		t.Fatal(err)
+	}
+	return msg
+}
+
+// waitFor polls cond for up to five seconds.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for " + what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestBroadcastReachesEveryConnectionOfTheUser(t *testing.T) {
+	ts := newTestServer(t, true)
+	var mine []*websocket.Conn
+	for i := 0; i < 3; i++ {
+		conn, _ := ts.dial(t, 1)
+		mine = append(mine, conn)
+	}
+	other, _ := ts.dial(t, 2)
+
+	ts.hub.broadcast(1, models.WSMessage{Type: "new_message", Data: "hello"})
+
+	for i, conn := range mine {
+		if msg := readMessage(t, conn); msg.Type != "new_message" || msg.Data != "hello" {
+			t.Errorf("connection %d got %+v", i, msg)
+		}
+	}
+	other.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	var msg models.WSMessage
+	if err := other.ReadJSON(&msg); err == nil {
+		t.Errorf("another user's connection got %+v", msg)
+	}
+}
+
+func TestUnregisterDuringBroadcast(t *testing.T) {
+	ts := newTestServer(t, true)
+	var conns []*websocket.Conn
+	for i := 0; i < 8; i++ {
+		conn, _ := ts.dial(t, 1)
+		conns = append(conns, conn)
+	}
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+					ts.hub.broadcast(1, models.WSMessage{Type: "new_message"})
+				}
+			}
+		}()
+	}
+
+	// Peers hang up while broadcasts are running; each server handler unregisters its client
+	for _, conn := range conns {
+		conn.Close()
+		time.Sleep(time.Millisecond)
+	}
+	waitFor(t, "every connection to unregister", func() bool { return ts.hub.count() == 0 })
+	close(stop)
+	wg.Wait()
+
+	if n := len(ts.hub.clientsOf(1)); n != 0 {
+		t.Errorf("user still has %d connections", n)
+	}
+}
+
+func TestSlowConsumerIsClosed(t *testing.T) {
+	// Without a write pump nothing drains the buffer, so it fills up
+	ts := newTestServer(t, false)
+	conn, c := ts.dial(t, 1)
+	fast, _ := ts.dial(t, 2)
+
+	for i := 0; i < sendBufferSize; i++ {
+		ts.hub.broadcast(1, models.WSMessage{Type: "new_message"})
+	}
+	select {
+	case <-c.done:
+		t.Fatal("closed before the buffer was full")
+	default:
+	}
+
+	ts.hub.broadcast(1, models.WSMessage{Type: "new_message"})
+	select {
+	case <-c.done:
+	case <-time.After(time.Second):
+		t.Fatal("slow consumer was not closed")
+	}
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, _, err := conn.ReadMessage()
+	var closeErr *websocket.CloseError
+	if !errors.As(err, &closeErr) || closeErr.Code != websocket.CloseTryAgainLater {
+		t.Errorf("peer got %v, want close %d", err, websocket.CloseTryAgainLater)
+	}
+
+	// Other users' connections are unaffected
+	fast.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if _, _, err := fast.ReadMessage(); errors.As(err, &closeErr) {
+		t.Errorf("another user's connection was closed: %v", err)
+	}
+}
+
+func TestWritePumpPings(t *testing.T) {
+	saved := idleTimeout
+	idleTimeout = 100 * time.Millisecond
+	t.Cleanup(func() { idleTimeout = saved })
+
+	ts := newTestServer(t, true)
+	conn, _ := ts.dial(t, 1)
+
+	pinged := make(chan struct{}, 1)
+	conn.SetPingHandler(func(string) error {
+		select {
+		case pinged <- struct{}{}:
+		default:
+		}
+		return nil
+	})
+	// Control frames are only processed while reading
+	go func() {
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	select {
+	case <-pinged:
+	case <-time.After(time.Second):
+		t.Fatal("no ping within a second")
+	}
+}
+
+func TestWritePumpStopsOnClose(t *testing.T) {
+	ts := newTestServer(t, false)
+	conn, c := ts.dial(t, 1)
+
+	stopped := make(chan struct{})
+	go func() {
+		c.writePump()
+		close(stopped)
+	}()
+
+	ts.hub.broadcast(1, models.WSMessage{Type: "new_message"})
+	if msg := readMessage(t, conn); msg.Type != "new_message" {
+		t.Fatalf("got %+v", msg)
+	}
+
+	c.close(websocket.CloseGoingAway, "bye")
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("writePump kept running after close")
+	}
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, _, err := conn.ReadMessage()
+	var closeErr *websocket.CloseError
+	if !errors.As(err, &closeErr) || closeErr.Code != websocket.CloseGoingAway || closeErr.Text != "bye" {
+		t.Errorf("peer got %v, want close %d", err, websocket.CloseGoingAway)
+	}
+
+	// Messages for a closed client are dropped, not reported as a slow consumer
+	if !c.enqueue(models.WSMessage{Type: "new_message"}) {
+		t.Error("enqueue on a closed client reported a full buffer")
+	}
+}
+
+// fakeEventLog serves Since from a fixed list of events.
+type fakeEventLog struct {
+	EventLog
+	events []models.WSMessage
+}
+
+func (l fakeEventLog) Since(ctx context.Context, userID int, afterSeq int64, limit int) ([]models.WSMessage, error) {
+	var out []models.WSMessage
+	for _, ev := range l.events {
+		if ev.Seq > afterSeq && len(out) < limit {
+			out = append(out, ev)
+		}
+	}
+	return out, nil
+}
+
+func TestEventsAreDeliveredInSeqOrder(t *testing.T) {
+	old, oldWait := eventLog(), gapWait
+	t.Cleanup(func() { SetEventLog(old); gapWait = oldWait })
+	// Seq 4 never made it into the log; 5 did, but its publish was lost
+	SetEventLog(fakeEventLog{events: []models.WSMessage{{Type: "message", Seq: 5}}})
+	gapWait = 50 * time.Millisecond
+
+	ts := newTestServer(t, true)
+	conn, c := ts.dial(t, 1)
+
+	for _, seq := range []int64{1, 3, 2, 2, 6} {
+		ts.hub.broadcast(1, models.WSMessage{Type: "message", Seq: seq})
+	}
+	ts.hub.broadcast(1, models.WSMessage{Type: "typing_start"})
+
+	if msg := readMessage(t, conn); msg.Seq != 1 {
+		t.Fatalf("got seq %d first, want 1", msg.Seq)
+	}
+	if msg := readMessage(t, conn); msg.Seq != 2 {
+		t.Fatalf("got seq %d, want 2", msg.Seq)
+	}
+	if msg := readMessage(t, conn); msg.Seq != 3 {
+		t.Fatalf("got seq %d, want 3", msg.Seq)
+	}
+	// Events without a seq are not held back behind a gap
+	if msg := readMessage(t, conn); msg.Type != "typing_start" {
+		t.Fatalf("got %s, want the typing event", msg.Type)
+	}
+	for _, want := range []int64{5, 6} {
+		if msg := readMessage(t, conn); msg.Seq != want {
+			t.Fatalf("after the gap: got seq %d, want %d", msg.Seq, want)
+		}
+	}
+
+	c.replayMu.Lock()
+	defer c.replayMu.Unlock()
+	if c.lastSeq != 6 || len(c.pending) != 0 || c.gapTimer != nil {
+		t.Errorf("got lastSeq %d, %d pending, timer %v; want 6, none, nil", c.lastSeq, len(c.pending), c.gapTimer)
+	}
+}
@@ -0,0 +1,113 @@
+package ws
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"DB-Presentation/auth"
+	"DB-Presentation/models"
+	"DB-Presentation/utils"
+)
+
+// sseRetry is the reconnect delay, in milliseconds, suggested to EventSource clients.
+const sseRetry = 3000
+
+// HandleEvents streams the same events as HandleWebSocket as Server-Sent Events,
+// for clients whose network refuses websocket upgrades. It is receive-only:
+// commands go through the HTTP API instead.
+//
+// The session token comes from the Authorization header or ?token= (EventSource
+// cannot set headers). Each persisted event carries its seq as the SSE id, so a
+// reconnecting EventSource resumes through Last-Event-ID; ?last_seq= does the same
+// for the first connect. A "ready" event follows the replay, as on the websocket.
+func HandleEvents(w http.ResponseWriter, r *http.Request) {
+	token := auth.TokenFromRequest(r)
+	if token == "" {
+		token = r.URL.Query().Get("token")
+	}
+	if token == "" {
+		utils.SendJSON(w, models.Response{Success: false, Message: "Authentication required"}, http.StatusUnauthorized)
+		return
+	}
+
+	session, err := auth.Validate(token)
+	if err != nil {
+		utils.SendJSON(w, models.Response{Success: false, Message: "Invalid or expired session"}, http.StatusUnauthorized)
+		return
+	}
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	// Stop nginx-style proxies from buffering the stream
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "retry: %d\n\n", sseRetry)
+	if err := rc.Flush(); err != nil {
+		log.Println("SSE not supported by response writer:", err)
+		return
+	}
+
+	c := newClient(session.UserID, session.ID, nil)
+	defer join(c, session)()
+
+	// This goroutine is the stream's writer, so replay has to run beside it
+	afterSeq := parseLastSeq(r.Header.Get("Last-Event-ID"))
+	if afterSeq < 0 {
+		afterSeq = parseLastSeq(r.URL.Query().Get("last_seq"))
+	}
+	go func() {
+		if !replay(c, afterSeq) {
+			c.close(websocket.CloseTryAgainLater, "slow consumer")
+		}
+	}()
+
+	// Comments keep proxies from timing out an idle stream
+	ticker := time.NewTicker(pingPeriod())
+	defer ticker.Stop()
+
+	for {
+		var err error
+		select {
+		case <-c.done:
+			return
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+			rc.SetWriteDeadline(time.Now().Add(writeWait))
+			_, err = fmt.Fprint(w, ": ping\n\n")
+		case msg := <-c.send:
+			rc.SetWriteDeadline(time.Now().Add(writeWait))
+			err = writeEvent(w, msg)
+		}
+		if err == nil {
+			err = rc.Flush()
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// writeEvent writes msg as one SSE event. Only persisted events get an id, so
+// Last-Event-ID always names a seq the event log can resume from.
+func writeEvent(w http.ResponseWriter, msg models.WSMessage) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		log.Printf("warning: could not encode %s event: %v", msg.Type, err)
+		return nil
+	}
+	if msg.Seq != 0 {
+		if _, err := fmt.Fprintf(w, "id: %d\n", msg.Seq); err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprintf(w, "data: %s\n\n", data)
+	return err
+}
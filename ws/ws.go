@@ -1,73 +1,164 @@
 package ws
 
 import (
+	"context"
+	"database/sql"
 	"log"
 	"net/http"
-	"strconv"
-	"sync"
+	"os"
+	"time"
 
-	"github.com/gorilla/mux"
 	"github.com/gorilla/websocket"
 
+	"DB-Presentation/auth"
 	"DB-Presentation/models"
 )
 
+// bearerProtocol is the Sec-WebSocket-Protocol marker that precedes a session token,
+// for browsers that would rather not put the token in the URL: new WebSocket(url, ["bearer", token]).
+const bearerProtocol = "bearer"
+
 var upgrader = websocket.Upgrader{
 	CheckOrigin: func(r *http.Request) bool { return true },
 }
 
-var clients = make(map[int]*websocket.Conn)
-var clientsMux sync.Mutex
+// DefaultIdleTimeout is how long a connection may stay silent (no frames, no pongs)
+// before it is considered dead. Override with WS_IDLE_TIMEOUT.
+const DefaultIdleTimeout = 60 * time.Second
+
+var clients = newHub()
+var idleTimeout = DefaultIdleTimeout
+
+// presenceHandler is told when a user's first connection opens and their last one closes.
+var presenceHandler func(userID int, online bool)
+
+// activityHandler is told whenever a user sends a frame, i.e. is actively using a client.
+var activityHandler func(userID int)
+
+// Init keeps the event log in db (see SetEventLog) and reads websocket settings from
+// the environment. It also starts pruning events past their retention.
+func Init(db *sql.DB) {
+	SetEventLog(sqliteEventLog{db: db})
+
+	if v := os.Getenv("WS_IDLE_TIMEOUT"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d > time.Second {
+			idleTimeout = d
+		} else {
+			log.Printf("warning: invalid WS_IDLE_TIMEOUT %q, using %s", v, DefaultIdleTimeout)
+		}
+	}
+	if v := os.Getenv("WS_EVENT_RETENTION"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			eventRetention = d
+		} else {
+			log.Printf("warning: invalid WS_EVENT_RETENTION %q, using %s", v, DefaultEventRetention)
+		}
+	}
+
+	if err := broker.Subscribe(deliverEvent); err != nil {
+		log.Println("warning: could not subscribe to local broker:", err)
+	}
+
+	go pruneEvents()
+}
+
+// SetPresenceHandler registers fn to be called when a user comes online (first
+// connection) or goes offline (last connection closed or reaped).
+func SetPresenceHandler(fn func(userID int, online bool)) {
+	presenceHandler = fn
+}
+
+// SetActivityHandler registers fn to be called for every frame a user's client
+// sends. Pongs do not count: they only prove the connection is alive.
+func SetActivityHandler(fn func(userID int)) {
+	activityHandler = fn
+}
+
+// pingPeriod leaves room for a pong to arrive before the read deadline expires.
+func pingPeriod() time.Duration {
+	return idleTimeout * 9 / 10
+}
 
-// HandleWebSocket upgrades and manages a user's websocket connection.
+// HandleWebSocket authenticates and upgrades a user's websocket connection.
+// The user is taken from the session token (?token= or the "bearer" subprotocol);
+// requests without a valid session are refused with 401 before upgrading.
+// With ?last_seq=N the events after N are replayed before live events; every
+// connection then receives a "ready" frame.
 func HandleWebSocket(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	userIDStr := vars["userId"]
-	userID, err := strconv.Atoi(userIDStr)
+	token, protocol := tokenFromRequest(r)
+	if token == "" {
+		http.Error(w, "Authentication required", http.StatusUnauthorized)
+		return
+	}
+
+	session, err := auth.Validate(token)
 	if err != nil {
-		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		http.Error(w, "Invalid or expired session", http.StatusUnauthorized)
 		return
 	}
+	userID := session.UserID
 
-	conn, err := upgrader.Upgrade(w, r, nil)
+	var header http.Header
+	if protocol != "" {
+		header = http.Header{"Sec-WebSocket-Protocol": {protocol}}
+	}
+	conn, err := upgrader.Upgrade(w, r, header)
 	if err != nil {
 		log.Println(err)
 		return
 	}
 
-	clientsMux.Lock()
-	clients[userID] = conn
-	clientsMux.Unlock()
+	c := newClient(userID, session.ID, conn)
+	defer join(c, session)()
+	go c.writePump()
 
-	log.Printf("User %d connected. Total clients: %d", userID, len(clients))
+	if !replay(c, parseLastSeq(r.URL.Query().Get("last_seq"))) {
+		c.close(websocket.CloseTryAgainLater, "slow consumer")
+		return
+	}
 
-	defer func() {
-		clientsMux.Lock()
-		delete(clients, userID)
-		clientsMux.Unlock()
-		conn.Close()
-		log.Printf("User %d disconnected. Total clients: %d", userID, len(clients))
-	}()
+	c.readPump(auth.WithSession(context.Background(), session))
+}
+
+// join registers c with the hub and closes it when the session runs out. The
+// returned func unregisters and closes it; presence follows the user's first and
+// last connection, whatever the transport.
+func join(c *client, session models.Session) func() {
+	if clients.register(c) && presenceHandler != nil {
+		presenceHandler(c.userID, true)
+	}
+	log.Printf("User %d connected (conn %d). Total connections: %d", c.userID, c.id, clients.count())
+
+	expiry := time.AfterFunc(time.Until(session.ExpiresAt), func() {
+		c.close(websocket.ClosePolicyViolation, "session expired")
+	})
 
-	for {
-		_, _, err := conn.ReadMessage()
-		if err != nil {
-			break
+	return func() {
+		expiry.Stop()
+		last := clients.unregister(c)
+		c.close(websocket.CloseNormalClosure, "")
+		log.Printf("User %d disconnected (conn %d). Total connections: %d", c.userID, c.id, clients.count())
+		if last && presenceHandler != nil {
+			presenceHandler(c.userID, false)
 		}
 	}
 }
 
-// NotifyUser sends a WSMessage to a connected user (if present)
-func NotifyUser(userID int, msg models.WSMessage) {
-	clientsMux.Lock()
-	defer clientsMux.Unlock()
-
-	if conn, ok := clients[userID]; ok {
-		err := conn.WriteJSON(msg)
-		if err != nil {
-			log.Printf("Error sending to user %d: %v", userID, err)
-			conn.Close()
-			delete(clients, userID)
-		}
+// CloseSession force-closes any socket or event stream opened with the given session, on every
+// instance, e.g. after logout.
+func CloseSession(sessionID string) {
+	publish(models.UserEvent{Message: models.WSMessage{Type: typeSessionRevoked, Data: sessionID}})
+}
+
+// tokenFromRequest returns the session token and, when it came from the subprotocol
+// header, the protocol the server must echo back.
+func tokenFromRequest(r *http.Request) (token, protocol string) {
+	if t := r.URL.Query().Get("token"); t != "" {
+		return t, ""
+	}
+	protocols := websocket.Subprotocols(r)
+	if len(protocols) == 2 && protocols[0] == bearerProtocol {
+		return protocols[1], bearerProtocol
 	}
+	return "", ""
 }